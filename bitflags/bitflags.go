@@ -1,5 +1,9 @@
 package bitflags
 
+import (
+	"slices"
+)
+
 type BitsBlock uint64
 
 const (
@@ -16,12 +20,13 @@ const (
 	h01 = BitsBlock(0x0101010101010101) //the sum of 256 to the power of 0,1,2,3...
 )
 
-func (b BitsBlock) Set(pos uint) BitsBlock          { return 1<<pos | b }
-func (b BitsBlock) Clear(pos uint) BitsBlock        { return ^(1 << pos) & b }
-func (b BitsBlock) Toggle(pos uint) BitsBlock       { return 1<<pos ^ b }
-func (b BitsBlock) Has(pos uint) bool               { return 1<<pos&b != 0 }
-func (b BitsBlock) Union(o BitsBlock) BitsBlock     { return b | o }
-func (b BitsBlock) Intersect(o BitsBlock) BitsBlock { return b & o }
+func (b BitsBlock) Set(pos uint) BitsBlock           { return 1<<pos | b }
+func (b BitsBlock) Clear(pos uint) BitsBlock         { return ^(1 << pos) & b }
+func (b BitsBlock) Toggle(pos uint) BitsBlock        { return 1<<pos ^ b }
+func (b BitsBlock) Has(pos uint) bool                { return 1<<pos&b != 0 }
+func (b BitsBlock) Union(o BitsBlock) BitsBlock      { return b | o }
+func (b BitsBlock) Intersect(o BitsBlock) BitsBlock  { return b & o }
+func (b BitsBlock) Difference(o BitsBlock) BitsBlock { return b &^ o }
 func (b BitsBlock) Popcount() uint {
 	b -= (b >> 1) & m1             //put count of each 2 bits into those 2 bits
 	b = (b & m2) + ((b >> 2) & m2) //put count of each 4 bits into those 4 bits
@@ -65,10 +70,11 @@ func (b BitsBlock) Lsb() BitsBlock      { return b & -b }      // Clear all exce
 func (b BitsBlock) ClearLsb() BitsBlock { return b ^ b.Lsb() } // Clear lowest set bit
 
 const (
-	BitFlagsCap = BitsBlockSize * BitsBlockSize
+	BitsChunkCap = BitsBlockSize * BitsBlockSize
+	BitFlagsCap  = uint64(1 << 32) // typed so that it compiles where uint has 32 bits
 )
 
-type BitFlags struct { // like BitBlock but can hold up ints in [0, 64*64) range
+type BitsChunk struct { // like BitBlock but can hold up ints in [0, 64*64) range
 	activeMask BitsBlock
 	blocks     []BitsBlock
 }
@@ -79,50 +85,81 @@ func divmod(numerator, denominator uint) (quotient, remainder uint) {
 	return
 }
 
-func (b *BitFlags) resizeBlocks(blockIndex uint) (newIndex uint) {
-	if !b.activeMask.Has(blockIndex) {
-		b.blocks = append(b.blocks, BitsBlockEmpty)
-		b.activeMask = b.activeMask.Set(blockIndex)
+func (c *BitsChunk) resizeBlocks(blockMultiplier uint) (blockIndex uint) {
+	// blocks are kept in activeMask order, so index of a block is a number of active blocks below it
+	lowerMask := c.activeMask & (BitsBlock(1)<<blockMultiplier - 1)
+	blockIndex = lowerMask.Popcount()
+	if !c.activeMask.Has(blockMultiplier) {
+		c.blocks = append(c.blocks, BitsBlockEmpty)
+		copy(c.blocks[blockIndex+1:], c.blocks[blockIndex:])
+		c.blocks[blockIndex] = BitsBlockEmpty
+		c.activeMask = c.activeMask.Set(blockMultiplier)
 	}
-	return uint(len(b.blocks) - 1)
+	return blockIndex
 }
-func (b *BitFlags) Set(positions ...uint) { // TODO Set assumes increasing arguments order
+func (c *BitsChunk) Set(positions ...uint) {
 	for _, pos := range positions {
+		if pos >= BitsChunkCap {
+			continue
+		}
 		blockMultiplier, blockPos := divmod(pos, BitsBlockSize)
-		blockIndex := b.resizeBlocks(blockMultiplier)
-		b.blocks[blockIndex] = b.blocks[blockIndex].Set(blockPos)
+		blockIndex := c.resizeBlocks(blockMultiplier)
+		c.blocks[blockIndex] = c.blocks[blockIndex].Set(blockPos)
 	}
 }
-func (b BitFlags) Traverse() <-chan uint {
-	sizeGuess := b.activeMask.Popcount() * 32
+func (c BitsChunk) Has(pos uint) bool {
+	blockMultiplier, blockPos := divmod(pos, BitsBlockSize)
+	if blockMultiplier >= BitsBlockSize || !c.activeMask.Has(blockMultiplier) {
+		return false
+	}
+	blockIndex := (c.activeMask & (BitsBlock(1)<<blockMultiplier - 1)).Popcount()
+	return c.blocks[blockIndex].Has(blockPos)
+}
+func (c BitsChunk) IsEmpty() bool { return c.activeMask == BitsBlockEmpty }
+func (c BitsChunk) clone() BitsChunk {
+	return BitsChunk{activeMask: c.activeMask, blocks: slices.Clone(c.blocks)}
+}
+func (c BitsChunk) Popcount() uint {
+	count := uint(0)
+	for _, block := range c.blocks {
+		count += block.Popcount()
+	}
+	return count
+}
+func (c BitsChunk) each(yield func(uint)) {
+	blockIndex := 0
+	for mask := c.activeMask; mask != 0; mask = mask.ClearLsb() {
+		blockMultiplier := (mask.Lsb() - 1).Popcount() // position of the lowest active block
+		for block := c.blocks[blockIndex]; block != 0; block = block.ClearLsb() {
+			yield(blockMultiplier*BitsBlockSize + (block.Lsb() - 1).Popcount())
+		}
+		blockIndex++
+	}
+}
+func (c BitsChunk) Traverse() <-chan uint {
+	sizeGuess := c.activeMask.Popcount() * 32
 	res := make(chan uint, sizeGuess)
 
 	go func() {
-		blockIndex := 0
-		for blockMultiplier := range b.activeMask.Traverse() {
-			for blockPos := range b.blocks[blockIndex].Traverse() {
-				res <- blockMultiplier*BitsBlockSize + blockPos
-			}
-			blockIndex++
-		}
+		c.each(func(pos uint) { res <- pos })
 		close(res)
 	}()
 
 	return res
 }
-func (b BitFlags) Union(o BitFlags) BitFlags {
-	maskUnion := b.activeMask.Union(o.activeMask)
+func (c BitsChunk) Union(o BitsChunk) BitsChunk {
+	maskUnion := c.activeMask.Union(o.activeMask)
 
-	res := BitFlags{
+	res := BitsChunk{
 		activeMask: maskUnion,
 		blocks:     make([]BitsBlock, maskUnion.Popcount()),
 	}
 
-	bi, oi, resi := 0, 0, 0
+	ci, oi, resi := 0, 0, 0
 	for unionIdx := range maskUnion.Traverse() {
-		if b.activeMask.Has(unionIdx) {
-			res.blocks[resi] = res.blocks[resi].Union(b.blocks[bi])
-			bi++
+		if c.activeMask.Has(unionIdx) {
+			res.blocks[resi] = res.blocks[resi].Union(c.blocks[ci])
+			ci++
 		}
 		if o.activeMask.Has(unionIdx) {
 			res.blocks[resi] = res.blocks[resi].Union(o.blocks[oi])
@@ -134,27 +171,27 @@ func (b BitFlags) Union(o BitFlags) BitFlags {
 	return res
 }
 
-func (b BitFlags) Intersect(o BitFlags) BitFlags {
-	maskIntersect := b.activeMask.Intersect(o.activeMask)
-	maskUnion := b.activeMask.Union(o.activeMask)
+func (c BitsChunk) Intersect(o BitsChunk) BitsChunk {
+	maskIntersect := c.activeMask.Intersect(o.activeMask)
+	maskUnion := c.activeMask.Union(o.activeMask)
 
-	res := BitFlags{
+	res := BitsChunk{
 		activeMask: maskIntersect,
 		blocks:     make([]BitsBlock, 0, maskIntersect.Popcount()),
 	}
 
-	bi, oi := 0, 0
+	ci, oi := 0, 0
 	for unionIdx := range maskUnion.Traverse() {
 		if maskIntersect.Has(unionIdx) {
-			blockIntersect := b.blocks[bi].Intersect(o.blocks[oi])
+			blockIntersect := c.blocks[ci].Intersect(o.blocks[oi])
 			if blockIntersect == 0 {
 				res.activeMask = res.activeMask.Clear(unionIdx)
 			} else {
 				res.blocks = append(res.blocks, blockIntersect)
 			}
 		}
-		if b.activeMask.Has(unionIdx) {
-			bi++
+		if c.activeMask.Has(unionIdx) {
+			ci++
 		}
 		if o.activeMask.Has(unionIdx) {
 			oi++
@@ -164,6 +201,163 @@ func (b BitFlags) Intersect(o BitFlags) BitFlags {
 	return res
 }
 
+func (c BitsChunk) Difference(o BitsChunk) BitsChunk {
+	res := BitsChunk{
+		activeMask: c.activeMask,
+		blocks:     make([]BitsBlock, 0, c.activeMask.Popcount()),
+	}
+
+	ci := 0
+	for idx := range c.activeMask.Traverse() {
+		blockDifference := c.blocks[ci]
+		if o.activeMask.Has(idx) {
+			oi := (o.activeMask & (BitsBlock(1)<<idx - 1)).Popcount()
+			blockDifference = blockDifference.Difference(o.blocks[oi])
+		}
+		if blockDifference == 0 {
+			res.activeMask = res.activeMask.Clear(idx)
+		} else {
+			res.blocks = append(res.blocks, blockDifference)
+		}
+		ci++
+	}
+
+	return res
+}
+
+type BitFlags struct { // holds ints in [0, 2^32) range as sorted chunks of BitsChunkCap ints each
+	keys   []uint32
+	chunks []BitsChunk
+}
+
+func (b *BitFlags) resizeChunks(key uint32) (chunkIndex int) {
+	chunkIndex, found := slices.BinarySearch(b.keys, key)
+	if !found {
+		b.keys = slices.Insert(b.keys, chunkIndex, key)
+		b.chunks = slices.Insert(b.chunks, chunkIndex, BitsChunk{})
+	}
+	return chunkIndex
+}
+func (b *BitFlags) Set(positions ...uint) {
+	for _, pos := range positions {
+		if uint64(pos) >= BitFlagsCap {
+			continue
+		}
+		key, chunkPos := divmod(pos, BitsChunkCap)
+		chunkIndex := b.resizeChunks(uint32(key))
+		b.chunks[chunkIndex].Set(chunkPos)
+	}
+}
+func (b BitFlags) Has(pos uint) bool {
+	if uint64(pos) >= BitFlagsCap {
+		return false
+	}
+	key, chunkPos := divmod(pos, BitsChunkCap)
+	if chunkIndex, found := slices.BinarySearch(b.keys, uint32(key)); found {
+		return b.chunks[chunkIndex].Has(chunkPos)
+	}
+	return false
+}
+func (b BitFlags) IsEmpty() bool { return len(b.keys) == 0 }
+func (b BitFlags) Popcount() uint {
+	count := uint(0)
+	for _, chunk := range b.chunks {
+		count += chunk.Popcount()
+	}
+	return count
+}
+func (b BitFlags) Traverse() <-chan uint {
+	res := make(chan uint, BitsChunkCap/2)
+
+	go func() {
+		for chunkIndex, key := range b.keys {
+			offset := uint(key) * BitsChunkCap
+			b.chunks[chunkIndex].each(func(pos uint) { res <- offset + pos })
+		}
+		close(res)
+	}()
+
+	return res
+}
+func (b BitFlags) Union(o BitFlags) BitFlags {
+	res := BitFlags{
+		keys:   make([]uint32, 0, len(b.keys)+len(o.keys)),
+		chunks: make([]BitsChunk, 0, len(b.chunks)+len(o.chunks)),
+	}
+
+	bi, oi := 0, 0
+	for bi < len(b.keys) || oi < len(o.keys) {
+		switch {
+		case oi == len(o.keys) || bi < len(b.keys) && b.keys[bi] < o.keys[oi]:
+			res.keys = append(res.keys, b.keys[bi])
+			res.chunks = append(res.chunks, b.chunks[bi].clone())
+			bi++
+		case bi == len(b.keys) || o.keys[oi] < b.keys[bi]:
+			res.keys = append(res.keys, o.keys[oi])
+			res.chunks = append(res.chunks, o.chunks[oi].clone())
+			oi++
+		default:
+			res.keys = append(res.keys, b.keys[bi])
+			res.chunks = append(res.chunks, b.chunks[bi].Union(o.chunks[oi]))
+			bi++
+			oi++
+		}
+	}
+
+	return res
+}
+
+func (b BitFlags) Intersect(o BitFlags) BitFlags {
+	res := BitFlags{}
+
+	bi, oi := 0, 0
+	for bi < len(b.keys) && oi < len(o.keys) {
+		switch {
+		case b.keys[bi] < o.keys[oi]:
+			bi++
+		case o.keys[oi] < b.keys[bi]:
+			oi++
+		default:
+			chunkIntersect := b.chunks[bi].Intersect(o.chunks[oi])
+			if !chunkIntersect.IsEmpty() {
+				res.keys = append(res.keys, b.keys[bi])
+				res.chunks = append(res.chunks, chunkIntersect)
+			}
+			bi++
+			oi++
+		}
+	}
+
+	return res
+}
+
+// Difference returns flags set in b and not set in o.
+func (b BitFlags) Difference(o BitFlags) BitFlags {
+	res := BitFlags{}
+
+	oi := 0
+	for bi, key := range b.keys {
+		for oi < len(o.keys) && o.keys[oi] < key {
+			oi++
+		}
+		chunkDifference := b.chunks[bi].clone()
+		if oi < len(o.keys) && o.keys[oi] == key {
+			chunkDifference = b.chunks[bi].Difference(o.chunks[oi])
+		}
+		if !chunkDifference.IsEmpty() {
+			res.keys = append(res.keys, key)
+			res.chunks = append(res.chunks, chunkDifference)
+		}
+	}
+
+	return res
+}
+
+// Complement returns flags of universe not set in b.
+func (b BitFlags) Complement(universe BitFlags) BitFlags {
+	return universe.Difference(b)
+}
+
 // func (b BitFlags) Traverse() []uint {
 // 	sizeGuess := b.activeMask.Popcount() * 32
 // 	res := make([]uint, 0, sizeGuess)
@@ -121,6 +121,16 @@ func TestCorrectlyIntersectBitFlags(t *testing.T) {
 	}
 }
 
+// BitsBlock: Check if difference of bit flags is correct.
+func TestCorrectlyDifferenceBitFlags(t *testing.T) {
+	b := BitsBlock(0b1101)
+
+	u := b.Difference(0b0110)
+	if u != 0b1001 {
+		t.Fatalf("Expected difference to be '1001', got '%04b'.\n", u)
+	}
+}
+
 // BitsBlock: Check if correctly counts set bits.
 func TestCorrectlyCountSetBits(t *testing.T) {
 	assert := func(b BitsBlock, popcount uint) {
@@ -219,13 +229,30 @@ func compareBitsBlocks(a, b []BitsBlock) bool {
 	return true
 }
 
-func compareBitFlags(a, b BitFlags) bool {
+func compareBitsChunks(a, b BitsChunk) bool {
 	if a.activeMask != b.activeMask {
 		return false
 	}
 	return compareBitsBlocks(a.blocks, b.blocks)
 }
 
+func compareBitFlags(a, b BitFlags) bool {
+	if len(a.keys) != len(b.keys) || len(a.chunks) != len(b.chunks) {
+		return false
+	}
+	for i, key := range a.keys {
+		if b.keys[i] != key || !compareBitsChunks(a.chunks[i], b.chunks[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// chunkFlags wraps a single chunk holding ints in [0, 64*64) range.
+func chunkFlags(chunk BitsChunk) BitFlags {
+	return BitFlags{keys: []uint32{0}, chunks: []BitsChunk{chunk}}
+}
+
 // BitFlags: Check if correctly union bits flags.
 func TestUnionBitFlags(t *testing.T) {
 	assert := func(expected, actual BitFlags) {
@@ -238,26 +265,26 @@ func TestUnionBitFlags(t *testing.T) {
 
 	a, b := BitFlags{}, BitFlags{}
 	a.Set(0)
-	assert(a.Union(b), BitFlags{activeMask: 1, blocks: []BitsBlock{1}})
+	assert(a.Union(b), chunkFlags(BitsChunk{activeMask: 1, blocks: []BitsBlock{1}}))
 
 	a, b = BitFlags{}, BitFlags{}
 	a.Set(1)
-	assert(a.Union(b), BitFlags{activeMask: 1, blocks: []BitsBlock{2}})
+	assert(a.Union(b), chunkFlags(BitsChunk{activeMask: 1, blocks: []BitsBlock{2}}))
 
 	a, b = BitFlags{}, BitFlags{}
 	a.Set(2)
 	b.Set(3)
-	assert(a.Union(b), BitFlags{activeMask: 1, blocks: []BitsBlock{0b1100}})
+	assert(a.Union(b), chunkFlags(BitsChunk{activeMask: 1, blocks: []BitsBlock{0b1100}}))
 
 	a, b = BitFlags{}, BitFlags{}
 	a.Set(3)
 	b.Set(3)
-	assert(a.Union(b), BitFlags{activeMask: 1, blocks: []BitsBlock{0b1000}})
+	assert(a.Union(b), chunkFlags(BitsChunk{activeMask: 1, blocks: []BitsBlock{0b1000}}))
 
 	a, b = BitFlags{}, BitFlags{}
 	a.Set(2, 64, 64*2+1, 64*3+18, 64*3+20, 64*64-1)
 	b.Set(3, 63, 64*4+1, 64*5+2)
-	assert(a.Union(b), BitFlags{
+	assert(a.Union(b), chunkFlags(BitsChunk{
 		activeMask: 0b10000000_00000000_00000000_00000000_00000000_00000000_00000000_00111111, // 64*0, 64*1, 64*2, 64*3, 64*4, 64*5, 64*64
 		blocks: []BitsBlock{
 			0b10000000_00000000_00000000_00000000_00000000_00000000_00000000_00001100, // 2, 3, 63
@@ -267,7 +294,7 @@ func TestUnionBitFlags(t *testing.T) {
 			0b0010,                       // 64*4+1,
 			0b0100,                       // 64*5+2
 			0b10000000_00000000_00000000_00000000_00000000_00000000_00000000_00000000, // 64*64-1
-		}})
+		}}))
 }
 
 // BitFlags: Check for correct bits flags intersections.
@@ -296,16 +323,123 @@ func TestIntersectBitFlags(t *testing.T) {
 	a, b = BitFlags{}, BitFlags{}
 	a.Set(66)
 	b.Set(66)
-	assert(a.Intersect(b), BitFlags{activeMask: 0b10, blocks: []BitsBlock{0b0100}})
+	assert(a.Intersect(b), chunkFlags(BitsChunk{activeMask: 0b10, blocks: []BitsBlock{0b0100}}))
 
 	a, b = BitFlags{}, BitFlags{}
 	a.Set(2, 64, 64*2+1, 64*3+18, 64*3+20, 64*5+2, 64*64-1)
 	b.Set(3, 63, 64*2+1, 64*3+18, 64*4+1, 64*5+2)
-	assert(a.Intersect(b), BitFlags{
+	assert(a.Intersect(b), chunkFlags(BitsChunk{
 		activeMask: 0b0010_1100, // 64*2, 64*3, 64*5
 		blocks: []BitsBlock{
 			0b0010,                   // 64*2+1,
 			0b0100_00000000_00000000, // 64*3+18,
 			0b0100,                   // 64*5+2
-		}})
+		}}))
+}
+
+// BitFlags: Check if positions beyond single chunk capacity are set and traversed in order.
+func TestSetAndTraverseLargeBitFlags(t *testing.T) {
+	f := BitFlags{}
+	last := uint(BitFlagsCap - 1)
+	f.Set(last, 64*64*1000+7, 5, 64*64, 64*64-1, 1_000_000)
+
+	expected := []uint{5, 64*64 - 1, 64 * 64, 1_000_000, 64*64*1000 + 7, last}
+	if !compareRanges(f.Traverse(), expected) {
+		t.Fatalf("Expected range different than actual:\n%v\n", expected)
+	}
+	if len(f.keys) != 5 {
+		t.Fatalf("Expected 5 chunks, got %d.\n", len(f.keys))
+	}
+	for _, pos := range expected {
+		if !f.Has(pos) {
+			t.Fatalf("Expected position %d to be set.\n", pos)
+		}
+	}
+	if f.Has(6) || f.Has(64*64+1) || (last+1 != 0 && f.Has(last+1)) {
+		t.Fatalf("Expected unset positions not to be set.\n")
+	}
+	if f.Popcount() != uint(len(expected)) {
+		t.Fatalf("Expected %d bits to be set, got %d.\n", len(expected), f.Popcount())
+	}
+}
+
+// BitFlags: Check if Set does not depend on arguments order.
+func TestSetUnorderedBitFlags(t *testing.T) {
+	a, b := BitFlags{}, BitFlags{}
+	a.Set(64*3+1, 2, 64+5, 64*3)
+	b.Set(2, 64+5, 64*3, 64*3+1)
+
+	if !compareBitFlags(a, b) {
+		t.Fatalf("Expected BitFlags to be equal:\n%v\n%v\n", a, b)
+	}
+}
+
+// BitFlags: Check union and intersection of bit flags spanning multiple chunks.
+func TestUnionAndIntersectLargeBitFlags(t *testing.T) {
+	a, b := BitFlags{}, BitFlags{}
+	a.Set(1, 64*64+1, 64*64*7+3, 4_000_000_000)
+	b.Set(2, 64*64+1, 64*64*9, 4_000_000_000)
+
+	union := []uint{1, 2, 64*64 + 1, 64*64*7 + 3, 64 * 64 * 9, 4_000_000_000}
+	if !compareRanges(a.Union(b).Traverse(), union) {
+		t.Fatalf("Expected union different than actual:\n%v\n", union)
+	}
+
+	intersect := []uint{64*64 + 1, 4_000_000_000}
+	if !compareRanges(a.Intersect(b).Traverse(), intersect) {
+		t.Fatalf("Expected intersection different than actual:\n%v\n", intersect)
+	}
+
+	if !a.Intersect(BitFlags{}).IsEmpty() {
+		t.Fatalf("Expected intersection with empty BitFlags to be empty.\n")
+	}
+
+	u := a.Union(BitFlags{})
+	u.Set(3)
+	if a.Has(3) {
+		t.Fatalf("Expected union not to share blocks with its arguments.\n")
+	}
+}
+
+// BitFlags: Check difference and complement of bit flags spanning multiple chunks.
+func TestDifferenceAndComplementBitFlags(t *testing.T) {
+	assert := func(actual, expected BitFlags) {
+		if !compareBitFlags(expected, actual) {
+			t.Fatalf("Expected BitFlags different than actual:\n%v\n%v\n", expected, actual)
+		}
+	}
+
+	assert(BitFlags{}.Difference(BitFlags{}), BitFlags{})
+
+	a, b := BitFlags{}, BitFlags{}
+	a.Set(2, 64, 64*2+1, 64*3+18, 64*3+20)
+	b.Set(3, 64, 64*2+1, 64*3+18)
+	assert(a.Difference(b), chunkFlags(BitsChunk{
+		activeMask: 0b1001, // 0, 64*3
+		blocks: []BitsBlock{
+			0b0100,                    // 2
+			0b10000_00000000_00000000, // 64*3+20
+		}}))
+	assert(a.Difference(a), BitFlags{})
+
+	a, b = BitFlags{}, BitFlags{}
+	a.Set(1, 64*64+1, 64*64*7+3, 4_000_000_000)
+	b.Set(2, 64*64+1, 64*64*9, 4_000_000_000)
+
+	difference := []uint{1, 64*64*7 + 3}
+	if !compareRanges(a.Difference(b).Traverse(), difference) {
+		t.Fatalf("Expected difference different than actual:\n%v\n", difference)
+	}
+
+	universe := a.Union(b)
+	complement := []uint{2, 64 * 64 * 9}
+	if !compareRanges(a.Complement(universe).Traverse(), complement) {
+		t.Fatalf("Expected complement different than actual:\n%v\n", complement)
+	}
+
+	d := a.Difference(BitFlags{})
+	d.Set(3)
+	if a.Has(3) {
+		t.Fatalf("Expected difference not to share blocks with its arguments.\n")
+	}
 }
@@ -0,0 +1,522 @@
+package nosqlite
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+/* External sort index builder.
+** Every value of every document becomes a (key, type, value, file index) record.
+** Records are buffered up to a memory budget, sorted and spilled to run files,
+** the runs are k-way merged, at most maxMergedRuns at a time, and merged records
+** are grouped back into entries that are written one by one.
+** Memory is bounded by the budget plus the largest single entry,
+** and the INDEX file is the same as SaveIndex writes for IndexFiles of the same files.
+** DOCS file is written next to it, and TEXT file too when text keys are set. Both are staged as hidden
+** files and renamed only once INDEX file is replaced, so a failed build keeps all the old files.
+** Postings of TEXT file are kept in memory whole, the memory budget does not cover them. */
+
+// BuildOptions configures BuildIndex.
+type BuildOptions struct {
+	// MemoryBudget is the approximate number of bytes of records kept in memory
+	// before they are sorted and spilled to a run file, 64 MiB when not positive.
+	// Postings of TextKeys are not spilled, so they take memory beyond the budget.
+	MemoryBudget int
+	// TempDir holds run files while building, os.TempDir() when empty.
+	TempDir string
+	// Collation of indexed string values, stored in the INDEX header.
+	Collation Collation
+	// Docs lists locations of documents indexed before by their refs, like IndexOptions.Docs.
+	Docs DocsT
+	// TextKeys lists keys of the TEXT file, which is removed when there are none.
+	TextKeys []string
+}
+
+const (
+	defaultMemoryBudget = 64 << 20
+	// n of run files open at once while merging
+	maxMergedRuns = 64
+)
+
+// indexRecord is a single value of a single document.
+type indexRecord struct {
+	key       string
+	valueType IndexEntryType
+	value     aggregateValueT
+	ref       Ref
+}
+
+func indexRecordCmp(a, b indexRecord) int {
+	if keyCmp := valueWithTypeCmp(a.key, b.key, a.valueType, b.valueType); keyCmp != 0 {
+		return keyCmp
+	}
+	if valueCmp := aggregateValueCmp(a.value, b.value, a.valueType); valueCmp != 0 {
+		return valueCmp
+	}
+	return cmp.Compare(a.ref, b.ref)
+}
+
+// recordSize estimates memory taken by the record.
+func recordSize(record indexRecord) int {
+	size := 64 + len(record.key)
+	if str, isStr := record.value.(string); isStr {
+		size += len(str)
+	}
+	return size
+}
+
+// run file binary layout
+// {n key bytes}{key}{type byte}{value}{file index}{n key bytes}{key}{type byte}{value}{file index}...
+// {n key bytes}, {file index} and {n string bytes} before string {value} are uvarints,
+// float and integer {value} is 8 byte big endian, bool {value} a single byte and null has no {value}
+
+func writeRecord(w *bufio.Writer, record indexRecord) error {
+	buff := make([]byte, 0, 2*binary.MaxVarintLen64+len(record.key)+9)
+	buff = binary.AppendUvarint(buff, uint64(len(record.key))) // {n key bytes}
+	buff = append(buff, record.key...)                         // {key}
+	buff = append(buff, byte(record.valueType))                // {type byte}
+	switch record.valueType {                                  // {value}
+	case FloatType:
+		buff = binary.BigEndian.AppendUint64(buff, math.Float64bits(record.value.(float64)))
+	case IntType:
+		buff = binary.BigEndian.AppendUint64(buff, uint64(record.value.(int64)))
+	case StrType:
+		buff = binary.AppendUvarint(buff, uint64(len(record.value.(string))))
+		buff = append(buff, record.value.(string)...)
+	case BoolType:
+		if record.value.(bool) {
+			buff = append(buff, 1)
+		} else {
+			buff = append(buff, 0)
+		}
+	}
+	buff = binary.AppendUvarint(buff, uint64(record.ref)) // {file index}
+	_, err := w.Write(buff)
+	return err
+}
+
+// readRecord reads the next record of a run, it returns io.EOF at the end of the run.
+func readRecord(r *bufio.Reader) (indexRecord, error) {
+	var record indexRecord
+	readBytes := func(err error) ([]byte, error) {
+		if err != nil {
+			return nil, err
+		}
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		bytes := make([]byte, n)
+		_, err = io.ReadFull(r, bytes)
+		return bytes, err
+	}
+
+	key, err := readBytes(nil)
+	if err != nil {
+		return record, err
+	}
+	record.key = string(key)
+	valueType, err := r.ReadByte()
+	record.valueType = IndexEntryType(valueType)
+	switch record.valueType {
+	case FloatType:
+		var bits [8]byte
+		if err == nil {
+			_, err = io.ReadFull(r, bits[:])
+		}
+		record.value = math.Float64frombits(binary.BigEndian.Uint64(bits[:]))
+	case IntType:
+		var bits [8]byte
+		if err == nil {
+			_, err = io.ReadFull(r, bits[:])
+		}
+		record.value = int64(binary.BigEndian.Uint64(bits[:]))
+	case StrType:
+		var str []byte
+		str, err = readBytes(err)
+		record.value = string(str)
+	case BoolType:
+		var boolByte byte
+		if err == nil {
+			boolByte, err = r.ReadByte()
+		}
+		record.value = boolByte != 0
+	case NullType:
+	default:
+		if err == nil {
+			err = fmt.Errorf("%w: unknown record type %c", ErrCorruptIndex, valueType)
+		}
+	}
+	if err == nil {
+		var ref uint64
+		ref, err = binary.ReadUvarint(r)
+		record.ref = Ref(ref)
+	}
+	if errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	return record, err
+}
+
+// recordSource returns records in order, until it returns io.EOF.
+type recordSource func() (indexRecord, error)
+
+func sliceSource(records []indexRecord) recordSource {
+	return func() (indexRecord, error) {
+		if len(records) == 0 {
+			return indexRecord{}, io.EOF
+		}
+		record := records[0]
+		records = records[1:]
+		return record, nil
+	}
+}
+
+type sourceCursor struct {
+	record indexRecord
+	next   recordSource
+}
+
+type cursorHeap []*sourceCursor
+
+func (h cursorHeap) Len() int           { return len(h) }
+func (h cursorHeap) Less(i, j int) bool { return indexRecordCmp(h[i].record, h[j].record) < 0 }
+func (h cursorHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *cursorHeap) Push(x any)        { *h = append(*h, x.(*sourceCursor)) }
+func (h *cursorHeap) Pop() any {
+	old := *h
+	cursor := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return cursor
+}
+
+// mergeSources k-way merges sorted sources into a single sorted source.
+func mergeSources(sources []recordSource) (recordSource, error) {
+	h := make(cursorHeap, 0, len(sources))
+	for _, next := range sources {
+		record, err := next()
+		if errors.Is(err, io.EOF) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		h = append(h, &sourceCursor{record, next})
+	}
+	heap.Init(&h)
+
+	return func() (indexRecord, error) {
+		if len(h) == 0 {
+			return indexRecord{}, io.EOF
+		}
+		cursor := h[0]
+		record := cursor.record
+		next, err := cursor.next()
+		switch {
+		case errors.Is(err, io.EOF):
+			heap.Pop(&h)
+		case err != nil:
+			return record, err
+		default:
+			cursor.record = next
+			heap.Fix(&h, 0)
+		}
+		return record, nil
+	}, nil
+}
+
+// runFiles creates, spills and reads back sorted runs in a temporary directory.
+type runFiles struct {
+	dirPath string
+	paths   []string
+	open    []*os.File
+}
+
+// spill sorts records and writes them into a new run file.
+func (runs *runFiles) spill(records []indexRecord) error {
+	slices.SortFunc(records, indexRecordCmp)
+	return runs.write(sliceSource(records))
+}
+
+func (runs *runFiles) write(source recordSource) error {
+	f, err := os.CreateTemp(runs.dirPath, "run-")
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for {
+		record, err := source()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err == nil {
+			err = writeRecord(w, record)
+		}
+		if err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	runs.paths = append(runs.paths, f.Name())
+	return f.Close()
+}
+
+// sources opens the first n runs and removes them from the list of runs.
+func (runs *runFiles) sources(n int) ([]recordSource, error) {
+	sources := make([]recordSource, 0, n)
+	for _, path := range runs.paths[:n] {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		runs.open = append(runs.open, f)
+		r := bufio.NewReader(f)
+		sources = append(sources, func() (indexRecord, error) { return readRecord(r) })
+	}
+	runs.paths = runs.paths[n:]
+	return sources, nil
+}
+
+// closeOpen closes and removes every run opened by sources.
+func (runs *runFiles) closeOpen() {
+	for _, f := range runs.open {
+		f.Close()
+		os.Remove(f.Name())
+	}
+	runs.open = nil
+}
+
+// BuildIndex indexes filePaths like IndexDocuments, including lines of collection files, and writes INDEX file of dirPath
+// without holding the whole index in memory, together with DOCS file locating the documents relative to dirPath
+// and TEXT file of opts.TextKeys.
+func BuildIndex(filePaths []string, dirPath string, opts BuildOptions) error {
+	budget := opts.MemoryBudget
+	if budget <= 0 {
+		budget = defaultMemoryBudget
+	}
+	tmpDir, err := os.MkdirTemp(opts.TempDir, "nosqlite-build-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	runs := &runFiles{dirPath: tmpDir}
+	defer runs.closeOpen()
+
+	var text *TextIndex
+	if len(opts.TextKeys) > 0 {
+		text = NewTextIndex(opts.TextKeys)
+	}
+	records := make([]indexRecord, 0, 1024)
+	recordsSize := 0
+	refs := newRefAssigner(opts.Docs)
+	err = eachSourceDocument(filePaths, func(src sourceDocument) error {
+		bytes, err := readSourceDocument(src)
+		if err != nil {
+			return err
+		}
+		unflatten, err := parseJson(bytes)
+		if err != nil {
+			return fmt.Errorf("%s: %w", src.location, err)
+		}
+		ref := refs.assign(src.location)
+		flatten := flattenJson(unflatten)
+		if text != nil {
+			text.addDocument(ref, flatten)
+		}
+		for flattenValue := range collateFlatten(flatten, opts.Collation) {
+			record := indexRecord{flattenValue.key, flattenValue.valueType, flattenValue.value, ref}
+			records = append(records, record)
+			recordsSize += recordSize(record)
+		}
+
+		if recordsSize >= budget {
+			if err := runs.spill(records); err != nil {
+				return err
+			}
+			records, recordsSize = records[:0], 0
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for len(runs.paths) > maxMergedRuns {
+		sources, err := runs.sources(maxMergedRuns)
+		if err != nil {
+			return err
+		}
+		merged, err := mergeSources(sources)
+		if err == nil {
+			err = runs.write(merged)
+		}
+		if err != nil {
+			return err
+		}
+		runs.closeOpen()
+	}
+
+	slices.SortFunc(records, indexRecordCmp)
+	sources, err := runs.sources(len(runs.paths))
+	if err != nil {
+		return err
+	}
+	merged, err := mergeSources(append(sources, sliceSource(records)))
+	if err != nil {
+		return err
+	}
+
+	docs, err := relativeDocs(dirPath, refs.docs)
+	if err != nil {
+		return err
+	}
+	docsPath, err := writeTempFile(dirPath, ".DOCS-", serializeDocs(docs))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(docsPath)
+	textPath := ""
+	if text != nil {
+		if textPath, err = writeTempFile(dirPath, ".TEXT-", serializeTextIndex(text)); err != nil {
+			return err
+		}
+		defer os.Remove(textPath)
+		text = nil
+	}
+
+	if err := writeBuiltIndex(dirPath, tmpDir, merged, opts.Collation); err != nil {
+		return err
+	}
+	if err := os.Rename(docsPath, filepath.Join(dirPath, docsFileName)); err != nil {
+		return err
+	}
+	if textPath == "" {
+		return removeTextIndex(dirPath)
+	}
+	return replaceTextFile(textPath, dirPath)
+}
+
+// writeBuiltIndex groups sorted records into entries and writes them as INDEX file of dirPath.
+// Entries and directory go to temporary files first, as the header needs their sizes.
+func writeBuiltIndex(dirPath, tmpDir string, records recordSource, collation Collation) error {
+	// {entries}{entry offsets}{first value of entries}{value offsets}
+	sections := make([]*os.File, 4)
+	writers := make([]*bufio.Writer, 4)
+	for i := range sections {
+		f, err := os.CreateTemp(tmpDir, "section-")
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		sections[i], writers[i] = f, bufio.NewWriter(f)
+	}
+
+	w := indexWriter{encoding: currentEncoding, buff: bytes.NewBuffer(make([]byte, 0, 512))}
+	appendEntry := func(entry IndexEntry) error {
+		w.appendEntry(entry)
+		w.base += size_t(w.buff.Len())
+		if _, err := w.buff.WriteTo(writers[0]); err != nil {
+			return err
+		}
+		for i, offsets := range [][]size_t{w.dir.entryOffsets, w.dir.firstValues, w.dir.valueOffsets} {
+			if err := binary.Write(writers[i+1], binary.BigEndian, offsets); err != nil {
+				return err
+			}
+		}
+		w.dir = indexDir{w.dir.entryOffsets[:0], w.dir.firstValues[:0], w.dir.valueOffsets[:0]}
+		return nil
+	}
+
+	documents := fileRefs{}
+	var entry IndexEntry
+	for {
+		record, err := records()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		documents.Set(uint(record.ref))
+
+		if entry.values == nil || entry.key != record.key || entry.valueType != record.valueType {
+			if entry.values != nil {
+				if err := appendEntry(entry); err != nil {
+					return err
+				}
+			}
+			entry = IndexEntry{record.key, record.valueType, make([]ValueRefs, 0, 1)}
+		}
+		last := len(entry.values) - 1
+		if last >= 0 && aggregateValueCmp(entry.values[last].value, record.value, record.valueType) == 0 {
+			entry.values[last].refs = append(entry.values[last].refs, record.ref)
+		} else {
+			entry.values = append(entry.values, ValueRefs{record.value, []Ref{record.ref}})
+		}
+	}
+	if entry.values != nil {
+		if err := appendEntry(entry); err != nil {
+			return err
+		}
+	}
+	for _, writer := range writers {
+		if err := writer.Flush(); err != nil {
+			return err
+		}
+	}
+
+	header := indexHeader{indexFormatVersion, size_t(w.nEntries), size_t(documents.Popcount()),
+		w.base, size_t(4 * (2*w.nEntries + int(w.nValues))), size_t(currentEncoding) | size_t(collation)<<collationShift}
+	indexFile, err := os.CreateTemp(dirPath, ".INDEX-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(indexFile.Name())
+	defer indexFile.Close()
+
+	out := bufio.NewWriter(indexFile)
+	copySections := func(sections []*os.File) error {
+		crc := crc32.New(crc32cTable)
+		for _, f := range sections {
+			if _, err := f.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+			if _, err := io.Copy(io.MultiWriter(out, crc), f); err != nil {
+				return err
+			}
+		}
+		return binary.Write(out, binary.BigEndian, crc.Sum32())
+	}
+	if _, err := out.Write(encodeIndexHeader(header)); err != nil { // {header}
+		return err
+	}
+	if err := copySections(sections[:1]); err != nil { // {entries}{entries crc32c}
+		return err
+	}
+	if err := copySections(sections[1:]); err != nil { // {directory}{directory crc32c}
+		return err
+	}
+	if err := out.Flush(); err != nil {
+		return err
+	}
+	if err := indexFile.Close(); err != nil {
+		return err
+	}
+	return replaceIndexFile(indexFile.Name(), dirPath)
+}
@@ -0,0 +1,153 @@
+package nosqlite
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Check if INDEX built through external sort is byte identical to the one saved from IndexFiles.
+func TestBuildIndex(t *testing.T) {
+	paths := writeTestFiles(t, 300)
+	extra := filepath.Join(t.TempDir(), "extra")
+	check(os.WriteFile(extra, []byte(`{"n": "seven", "s": null, "nul\u0000key": "a\u0000b", "neg": -0.5, "empty": {}}`), 0644))
+	paths = append(paths, extra)
+
+	expectedDir := t.TempDir()
+	index, err := IndexFiles(paths)
+	check(err)
+	check(SaveIndex(index, expectedDir))
+	expected := readTestFile(t, expectedDir+"/INDEX")
+
+	// 1 byte budget spills a run for every file, more than maxMergedRuns of them
+	for _, budget := range []int{0, 1, 4096} {
+		dirPath, tmpDir := t.TempDir(), t.TempDir()
+		if err := BuildIndex(paths, dirPath, BuildOptions{MemoryBudget: budget, TempDir: tmpDir}); err != nil {
+			t.Fatalf("Got unexpected error: %v", err)
+		}
+		if actual := readTestFile(t, dirPath+"/INDEX"); !bytes.Equal(expected, actual) {
+			t.Fatalf("Expected INDEX built with %d bytes budget to be identical to saved one, got %d bytes instead of %d",
+				budget, len(actual), len(expected))
+		}
+		if entries, _ := os.ReadDir(tmpDir); len(entries) != 0 {
+			t.Fatalf("Expected run files to be removed, got %v", entries)
+		}
+		if entries, _ := os.ReadDir(dirPath); len(entries) != 2 {
+			t.Fatalf("Expected only INDEX and DOCS files to be written, got %v", entries)
+		}
+		docs, err := ReadDocs(dirPath)
+		check(err)
+		if len(docs) != len(paths) || docs.path(1) != "../"+filepath.Base(filepath.Dir(paths[1]))+"/"+filepath.Base(paths[1]) {
+			t.Fatalf("Expected DOCS file to locate documents relative to %s, got %v", dirPath, docs[:2])
+		}
+	}
+}
+
+// Check if directory built with collection files and TEXT keys is opened and queried.
+func TestBuildDir(t *testing.T) {
+	dirPath := t.TempDir()
+	check(os.WriteFile(dirPath+"/a.json", []byte(`{"name": "Ann", "bio": "fast runner"}`), 0644))
+	check(os.WriteFile(dirPath+"/b.jsonl", []byte("{\"name\": \"Bob\"}\n{\"name\": \"Cid\", \"bio\": \"slow runner\"}\n"), 0644))
+	// DOCS of an earlier index, whose refs are kept, and a stale TEXT file
+	check(SaveDocs(DocsT{"b.jsonl#0", "gone.json"}, dirPath))
+	check(os.WriteFile(dirPath+"/"+textFileName, []byte("stale"), 0644))
+
+	if err := BuildDir(dirPath, DirOptions{TextKeys: []string{"/bio"}}); err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	docs, err := ReadDocs(dirPath)
+	check(err)
+	if expected := (DocsT{"b.jsonl#0", "", "a.json", "b.jsonl#16"}); !compareSlices(docs, expected) {
+		t.Fatalf("Expected docs different than actual:\n%v\n%v", expected, docs)
+	}
+
+	db, err := Open(dirPath)
+	check(err)
+	defer db.Close()
+	documents, err := db.Query("SELECT c.name FROM c WHERE c.name > 'Ann'")
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	assertDocuments(t, documents, []Document{
+		{0, "b.jsonl#0", map[string]interface{}{"name": "Bob"}},
+		{3, "b.jsonl#16", map[string]interface{}{"name": "Cid"}},
+	})
+	ids, err := db.Exec("SELECT * FROM c WHERE MATCH(c.bio, 'runner')")
+	if err != nil || !compareSlices(ids, []Ref{2, 3}) {
+		t.Fatalf("Expected ids different than actual:\n%v\n%v %v", []Ref{2, 3}, ids, err)
+	}
+}
+
+// Check if empty input and broken files are handled like IndexFiles.
+func TestBuildIndexEdgeCases(t *testing.T) {
+	dirPath := t.TempDir()
+	check(BuildIndex(nil, dirPath, BuildOptions{}))
+	if actual := readTestFile(t, dirPath+"/INDEX"); !bytes.Equal(encodeIndexFile(IndexT{}, BinaryCollation), actual) {
+		t.Fatalf("Expected empty INDEX, got %q", actual)
+	}
+
+	paths := writeTestFiles(t, 10)
+	check(os.WriteFile(paths[4], []byte(`{"name": `), 0644))
+	err := BuildIndex(paths, dirPath, BuildOptions{MemoryBudget: 1})
+	if err == nil || !strings.Contains(err.Error(), paths[4]) {
+		t.Fatalf("Expected error of %s, got %v", paths[4], err)
+	}
+
+	// INDEX which can not be replaced keeps DOCS and TEXT files it was written with
+	check(SaveDocs(DocsT{"old.json"}, dirPath))
+	check(SaveTextIndex(NewTextIndex([]string{"/s"}), dirPath))
+	textBytes := readTestFile(t, dirPath+"/"+textFileName)
+	check(os.Remove(dirPath + "/INDEX"))
+	check(os.MkdirAll(dirPath+"/INDEX/blocked", 0755))
+	if err := BuildIndex(paths[:4], dirPath, BuildOptions{TextKeys: []string{"/s"}}); err == nil {
+		t.Fatalf("Expected error when INDEX file can not be replaced")
+	}
+	if docs, err := ReadDocs(dirPath); err != nil || !compareSlices(docs, DocsT{"old.json"}) {
+		t.Fatalf("Expected old DOCS file to be kept, got %v %v", docs, err)
+	}
+	if !bytes.Equal(readTestFile(t, dirPath+"/"+textFileName), textBytes) {
+		t.Fatalf("Expected old TEXT file to be kept")
+	}
+	entries, _ := os.ReadDir(dirPath)
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			t.Fatalf("Expected staged files to be removed, got %s", entry.Name())
+		}
+	}
+}
+
+// Check if records round trip through run files.
+func TestRunFiles(t *testing.T) {
+	records := []indexRecord{}
+	for ref := Ref(0); ref < 40; ref++ {
+		records = append(records,
+			indexRecord{fmt.Sprintf("/k%d", ref%3), FloatType, float64(ref%5) - 2.5, ref},
+			indexRecord{"/s", StrType, strings.Repeat("\x00ż", int(ref)), ref},
+			indexRecord{"/b", BoolType, ref%2 == 0, ref},
+			indexRecord{"/n", NullType, nil, ref})
+	}
+
+	runs := &runFiles{dirPath: t.TempDir()}
+	defer runs.closeOpen()
+	check(runs.spill(records[:70]))
+	check(runs.spill(records[70:]))
+	sources, err := runs.sources(2)
+	check(err)
+	merged, err := mergeSources(sources)
+	check(err)
+
+	expected := append([]indexRecord{}, records...)
+	check(runs.spill(expected)) // sorts expected
+	for i, record := range expected {
+		actual, err := merged()
+		if err != nil || actual != record {
+			t.Fatalf("Expected record %d %v, got %v %v", i, record, actual, err)
+		}
+	}
+	if _, err := merged(); err == nil {
+		t.Fatalf("Expected end of merged runs")
+	}
+}
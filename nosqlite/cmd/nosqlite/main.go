@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jacnik/nosqlite"
+)
+
+// https://github.com/x-motemen/gore/blob/main/cli/run.go
+func cmdOpen(cmd string) (filename string, path string, db *nosqlite.DB, err error) {
+	// strings.Split(cmd, )
+	fields := strings.Fields(cmd)
+	if len(fields) != 2 {
+		// Custom errors -> https://yourbasic.org/golang/create-error/
+		return "", "", nil, errors.New("Wrong number of parameters to .open") // TODO custom error end usage of .open cmd
+	}
+	path = fields[1]
+	filename = filepath.Base(path)
+	db, err = nosqlite.Open(filepath.Dir(path))
+	return
+}
+
+func closeDb(db *nosqlite.DB) int {
+	if db == nil {
+		return 0
+	}
+	if err := db.Close(); err != nil {
+		fmt.Printf("%s\n", err)
+		return 1
+	}
+	return 0
+}
+
+func RunCli() int {
+	/* Commands: .help .exit .open .database */
+	reader := bufio.NewReader(os.Stdin)
+	version := "0.01"
+	fmt.Printf("NoSQLite version: %s\n", version)
+	fmt.Println("Enter \".help\" for usage hints.")
+
+	var currDb *nosqlite.DB
+	var currName string
+	var currPath string
+
+	for {
+		fmt.Print("nosqlite> ")
+		text, err := reader.ReadString('\n')
+		if err == io.EOF {
+			return closeDb(currDb)
+		}
+		// convert CRLF to LF
+		text = strings.Replace(text, "\n", "", -1)
+
+		if text == ".exit" {
+			return closeDb(currDb)
+		}
+		if text == ".database" {
+			fmt.Printf("seq  name             file\n")
+			fmt.Printf("---  ---------------  --------------------------\n")
+			fmt.Printf("%-3d  %-15s  %-26s\n", 0, currName, currPath)
+			continue
+		}
+		if strings.HasPrefix(text, ".open") { //.open /workspaces/nosqlite/nosqlite/db/INDEX
+			name, path, db, err := cmdOpen(text)
+			if err != nil {
+				fmt.Printf("%s\n", err)
+				continue
+			}
+			closeDb(currDb)
+			currDb = db
+			currName = name
+			currPath = path
+			continue
+		}
+		if strings.HasPrefix(text, ".") {
+			fmt.Printf("Unknown \"%s\"\n", text)
+			continue
+		}
+		if currDb == nil {
+			fmt.Println("No database opened. Use \".open\" first.")
+			continue
+		}
+		if strings.HasPrefix(text, "SELECT") {
+			documents, err := currDb.Query(text)
+			if err != nil {
+				fmt.Printf("%s\n", err)
+				continue
+			}
+			for _, document := range documents {
+				value, _ := json.Marshal(document.Value)
+				fmt.Printf("%-3d  %-15s  %s\n", document.Ref, document.Path, value)
+			}
+			continue
+		}
+		ids, err := currDb.Exec(text)
+		if err != nil {
+			fmt.Printf("%s\n", err)
+			continue
+		}
+		fmt.Printf("Refs:\n%v\n", ids)
+	}
+}
+
+func main() {
+	os.Exit(RunCli())
+}
@@ -0,0 +1,117 @@
+package nosqlite
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/jacnik/nosqlite/parser"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+/* Collation of string values.
+** Strings are collated when documents are flattened, so the index keeps only collated values,
+** sorted byte by byte as before, and string values of queries are collated the same way before
+** they are searched for. Documents themselves are never changed.
+** Collation of an index is stored in INDEX header flags above indexEncoding bits,
+** SaveIndex keeps collation of the INDEX file it replaces and SaveIndexWith sets it. */
+
+// Collation tells how string values are compared.
+type Collation size_t
+
+const (
+	// NormalizeNFC composes strings canonically, so "e\u0301" and "\u00e9" are equal.
+	NormalizeNFC Collation = 1 << iota
+	// FoldCase folds case of strings by Unicode case folding, so 'Elliot' and 'elliot'
+	// and also 'Straße' and 'STRASSE' are equal.
+	FoldCase
+)
+
+const (
+	// BinaryCollation compares strings byte by byte.
+	BinaryCollation Collation = 0
+	knownCollations           = NormalizeNFC | FoldCase
+	// collation bits of INDEX header {flags} start after indexEncoding bits
+	collationShift = 8
+)
+
+func (c Collation) apply(s string) string {
+	if c&FoldCase != 0 {
+		// Caser keeps state, so it is not shared by goroutines indexing documents
+		s = cases.Fold().String(s)
+	}
+	if c&NormalizeNFC != 0 {
+		s = norm.NFC.String(s)
+	}
+	return s
+}
+
+// collateValue collates string values of a condition and bounds of BETWEEN.
+// Values compared to LOWER or UPPER of a key keep their case, as they are compared to converted values.
+func (c Collation) collateValue(val interface{}) interface{} {
+	switch v := val.(type) {
+	case string:
+		return c.apply(v)
+	case parser.Range:
+		return parser.Range{From: c.collateValue(v.From), To: c.collateValue(v.To)}
+	case parser.CaseValue:
+		return parser.CaseValue{Func: v.Func, Val: (c &^ FoldCase).collateValue(v.Val)}
+	}
+	return val
+}
+
+// isLowerValue reports whether string value, or both bounds of BETWEEN, are lower case,
+// so that LOWER of a key may be equal to them.
+func isLowerValue(val interface{}) bool {
+	switch v := val.(type) {
+	case string:
+		return v == strings.ToLower(v)
+	case parser.Range:
+		return isLowerValue(v.From) && isLowerValue(v.To)
+	}
+	return false
+}
+
+// collateFlatten returns flatten with collated string values.
+func collateFlatten(flatten flattenJsonT, collation Collation) flattenJsonT {
+	if collation == BinaryCollation {
+		return flatten
+	}
+	collated := make(flattenJsonT, len(flatten))
+	for flattenValue := range flatten {
+		if str, isStr := flattenValue.value.(string); isStr {
+			flattenValue.value = collation.apply(str)
+		}
+		collated[flattenValue] = struct{}{}
+	}
+	return collated
+}
+
+// ReadCollation returns collation of INDEX file of dirPath,
+// BinaryCollation when there is no INDEX file yet or it was written before collations were added.
+func ReadCollation(dirPath string) (Collation, error) {
+	f, err := os.Open(dirPath + "/INDEX")
+	if errors.Is(err, os.ErrNotExist) {
+		return BinaryCollation, nil
+	}
+	if err != nil {
+		return BinaryCollation, err
+	}
+	defer f.Close()
+
+	headerBytes := make([]byte, indexHeaderSize(indexFormatVersion))
+	n, err := io.ReadFull(f, headerBytes)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return BinaryCollation, err
+	}
+	if isLegacyIndexFile(headerBytes[:n]) {
+		return BinaryCollation, nil
+	}
+	header, err := decodeIndexHeader(headerBytes[:n])
+	if err != nil {
+		return BinaryCollation, err
+	}
+	return header.collation(), nil
+}
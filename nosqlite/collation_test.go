@@ -0,0 +1,128 @@
+package nosqlite
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Check if collations fold case and compose accents.
+func TestCollationApply(t *testing.T) {
+	assert := func(collation Collation, s, expected string) {
+		if actual := collation.apply(s); actual != expected {
+			t.Fatalf("Expected %q collated with %d to be %q, got %q", s, collation, expected, actual)
+		}
+	}
+	assert(BinaryCollation, "Élliot", "Élliot")
+	assert(NormalizeNFC, "Élliot", "Élliot")
+	assert(FoldCase, "Élliot", "élliot")
+	assert(FoldCase|NormalizeNFC, "Élliot", "élliot")
+	assert(FoldCase|NormalizeNFC, "ÉLLIOT", "élliot")
+	// case folding differs from lower casing
+	assert(FoldCase, "Straße", "strasse")
+	assert(FoldCase, "STRASSE", "strasse")
+	assert(FoldCase, "ὈΔΥΣΣΕΎΣ", FoldCase.apply("ὀδυσσεύς"))
+	if strings.ToLower("ΣΟΦΟΣ") == "σοφος" || FoldCase.apply("ΣΟΦΟΣ") != FoldCase.apply("σοφος") {
+		t.Fatalf("Expected final sigma to be folded, got %q", FoldCase.apply("σοφος"))
+	}
+}
+
+func writeCollationTestDocs(t *testing.T) string {
+	t.Helper()
+	dirPath := t.TempDir()
+	for i, name := range []string{"Élliot", "elliot", "ELLA", "Fraser"} {
+		check(os.WriteFile(fmt.Sprintf("%s/%d", dirPath, i), []byte(fmt.Sprintf(`{"name": %q}`, name)), 0644))
+	}
+	return dirPath
+}
+
+// Check if collated index matches strings case insensitively and keeps its collation when it is saved again.
+func TestQueryCollatedIndex(t *testing.T) {
+	dirPath := writeCollationTestDocs(t)
+	db, err := OpenWith(dirPath, DirOptions{Collation: FoldCase | NormalizeNFC})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	assert := func(query string, expected ...Ref) {
+		t.Helper()
+		refs, err := db.Exec(query)
+		if err != nil {
+			t.Fatalf("Got unexpected error for %s: %v", query, err)
+		}
+		if !compareSlices(refs, expected) {
+			t.Fatalf("Expected refs for %s different than actual:\n%v\n%v", query, expected, refs)
+		}
+	}
+
+	assert("SELECT * FROM c WHERE c.name = 'ÉLLIOT'", 0)
+	assert("SELECT * FROM c WHERE c.name = 'Elliot'", 1)
+	assert("SELECT * FROM c WHERE c.name LIKE 'EL%'", 1, 2)
+	assert("SELECT * FROM c WHERE LOWER(c.name) = 'ella'", 2)
+	assert("SELECT * FROM c WHERE LOWER(c.name) = 'ELLA'")
+	assert("SELECT * FROM c WHERE UPPER(c.name) BETWEEN 'ELLA' AND 'ELLIOT'", 1, 2)
+	assert(`INSERT INTO c VALUES '{"name": "FRASER"}'`, 4)
+	assert("SELECT * FROM c WHERE c.name = 'fraser'", 3, 4)
+	assert(`INSERT INTO c VALUES '{"name": "Straße"}'`, 5)
+	assert(`INSERT INTO c VALUES '{"name": "ΣΟΦΟΣ"}'`, 6)
+	assert("SELECT * FROM c WHERE c.name = 'STRASSE'", 5)
+	assert("SELECT * FROM c WHERE c.name = 'σοφος'", 6)
+	assert("SELECT * FROM c WHERE LOWER(c.name) = 'straße'", 5)
+
+	check(db.Close())
+	if collation, err := ReadCollation(dirPath); err != nil || collation != FoldCase|NormalizeNFC {
+		t.Fatalf("Expected collation %d, got %d %v", FoldCase|NormalizeNFC, collation, err)
+	}
+
+	// reopened without options keeps the collation of its INDEX file
+	db, err = Open(dirPath)
+	check(err)
+	check(db.Index())
+	assert("SELECT * FROM c WHERE c.name = 'FRASER'", 3, 4)
+	check(db.Close())
+
+	// built INDEX is collated the same way
+	builtPath := t.TempDir()
+	paths := []string{dirPath + "/0", dirPath + "/1", dirPath + "/2", dirPath + "/3", dirPath + "/4", dirPath + "/5", dirPath + "/6"}
+	check(BuildIndex(paths, builtPath, BuildOptions{MemoryBudget: 1, Collation: FoldCase | NormalizeNFC}))
+	if !bytes.Equal(readTestFile(t, dirPath+"/INDEX"), readTestFile(t, builtPath+"/INDEX")) {
+		t.Fatalf("Expected built INDEX to be identical to saved one")
+	}
+
+	mapped, err := MapIndex(dirPath)
+	check(err)
+	defer mapped.Close()
+	refs, err := mapped.Query("SELECT * FROM c WHERE c.name = 'Élliot'")
+	if err != nil || !compareSlices(refs, []Ref{0}) {
+		t.Fatalf("Expected refs [0] from mapped index, got %v %v", refs, err)
+	}
+}
+
+// Check if LOWER and UPPER convert values of binary collated index while plain conditions stay case sensitive.
+func TestQueryCaseFunctions(t *testing.T) {
+	dirPath := writeCollationTestDocs(t)
+	db, err := Open(dirPath)
+	check(err)
+	defer db.Close()
+	if collation, err := ReadCollation(dirPath); err != nil || collation != BinaryCollation {
+		t.Fatalf("Expected binary collation, got %d %v", collation, err)
+	}
+
+	assert := func(query string, expected ...Ref) {
+		t.Helper()
+		refs, err := db.Exec(query)
+		if err != nil {
+			t.Fatalf("Got unexpected error for %s: %v", query, err)
+		}
+		if !compareSlices(refs, expected) {
+			t.Fatalf("Expected refs for %s different than actual:\n%v\n%v", query, expected, refs)
+		}
+	}
+
+	assert("SELECT * FROM c WHERE c.name = 'ella'")
+	assert("SELECT * FROM c WHERE LOWER(c.name) = 'ella'", 2)
+	assert("SELECT * FROM c WHERE UPPER(c.name) = 'ELLIOT'", 1)
+	assert("SELECT * FROM c WHERE UPPER(c.name) != 'ELLA'", 0, 1, 3)
+	assert("SELECT * FROM c WHERE LOWER(c.name) LIKE '%l%' AND NOT UPPER(c.name) > 'F'", 0, 1, 2)
+}
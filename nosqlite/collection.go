@@ -0,0 +1,135 @@
+package nosqlite
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+/* Collection files (.jsonl, .ndjson) hold a json document on every line.
+** Every non blank line is indexed as a separate document with its own ref,
+** located in DOCS by path of the file and byte offset of the line, like `events.jsonl#1024`,
+** so a query result is read back by seeking to the line instead of parsing the whole file.
+** Lines of collection files are read only, UPDATE and DELETE of them are rejected. */
+
+// ErrReadOnlyDocument is returned when a line of a collection file is to be updated or deleted.
+var ErrReadOnlyDocument = errors.New("Document is a line of a collection file and can not be modified")
+
+func isCollectionFile(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jsonl", ".ndjson":
+		return true
+	}
+	return false
+}
+
+// lineLocation returns location of the line starting at offset of collection file path.
+func lineLocation(path string, offset int64) string {
+	return path + "#" + strconv.FormatInt(offset, 10)
+}
+
+// splitLocation returns file path of a document location and, for lines of collection files, offset of the line.
+func splitLocation(location string) (path string, offset int64, isLine bool) {
+	sep := strings.LastIndexByte(location, '#')
+	if sep < 0 || !isCollectionFile(location[:sep]) {
+		return location, 0, false
+	}
+	offset, err := strconv.ParseInt(location[sep+1:], 10, 64)
+	if err != nil || offset < 0 {
+		return location, 0, false
+	}
+	return location[:sep], offset, true
+}
+
+// trimLine drops line ending and surrounding white space.
+func trimLine(line []byte) []byte {
+	return bytes.TrimSpace(line)
+}
+
+// readLocation reads document at location, a whole file or a single line of a collection file.
+func readLocation(location string) ([]byte, error) {
+	path, offset, isLine := splitLocation(location)
+	if !isLine {
+		return os.ReadFile(path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	line, err := bufio.NewReader(f).ReadBytes('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	line = trimLine(line)
+	if len(line) == 0 {
+		return nil, fmt.Errorf("%w: no document at %s", ErrDocumentNotFound, location)
+	}
+	return line, nil
+}
+
+// sourceDocument is a document found in indexed files.
+type sourceDocument struct {
+	fileIdx  int    // position of the file in indexed files
+	location string // file path, followed by offset of the line for collection files
+	doc      []byte // line of a collection file, nil when the document is a whole file not read yet
+}
+
+// eachSourceDocument passes every document of filePaths to yield, in order of their refs.
+// Files holding a single document are not read, lines of collection files are.
+func eachSourceDocument(filePaths []string, yield func(sourceDocument) error) error {
+	for fileIdx, path := range filePaths {
+		if !isCollectionFile(path) {
+			if err := yield(sourceDocument{fileIdx, path, nil}); err != nil {
+				return err
+			}
+			continue
+		}
+
+		err := func() error {
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+
+			r := bufio.NewReader(f)
+			for offset := int64(0); ; {
+				line, err := r.ReadBytes('\n')
+				if err != nil && !errors.Is(err, io.EOF) {
+					return err
+				}
+				if doc := trimLine(line); len(doc) > 0 {
+					if err := yield(sourceDocument{fileIdx, lineLocation(path, offset), doc}); err != nil {
+						return err
+					}
+				}
+				if err != nil {
+					return nil
+				}
+				offset += int64(len(line))
+			}
+		}()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readSourceDocument reads the file of src unless it is a line of a collection file.
+func readSourceDocument(src sourceDocument) ([]byte, error) {
+	if src.doc != nil {
+		return src.doc, nil
+	}
+	return readFile(src.location)
+}
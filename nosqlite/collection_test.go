@@ -0,0 +1,106 @@
+package nosqlite
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+const testCollection = "{\"name\": \"Ann\", \"age\": 31}\n" +
+	"\n" +
+	"{\"name\": \"Bob\", \"age\": 17}\r\n" +
+	"   {\"name\": \"Cid\", \"age\": 45}"
+
+// Check if every line of a collection file is indexed as a document located by its offset.
+func TestIndexDocumentsOfCollection(t *testing.T) {
+	dirPath := t.TempDir()
+	check(os.WriteFile(dirPath+"/people.jsonl", []byte(testCollection), 0644))
+	check(os.WriteFile(dirPath+"/solo.json", []byte(`{"name": "Dan", "age": 50}`), 0644))
+	paths := []string{dirPath + "/people.jsonl", dirPath + "/solo.json"}
+
+	expected := IndexT{}
+	for ref, doc := range []string{`{"name": "Ann", "age": 31}`, `{"name": "Bob", "age": 17}`,
+		`{"name": "Cid", "age": 45}`, `{"name": "Dan", "age": 50}`} {
+		delta, err := documentIndex([]byte(doc), Ref(ref), BinaryCollation)
+		check(err)
+		mergeIndex(&expected, delta)
+	}
+
+	for _, workers := range []int{1, 3} {
+		index, docs, err := IndexDocuments(paths, IndexOptions{Workers: workers})
+		if err != nil {
+			t.Fatalf("Got unexpected error: %v", err)
+		}
+		if !compareIndexes(expected, index) {
+			t.Fatalf("Expected index different than actual:\n%v\n%v", expected, index)
+		}
+		expectedDocs := DocsT{paths[0] + "#0", paths[0] + "#28", paths[0] + "#56", paths[1]}
+		if !compareSlices(expectedDocs, docs) {
+			t.Fatalf("Expected docs different than actual:\n%v\n%v", expectedDocs, docs)
+		}
+	}
+
+	saved := t.TempDir()
+	check(SaveIndex(expected, saved))
+	check(BuildIndex(paths, dirPath, BuildOptions{MemoryBudget: 1}))
+	if !bytes.Equal(readTestFile(t, saved+"/INDEX"), readTestFile(t, dirPath+"/INDEX")) {
+		t.Fatalf("Expected built INDEX to be identical to saved one")
+	}
+
+	check(os.WriteFile(paths[0], []byte(testCollection+"\n{\"name\": "), 0644))
+	_, _, err := IndexDocuments(paths, IndexOptions{})
+	if err == nil || !strings.Contains(err.Error(), paths[0]+"#86") {
+		t.Fatalf("Expected error at line offset 86, got %v", err)
+	}
+}
+
+// Check if query results of a collection file are read back from their lines, which are read only.
+func TestQueryCollection(t *testing.T) {
+	dirPath := t.TempDir()
+	check(os.WriteFile(dirPath+"/people.ndjson", []byte(testCollection), 0644))
+
+	db, err := Open(dirPath)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	defer db.Close()
+
+	documents, err := db.Query("SELECT c.name FROM c WHERE c.age > 20")
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	assertDocuments(t, documents, []Document{
+		{0, "people.ndjson#0", map[string]interface{}{"name": "Ann"}},
+		{2, "people.ndjson#56", map[string]interface{}{"name": "Cid"}},
+	})
+
+	line, err := db.Document(1)
+	if err != nil || string(line) != `{"name": "Bob", "age": 17}` {
+		t.Fatalf("Expected line of Bob, got %q %v", line, err)
+	}
+	if _, err := db.Document(3); !errors.Is(err, ErrDocumentNotFound) {
+		t.Fatalf("Expected ErrDocumentNotFound, got %v", err)
+	}
+
+	if _, err := db.Exec("DELETE FROM c WHERE c.name = 'Bob'"); !errors.Is(err, ErrReadOnlyDocument) {
+		t.Fatalf("Expected ErrReadOnlyDocument, got %v", err)
+	}
+}
+
+// Check if only offsets after collection file paths are taken for line locations.
+func TestSplitLocation(t *testing.T) {
+	assert := func(location, path string, offset int64, isLine bool) {
+		actualPath, actualOffset, actualIsLine := splitLocation(location)
+		if actualPath != path || actualOffset != offset || actualIsLine != isLine {
+			t.Fatalf("Expected %s to split into %s %d %t, got %s %d %t",
+				location, path, offset, isLine, actualPath, actualOffset, actualIsLine)
+		}
+	}
+	assert("db/events.jsonl#1024", "db/events.jsonl", 1024, true)
+	assert("db/events.NDJSON#0", "db/events.NDJSON", 0, true)
+	assert("db/events.json#12", "db/events.json#12", 0, false)
+	assert("db/events.jsonl#x", "db/events.jsonl#x", 0, false)
+	assert("db/events.jsonl", "db/events.jsonl", 0, false)
+}
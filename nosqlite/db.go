@@ -0,0 +1,167 @@
+package nosqlite
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// DB is a database directory holding json documents next to their INDEX file.
+type DB struct {
+	dirPath string
+	opts    DirOptions
+	index   *journaledIndex
+	closed  bool
+}
+
+// ErrClosed is returned when DB is used after Close.
+var ErrClosed = errors.New("Database is closed")
+
+// Open maps index of the database directory dirPath, see MappedIndex, and replays its INDEX.delta journal.
+// Directory without INDEX file is indexed first and INDEX file without header or in an older format is migrated.
+func Open(dirPath string) (*DB, error) {
+	return OpenWith(dirPath, DirOptions{})
+}
+
+// OpenWith opens dirPath like Open, indexing only documents of the directory tree selected by opts.
+func OpenWith(dirPath string, opts DirOptions) (*DB, error) {
+	info, err := os.Stat(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, errors.New("Database path is not a directory")
+	}
+
+	db := &DB{dirPath: dirPath, opts: opts}
+	if _, err := MigrateIndex(dirPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	db.index, err = openJournaledIndex(dirPath)
+	if errors.Is(err, os.ErrNotExist) {
+		err = db.Index()
+	} else if err == nil {
+		db.opts.Collation = db.index.mapped.Collation()
+		err = db.openText()
+	}
+	if err != nil {
+		if db.index != nil {
+			db.index.Close()
+		}
+		return nil, err
+	}
+	return db, nil
+}
+
+func (db *DB) Path() string {
+	return db.dirPath
+}
+
+// Entries reads the whole index with changes of INDEX.delta journal.
+func (db *DB) Entries() (IndexT, error) {
+	if db.closed {
+		return nil, ErrClosed
+	}
+	return ReadIndex(db.dirPath)
+}
+
+// openText keeps keys of an existing TEXT file unless opts set different ones, which are indexed right away.
+func (db *DB) openText() error {
+	keys := db.index.text.Keys()
+	if len(db.opts.TextKeys) == 0 {
+		db.opts.TextKeys = keys
+		return nil
+	}
+	if len(keys) > 0 && slices.Equal(keys, NewTextIndex(db.opts.TextKeys).Keys()) {
+		return nil
+	}
+	return db.indexText()
+}
+
+// indexText rebuilds TEXT file of opts.TextKeys, or removes it when there are none.
+func (db *DB) indexText() error {
+	if len(db.opts.TextKeys) == 0 {
+		db.index.text = NewTextIndex(nil)
+		return removeTextIndex(db.dirPath)
+	}
+	text, err := IndexText(db.dirPath, db.opts.TextKeys)
+	if err != nil {
+		return err
+	}
+	db.index.text = text
+	return SaveTextIndex(text, db.dirPath)
+}
+
+// Index rebuilds INDEX, DOCS and TEXT files from every document in the database directory tree.
+func (db *DB) Index() error {
+	if db.closed {
+		return ErrClosed
+	}
+	if err := BuildDir(db.dirPath, db.opts); err != nil {
+		return err
+	}
+	index, err := openJournaledIndex(db.dirPath)
+	if err != nil {
+		return err
+	}
+	if db.index != nil {
+		db.index.Close()
+	}
+	db.index = index
+	return nil
+}
+
+// Query runs SELECT statement and returns matching documents.
+func (db *DB) Query(query string) ([]Document, error) {
+	if db.closed {
+		return nil, ErrClosed
+	}
+	return QueryDocuments(db.dirPath, db.index, query)
+}
+
+// Search runs SELECT statement like Query, ordering documents by relevance to its MATCH conditions.
+func (db *DB) Search(query string) ([]ScoredDocument, error) {
+	if db.closed {
+		return nil, ErrClosed
+	}
+	return SearchDocuments(db.dirPath, db.index, query)
+}
+
+// Document returns json of the document ref, the exact line for lines of collection files.
+func (db *DB) Document(ref Ref) ([]byte, error) {
+	if db.closed {
+		return nil, ErrClosed
+	}
+	return ReadDocument(db.dirPath, ref)
+}
+
+// Exec runs SELECT, INSERT, UPDATE or DELETE statement and returns ids of affected documents.
+func (db *DB) Exec(query string) ([]Ref, error) {
+	if db.closed {
+		return nil, ErrClosed
+	}
+	return ExecStatement(db.dirPath, db.index, query)
+}
+
+// Close folds INDEX.delta and TEXT.delta journals into INDEX and TEXT files and unmaps the index.
+func (db *DB) Close() error {
+	if db.closed {
+		return ErrClosed
+	}
+	_, err := os.Stat(deltaPath(db.dirPath))
+	if err == nil {
+		err = db.index.compact(db.dirPath)
+	} else if errors.Is(err, os.ErrNotExist) {
+		err = nil
+	}
+	if _, statErr := os.Stat(filepath.Join(db.dirPath, textDeltaFileName)); statErr == nil && err == nil {
+		err = SaveTextIndex(db.index.text, db.dirPath)
+	}
+	if closeErr := db.index.Close(); err == nil {
+		err = closeErr
+	}
+	db.index = nil
+	db.closed = true
+	return err
+}
@@ -0,0 +1,161 @@
+package nosqlite
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+// Check if opened database is queried and journal is folded into INDEX on Close.
+func TestOpenQueryClose(t *testing.T) {
+	dirPath := copyDb(t)
+	db, err := Open(dirPath)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+
+	ids, err := db.Exec(`INSERT INTO c VALUES '{"name": "Ann", "age": 31}'`)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if !compareSlices(ids, []Ref{2}) {
+		t.Fatalf("Expected ids different than actual:\n%v\n%v", []Ref{2}, ids)
+	}
+
+	documents, err := db.Query("SELECT c.name FROM c WHERE c.age > 20")
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	assertDocuments(t, documents, []Document{
+		{0, "0", map[string]interface{}{"name": "Elliot"}},
+		{2, "2", map[string]interface{}{"name": "Ann"}},
+	})
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if _, err := os.Stat(deltaPath(dirPath)); !os.IsNotExist(err) {
+		t.Fatalf("Expected Close to fold the journal into INDEX")
+	}
+	if _, err := db.Query("SELECT * FROM c"); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Expected ErrClosed, got %v", err)
+	}
+
+	expected := indexTestFiles(t, []string{dirPath + "/0", dirPath + "/1", dirPath + "/2"})
+	if index := readTestIndex(t, dirPath); !compareIndexes(expected, index) {
+		t.Fatalf("Expected index different than actual:\n%v\n%v", expected, index)
+	}
+}
+
+// Check if directory without INDEX file is indexed when opened.
+func TestOpenIndexesDirectory(t *testing.T) {
+	dirPath := t.TempDir()
+	check(os.WriteFile(dirPath+"/elliot.json", readTestFile(t, "./db/0"), 0644))
+	check(os.WriteFile(dirPath+"/fraser.json", readTestFile(t, "./db/1"), 0644))
+
+	db, err := Open(dirPath)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	defer db.Close()
+
+	expected := indexTestFiles(t, []string{"./db/0", "./db/1"})
+	entries, err := db.Entries()
+	check(err)
+	if !compareIndexes(expected, entries) {
+		t.Fatalf("Expected index different than actual:\n%v\n%v", expected, entries)
+	}
+	if entry := entries[1]; entry.Key() != "/age" || entry.Type() != IntType || entry.Values()[0].Value() != int64(17) {
+		t.Fatalf("Expected /age entry, got %v", entry)
+	}
+
+	documents, err := db.Query("SELECT c.name FROM c WHERE c.type = 'Author'")
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	assertDocuments(t, documents, []Document{{1, "fraser.json", map[string]interface{}{"name": "Fraser"}}})
+
+	if _, err := Open(dirPath + "/missing"); err == nil {
+		t.Fatalf("Expected error for missing database directory")
+	}
+}
+
+// Check if documents keep ids returned by INSERT when the directory is indexed again.
+func TestIndexKeepsRefs(t *testing.T) {
+	dirPath := t.TempDir()
+	check(os.WriteFile(dirPath+"/fraser.json", readTestFile(t, "./db/1"), 0644))
+	db, err := Open(dirPath)
+	check(err)
+	defer db.Close()
+
+	for n := 1; n <= 12; n++ {
+		ids, err := db.Exec(fmt.Sprintf(`INSERT INTO c VALUES '{"n": %d}'`, n))
+		check(err)
+		if !compareSlices(ids, []Ref{Ref(n)}) {
+			t.Fatalf("Expected ids different than actual:\n%v\n%v", []Ref{Ref(n)}, ids)
+		}
+	}
+	_, err = db.Exec("DELETE FROM c WHERE c.n = 5")
+	check(err)
+	check(os.WriteFile(dirPath+"/ann.json", []byte(`{"n": 2}`), 0644))
+
+	if err := db.Index(); err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	doc, err := db.Document(2)
+	if err != nil || string(doc) != `{"n": 2}` {
+		t.Fatalf("Expected document 2 to be kept, got %s %v", doc, err)
+	}
+	ids, err := db.Exec("SELECT * FROM c WHERE c.n = 2")
+	check(err)
+	if expected := []Ref{2, 13}; !compareSlices(ids, expected) {
+		t.Fatalf("Expected ids different than actual:\n%v\n%v", expected, ids)
+	}
+	if _, err := db.Document(5); !errors.Is(err, ErrDocumentNotFound) {
+		t.Fatalf("Expected ErrDocumentNotFound for deleted document, got %v", err)
+	}
+}
+
+// Check if mapped index with changes journaled since INDEX was written answers like the whole decoded index.
+func TestOpenJournaledIndex(t *testing.T) {
+	dirPath := copyDb(t)
+	db, err := Open(dirPath)
+	check(err)
+	defer db.Close()
+
+	for _, statement := range []string{
+		`INSERT INTO c VALUES '{"name": "Ann", "age": 31}'`,
+		`INSERT INTO c VALUES '{"name": "Bob", "age": 12}'`,
+		`UPDATE c SET c.age = 45 WHERE c.name = 'Elliot'`,
+		`DELETE FROM c WHERE c.name = 'Bob'`,
+	} {
+		_, err := db.Exec(statement)
+		check(err)
+	}
+
+	// database opened again before the first one is closed replays the journal
+	reopened, err := Open(dirPath)
+	check(err)
+	defer reopened.Close()
+
+	index := readTestIndex(t, dirPath)
+	for _, query := range []string{
+		"SELECT * FROM c WHERE c.age > 20",
+		"SELECT * FROM c WHERE c.age = 45 OR c.name = 'Bob'",
+		"SELECT * FROM c WHERE NOT c.name = 'Ann'",
+		"SELECT * FROM c WHERE c.name LIKE '%o%'",
+	} {
+		expected, err := QueryIndex(&index, query)
+		check(err)
+		for _, db := range []*DB{db, reopened} {
+			actual, err := db.Exec(query)
+			if err != nil {
+				t.Fatalf("Got unexpected error for %s: %v", query, err)
+			}
+			if !compareSlices(expected, actual) {
+				t.Fatalf("Expected refs for %s different than actual:\n%v\n%v", query, expected, actual)
+			}
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package nosqlite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/jacnik/nosqlite/parser"
+)
+
+/* Incremental index maintenance.
+** Contribution of a single document is itself a small IndexT holding only its ref.
+** It is applied to the index of the directory, merged into (or subtracted from) an in memory IndexT
+** or kept next to the mapped INDEX by DB, and appended to the INDEX.delta journal,
+** which ReadIndex replays on top of INDEX.
+** SaveIndex writes a full snapshot and drops the journal.
+** TEXT file, when the directory has one, is updated along with the journal. */
+
+// INDEX.delta binary layout
+// {op byte = 'a' | 'r'}{flags = indexEncoding}{n bytes}{serialized document index}{op byte}{flags}{n bytes}{serialized document index}...
+//
+// records written before {flags} were added have op byte '+' | '-', no {flags} and plainEncoding
+
+type deltaOp byte
+
+const (
+	deltaAdd    deltaOp = '+'
+	deltaRemove deltaOp = '-'
+)
+
+// op bytes of records followed by {flags}
+const (
+	deltaAddRecord    = 'a'
+	deltaRemoveRecord = 'r'
+)
+
+const deltaFileName = "INDEX.delta"
+
+func deltaPath(dirPath string) string {
+	return dirPath + "/" + deltaFileName
+}
+
+// documentIndex returns index holding values of a single document under ref, with strings collated by collation.
+func documentIndex(doc []byte, ref Ref, collation Collation) (IndexT, error) {
+	flatten, err := flattenDocument(doc, collation)
+	if err != nil {
+		return nil, err
+	}
+	agg := make(aggregateT)
+	aggregateJson(agg, flatten, ref)
+	return indexAgregate(agg), nil
+}
+
+// DirIndex is index of a database directory which statements query and change, *IndexT
+// holding the whole index in memory or the mapped INDEX of DB.
+type DirIndex interface {
+	conditionIndex
+	// applyDelta merges delta into the index or subtracts it from the index.
+	applyDelta(op deltaOp, delta IndexT)
+	// compact writes the index as INDEX snapshot of dirPath, dropping INDEX.delta journal.
+	compact(dirPath string) error
+	// textIndex returns full-text index kept in memory with the index, nil when TEXT file is read instead.
+	textIndex() *TextIndex
+}
+
+func (index *IndexT) conditionRefs(key string, op parser.OpType, val interface{}, valueType IndexEntryType) fileRefs {
+	return getFileRefs(*index, key, op, val, valueType)
+}
+
+func (index *IndexT) applyDelta(op deltaOp, delta IndexT) {
+	switch op {
+	case deltaAdd:
+		mergeIndex(index, delta)
+	case deltaRemove:
+		subtractIndex(index, delta)
+	}
+}
+
+func (index *IndexT) compact(dirPath string) error {
+	return SaveIndex(*index, dirPath)
+}
+
+func (index *IndexT) textIndex() *TextIndex { return nil }
+
+func indexEntryKeyCmp(entry IndexEntry, key IndexEntry) int {
+	return valueWithTypeCmp(entry.key, key.key, entry.valueType, key.valueType)
+}
+
+// mergeIndex adds every ref of delta into index, keeping entries, values and refs sorted.
+func mergeIndex(index *IndexT, delta IndexT) {
+	for _, deltaEntry := range delta {
+		entryIdx, found := slices.BinarySearchFunc(*index, deltaEntry, indexEntryKeyCmp)
+		if !found {
+			*index = slices.Insert(*index, entryIdx, IndexEntry{deltaEntry.key, deltaEntry.valueType, nil})
+		}
+		entry := &(*index)[entryIdx]
+
+		for _, deltaValue := range deltaEntry.values {
+			valueIdx, found := slices.BinarySearchFunc(entry.values, deltaValue.value, func(v ValueRefs, val interface{}) int {
+				return aggregateValueCmp(v.value, val, entry.valueType)
+			})
+			if !found {
+				entry.values = slices.Insert(entry.values, valueIdx, ValueRefs{deltaValue.value, nil})
+			}
+			valueRefs := &entry.values[valueIdx]
+
+			for _, ref := range deltaValue.refs {
+				if refIdx, found := slices.BinarySearch(valueRefs.refs, ref); !found {
+					valueRefs.refs = slices.Insert(valueRefs.refs, refIdx, ref)
+				}
+			}
+		}
+	}
+}
+
+// subtractIndex removes every ref of delta from index,
+// dropping values and entries that are no longer referenced.
+func subtractIndex(index *IndexT, delta IndexT) {
+	for _, deltaEntry := range delta {
+		entryIdx, found := slices.BinarySearchFunc(*index, deltaEntry, indexEntryKeyCmp)
+		if !found {
+			continue
+		}
+		entry := &(*index)[entryIdx]
+
+		for _, deltaValue := range deltaEntry.values {
+			valueIdx, found := slices.BinarySearchFunc(entry.values, deltaValue.value, func(v ValueRefs, val interface{}) int {
+				return aggregateValueCmp(v.value, val, entry.valueType)
+			})
+			if !found {
+				continue
+			}
+			valueRefs := &entry.values[valueIdx]
+
+			for _, ref := range deltaValue.refs {
+				if refIdx, found := slices.BinarySearch(valueRefs.refs, ref); found {
+					valueRefs.refs = slices.Delete(valueRefs.refs, refIdx, refIdx+1)
+				}
+			}
+			if len(valueRefs.refs) == 0 {
+				entry.values = slices.Delete(entry.values, valueIdx, valueIdx+1)
+			}
+		}
+		if len(entry.values) == 0 {
+			*index = slices.Delete(*index, entryIdx, entryIdx+1)
+		}
+	}
+}
+
+func serializeIndexDelta(op deltaOp, delta IndexT) []byte {
+	deltaBytes := serializeIndex(delta, currentEncoding)
+
+	opByte := byte(deltaAddRecord)
+	if op == deltaRemove {
+		opByte = deltaRemoveRecord
+	}
+	buff := bytes.NewBuffer(make([]byte, 0, len(deltaBytes)+6))
+	buff.WriteByte(opByte)                                        // {op byte}
+	buff.WriteByte(byte(currentEncoding))                         // {flags}
+	binary.Write(buff, binary.BigEndian, size_t(len(deltaBytes))) // {n bytes}
+	buff.Write(deltaBytes)                                        // {serialized document index}
+	return buff.Bytes()
+}
+
+// replayIndexDelta applies every record of INDEX.delta journal to index.
+func replayIndexDelta(index DirIndex, bytes []byte) error {
+	for pos := 0; pos < len(bytes); {
+		op, encoding := deltaOp(bytes[pos]), plainEncoding
+		pos++
+		switch op {
+		case deltaAddRecord, deltaRemoveRecord:
+			if pos >= len(bytes) {
+				return fmt.Errorf("%w: truncated delta record at byte %d", ErrCorruptIndex, pos)
+			}
+			encoding = indexEncoding(bytes[pos])
+			if encoding&^knownEncodings != 0 {
+				return fmt.Errorf("%w: unsupported delta record flags %#x", ErrCorruptIndex, encoding)
+			}
+			op = deltaAdd
+			if bytes[pos-1] == deltaRemoveRecord {
+				op = deltaRemove
+			}
+			pos++
+		}
+
+		if pos+4 > len(bytes) {
+			return fmt.Errorf("%w: truncated delta record at byte %d", ErrCorruptIndex, pos)
+		}
+		n := int(binary.BigEndian.Uint32(bytes[pos : pos+4]))
+		pos += 4
+		if pos+n > len(bytes) {
+			return fmt.Errorf("%w: truncated delta record at byte %d", ErrCorruptIndex, pos)
+		}
+		if op != deltaAdd && op != deltaRemove {
+			return fmt.Errorf("%w: unknown delta op %c", ErrCorruptIndex, op)
+		}
+		delta, err := deserializeIndex(bytes[pos:pos+n], encoding)
+		if err != nil {
+			return err
+		}
+		pos += n
+		index.applyDelta(op, delta)
+	}
+	return nil
+}
+
+func appendIndexDelta(dirPath string, records ...[]byte) error {
+	f, err := os.OpenFile(deltaPath(dirPath), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		if _, err := f.Write(record); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
+
+// compactIndex folds the journal into a new INDEX snapshot once it outgrows the snapshot itself.
+func compactIndex(dirPath string, index DirIndex) error {
+	deltaInfo, err := os.Stat(deltaPath(dirPath))
+	if err != nil {
+		return err
+	}
+	indexInfo, err := os.Stat(dirPath + "/INDEX")
+	if err == nil && deltaInfo.Size() <= indexInfo.Size() {
+		return nil
+	}
+	return index.compact(dirPath)
+}
+
+func applyIndexDelta(dirPath string, index DirIndex, ops []deltaOp, deltas []IndexT) error {
+	records := make([][]byte, 0, len(deltas))
+	for i, delta := range deltas {
+		index.applyDelta(ops[i], delta)
+		records = append(records, serializeIndexDelta(ops[i], delta))
+	}
+	if err := appendIndexDelta(dirPath, records...); err != nil {
+		return err
+	}
+	return compactIndex(dirPath, index)
+}
+
+// AddToIndex adds values of a json document under ref to index stored in dirPath
+// without rebuilding it.
+func AddToIndex(dirPath string, index DirIndex, ref Ref, doc []byte) error {
+	collation, err := ReadCollation(dirPath)
+	if err != nil {
+		return err
+	}
+	delta, err := documentIndex(doc, ref, collation)
+	if err != nil {
+		return err
+	}
+	if err := applyIndexDelta(dirPath, index, []deltaOp{deltaAdd}, []IndexT{delta}); err != nil {
+		return err
+	}
+	return updateTextIndex(dirPath, index, ref, nil, doc)
+}
+
+// RemoveFromIndex removes values of a json document previously added under ref.
+func RemoveFromIndex(dirPath string, index DirIndex, ref Ref, doc []byte) error {
+	collation, err := ReadCollation(dirPath)
+	if err != nil {
+		return err
+	}
+	delta, err := documentIndex(doc, ref, collation)
+	if err != nil {
+		return err
+	}
+	if err := applyIndexDelta(dirPath, index, []deltaOp{deltaRemove}, []IndexT{delta}); err != nil {
+		return err
+	}
+	return updateTextIndex(dirPath, index, ref, doc, nil)
+}
+
+// ReplaceInIndex swaps values of oldDoc for values of newDoc under ref.
+func ReplaceInIndex(dirPath string, index DirIndex, ref Ref, oldDoc, newDoc []byte) error {
+	collation, err := ReadCollation(dirPath)
+	if err != nil {
+		return err
+	}
+	oldDelta, err := documentIndex(oldDoc, ref, collation)
+	if err != nil {
+		return err
+	}
+	newDelta, err := documentIndex(newDoc, ref, collation)
+	if err != nil {
+		return err
+	}
+	if err := applyIndexDelta(dirPath, index, []deltaOp{deltaRemove, deltaAdd}, []IndexT{oldDelta, newDelta}); err != nil {
+		return err
+	}
+	return updateTextIndex(dirPath, index, ref, oldDoc, newDoc)
+}
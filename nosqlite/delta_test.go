@@ -0,0 +1,127 @@
+package nosqlite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"testing"
+)
+
+// Check if merging and subtracting single documents gives the same index as a full rebuild.
+func TestMergeAndSubtractIndex(t *testing.T) {
+	paths := []string{"./db/0", "./db/1"}
+	expected := indexTestFiles(t, paths)
+
+	index := IndexT{}
+	for ref, path := range paths {
+		delta, err := documentIndex(readTestFile(t, path), Ref(ref), BinaryCollation)
+		check(err)
+		mergeIndex(&index, delta)
+	}
+	if !compareIndexes(expected, index) {
+		t.Fatalf("Expected index different than actual:\n%v\n%v", expected, index)
+	}
+
+	delta, err := documentIndex(readTestFile(t, "./db/0"), 0, BinaryCollation)
+	check(err)
+	subtractIndex(&index, delta)
+	for _, entry := range index {
+		for _, value := range entry.values {
+			if !compareSlices(value.refs, []Ref{1}) {
+				t.Fatalf("Expected only ref 1 to be left, got %v: %v", entry.key, value)
+			}
+		}
+	}
+
+	delta, err = documentIndex(readTestFile(t, "./db/1"), 1, BinaryCollation)
+	check(err)
+	subtractIndex(&index, delta)
+	if len(index) != 0 {
+		t.Fatalf("Expected empty index, got:\n%v", index)
+	}
+}
+
+// Check if changes are journaled to INDEX.delta and replayed by ReadIndex.
+func TestIndexDeltaJournal(t *testing.T) {
+	dirPath := copyDb(t)
+	index := readTestIndex(t, dirPath)
+	snapshot := readTestFile(t, dirPath+"/INDEX")
+
+	doc := []byte(`{"age": 40}`)
+	if err := AddToIndex(dirPath, &index, 5, doc); err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if !bytes.Equal(snapshot, readTestFile(t, dirPath+"/INDEX")) {
+		t.Fatalf("Expected INDEX snapshot not to be rewritten")
+	}
+	if replayed := readTestIndex(t, dirPath); !compareIndexes(index, replayed) {
+		t.Fatalf("Expected replayed index different than actual:\n%v\n%v", index, replayed)
+	}
+
+	if err := ReplaceInIndex(dirPath, &index, 5, doc, []byte(`{"age": 41}`)); err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if replayed := readTestIndex(t, dirPath); !compareIndexes(index, replayed) {
+		t.Fatalf("Expected replayed index different than actual:\n%v\n%v", index, replayed)
+	}
+
+	if err := RemoveFromIndex(dirPath, &index, 5, []byte(`{"age": 41}`)); err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if original := indexTestFiles(t, []string{"./db/0", "./db/1"}); !compareIndexes(original, index) {
+		t.Fatalf("Expected index different than actual:\n%v\n%v", original, index)
+	}
+
+	check(SaveIndex(index, dirPath))
+	if _, err := os.Stat(deltaPath(dirPath)); !os.IsNotExist(err) {
+		t.Fatalf("Expected SaveIndex to drop the journal")
+	}
+}
+
+// Check if journal is folded into INDEX once it outgrows the snapshot.
+func TestIndexDeltaCompaction(t *testing.T) {
+	dirPath := copyDb(t)
+	index := readTestIndex(t, dirPath)
+
+	for ref := Ref(10); ref < 20; ref++ {
+		check(AddToIndex(dirPath, &index, ref, readTestFile(t, "./db/0")))
+	}
+
+	deltaInfo, err := os.Stat(deltaPath(dirPath))
+	if err == nil {
+		indexInfo, _ := os.Stat(dirPath + "/INDEX")
+		if deltaInfo.Size() > indexInfo.Size() {
+			t.Fatalf("Expected journal to be compacted")
+		}
+	}
+	if replayed := readTestIndex(t, dirPath); !compareIndexes(index, replayed) {
+		t.Fatalf("Expected replayed index different than actual:\n%v\n%v", index, replayed)
+	}
+}
+
+// Check if journal keeps strings with NUL bytes and still replays records written without flags.
+func TestIndexDeltaJournalEncoding(t *testing.T) {
+	dirPath := copyDb(t)
+	index := readTestIndex(t, dirPath)
+
+	check(AddToIndex(dirPath, &index, 5, []byte(`{"name": "Null\u0000Byte", "key\u0000": true}`)))
+	if replayed := readTestIndex(t, dirPath); !compareIndexes(index, replayed) {
+		t.Fatalf("Expected replayed index different than actual:\n%v\n%v", index, replayed)
+	}
+
+	delta, err := documentIndex([]byte(`{"age": 40}`), 6, BinaryCollation)
+	check(err)
+	deltaBytes := serializeIndex(delta, plainEncoding)
+	record := binary.BigEndian.AppendUint32([]byte{byte(deltaAdd)}, uint32(len(deltaBytes)))
+	check(appendIndexDelta(dirPath, append(record, deltaBytes...)))
+	mergeIndex(&index, delta)
+	if replayed := readTestIndex(t, dirPath); !compareIndexes(index, replayed) {
+		t.Fatalf("Expected replayed index different than actual:\n%v\n%v", index, replayed)
+	}
+
+	check(appendIndexDelta(dirPath, []byte{deltaAddRecord, 0x80, 0, 0, 0, 0}))
+	if _, err := ReadIndex(dirPath); !errors.Is(err, ErrCorruptIndex) {
+		t.Fatalf("Expected ErrCorruptIndex for unsupported flags, got %v", err)
+	}
+}
@@ -0,0 +1,353 @@
+package nosqlite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jacnik/nosqlite/parser"
+)
+
+/* DOCS file maps file refs of the INDEX to document paths relative to the database directory.
+** Without DOCS file a document is expected to be named by its decimal ref, like the ones written by InsertDocument. */
+
+// DOCS file binary layout
+// {n of documents}{path}\x00{path}\x00...   empty path marks a deleted document
+
+const docsFileName = "DOCS"
+
+type DocsT []string
+
+func serializeDocs(docs DocsT) []byte {
+	stringSep := byte(NUL)
+
+	buff := bytes.NewBuffer(make([]byte, 0, 512))
+	binary.Write(buff, binary.BigEndian, size_t(len(docs))) // {n of documents}
+	for _, path := range docs {
+		buff.WriteString(path)    // {path}
+		buff.WriteByte(stringSep) // {string sep}
+	}
+	return buff.Bytes()
+}
+
+func deserializeDocs(bytes []byte) (DocsT, error) {
+	stringSep := byte(NUL)
+
+	if len(bytes) < 4 {
+		return nil, fmt.Errorf("%w: truncated DOCS file", ErrCorruptIndex)
+	}
+	nDocs := binary.BigEndian.Uint32(bytes[0:4])
+	// every path takes at least its separator
+	if int64(nDocs) > int64(len(bytes)-4) {
+		return nil, fmt.Errorf("%w: too many documents in DOCS file", ErrCorruptIndex)
+	}
+	docs := make(DocsT, 0, nDocs)
+	for pos := 4; size_t(len(docs)) < size_t(nDocs); {
+		endPos := pos
+		for ; endPos < len(bytes) && bytes[endPos] != stringSep; endPos++ {
+		}
+		if endPos >= len(bytes) {
+			return nil, fmt.Errorf("%w: truncated DOCS file at byte %d", ErrCorruptIndex, pos)
+		}
+		docs = append(docs, string(bytes[pos:endPos]))
+		pos = endPos + 1
+	}
+	return docs, nil
+}
+
+func SaveDocs(docs DocsT, dirPath string) error {
+	return os.WriteFile(filepath.Join(dirPath, docsFileName), serializeDocs(docs), 0644)
+}
+
+// ReadDocs returns nil DocsT when dirPath has no DOCS file.
+func ReadDocs(dirPath string) (DocsT, error) {
+	docsBytes, err := os.ReadFile(filepath.Join(dirPath, docsFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return deserializeDocs(docsBytes)
+}
+
+// path returns document path relative to the database directory, or "" for deleted documents.
+func (docs DocsT) path(ref Ref) string {
+	if docs == nil {
+		return strconv.FormatUint(uint64(ref), 10)
+	}
+	if int(ref) < len(docs) {
+		return docs[ref]
+	}
+	return ""
+}
+
+// dirDocuments returns paths of documents of dirPath selected by opts, relative to it with '/' separators,
+// together with their locations by refs before dirPath is indexed again, joined with dirPath like locations
+// of IndexDocuments. Without DOCS file, documents named by a decimal number keep it as their ref.
+// Documents stored by INSERT, which are named by their ref, are kept even when opts do not select them.
+func dirDocuments(dirPath string, opts DirOptions) ([]string, DocsT, error) {
+	names, err := walkDir(dirPath, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	docs, err := ReadDocs(dirPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	isRefName := func(name string, ref uint64) bool {
+		return name == strconv.FormatUint(ref, 10)
+	}
+	if docs == nil {
+		docs = DocsT{}
+		for _, name := range names {
+			if ref, err := strconv.ParseUint(name, 10, 32); err == nil && isRefName(name, ref) {
+				for uint64(len(docs)) <= ref {
+					docs = append(docs, "")
+				}
+				docs[ref] = name
+			}
+		}
+	} else {
+		walked := make(map[string]bool, len(names))
+		for _, name := range names {
+			walked[name] = true
+		}
+		for ref, location := range docs {
+			if location == "" || walked[location] || !isRefName(location, uint64(ref)) {
+				continue
+			}
+			if info, err := os.Stat(filepath.Join(dirPath, location)); err == nil && info.Mode().IsRegular() {
+				names = append(names, location)
+			}
+		}
+	}
+
+	previous := make(DocsT, len(docs))
+	for ref, location := range docs {
+		if location != "" {
+			previous[ref] = filepath.Join(dirPath, filepath.FromSlash(location))
+		}
+	}
+	return names, previous, nil
+}
+
+// IndexDir indexes every document in the tree of dirPath, returning the index and matching refs to document locations
+// relative to dirPath, file paths or file paths followed by line offsets for lines of collection files.
+func IndexDir(dirPath string) (IndexT, DocsT, error) {
+	return IndexDirWith(dirPath, DirOptions{})
+}
+
+// IndexDirWith indexes documents in the tree of dirPath selected by opts like IndexDir, collating them with opts.Collation.
+// Documents keep refs of an earlier index, see dirDocuments.
+func IndexDirWith(dirPath string, opts DirOptions) (IndexT, DocsT, error) {
+	names, previous, err := dirDocuments(dirPath, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	paths := make([]string, 0, len(names))
+	for _, name := range names {
+		paths = append(paths, filepath.Join(dirPath, filepath.FromSlash(name)))
+	}
+	index, docs, err := IndexDocuments(paths, IndexOptions{Collation: opts.Collation, Docs: previous})
+	if err != nil {
+		return nil, nil, err
+	}
+	docs, err = relativeDocs(dirPath, docs)
+	if err != nil {
+		return nil, nil, err
+	}
+	return index, docs, nil
+}
+
+// BuildDir indexes documents in the tree of dirPath selected by opts like IndexDirWith, but writes INDEX,
+// DOCS and TEXT files of dirPath through BuildIndex instead of returning the index, keeping memory bounded.
+func BuildDir(dirPath string, opts DirOptions) error {
+	names, previous, err := dirDocuments(dirPath, opts)
+	if err != nil {
+		return err
+	}
+	paths := make([]string, 0, len(names))
+	for _, name := range names {
+		paths = append(paths, filepath.Join(dirPath, filepath.FromSlash(name)))
+	}
+	return BuildIndex(paths, dirPath, BuildOptions{Collation: opts.Collation, Docs: previous, TextKeys: opts.TextKeys})
+}
+
+// relativeDocs turns locations of documents into locations relative to dirPath with '/' separators, like in DOCS file.
+func relativeDocs(dirPath string, docs DocsT) (DocsT, error) {
+	absDirPath, err := filepath.Abs(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	for ref, location := range docs {
+		if location == "" {
+			continue
+		}
+		absLocation, err := filepath.Abs(location)
+		if err != nil {
+			return nil, err
+		}
+		relLocation, err := filepath.Rel(absDirPath, absLocation)
+		if err != nil {
+			return nil, err
+		}
+		docs[ref] = filepath.ToSlash(relLocation)
+	}
+	return docs, nil
+}
+
+type Document struct {
+	Ref   Ref
+	Path  string      // path relative to the database directory, followed by #offset for lines of collection files
+	Value interface{} // whole json document or its projection on SELECT list
+}
+
+func getJsonPath(unflatten interface{}, path []string) (interface{}, bool) {
+	if len(path) == 0 {
+		return unflatten, true
+	}
+
+	switch v := unflatten.(type) {
+	case map[string]interface{}:
+		if item, ok := v[path[0]]; ok {
+			return getJsonPath(item, path[1:])
+		}
+	case []interface{}:
+		if i, err := strconv.Atoi(path[0]); err == nil && i >= 0 && i < len(v) {
+			return getJsonPath(v[i], path[1:])
+		}
+	}
+	return nil, false
+}
+
+// projectJson keeps only selected keys, nested like in the document so that `SELECT c.a.name, c.b.name`
+// returns {"a": {"name": ...}, "b": {"name": ...}}.
+func projectJson(unflatten interface{}, selection []string) interface{} {
+	if len(selection) == 0 {
+		return unflatten
+	}
+
+	var projection interface{} = make(map[string]interface{}, len(selection))
+	for _, key := range selection {
+		path := strings.Split(strings.TrimPrefix(key, "/"), "/")
+		if value, ok := getJsonPath(unflatten, path); ok {
+			// found paths always fit in the projection, which holds only their objects and arrays
+			if nested, err := setJsonPath(projection, path, value); err == nil {
+				projection = nested
+			}
+		}
+	}
+	return projection
+}
+
+// ReadDocument returns json of the document ref stored in dirPath, the exact line for lines of collection files.
+func ReadDocument(dirPath string, ref Ref) ([]byte, error) {
+	path, err := documentPath(dirPath, ref)
+	if err != nil {
+		return nil, err
+	}
+	if path == "" {
+		return nil, fmt.Errorf("%w: %d", ErrDocumentNotFound, ref)
+	}
+	doc, err := readLocation(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %d", ErrDocumentNotFound, ref)
+	}
+	return doc, err
+}
+
+// ScoredDocument is a document found by SearchDocuments together with its relevance.
+type ScoredDocument struct {
+	Document
+	Score float64 // sum of BM25 scores of the document for MATCH conditions of the query
+}
+
+func parseSelect(query string) (parser.Program, error) {
+	program, err := parser.Parse(query)
+	if err != nil {
+		return program, err
+	}
+	if program.Kind != parser.Select {
+		return program, errors.New("Only SELECT statements return documents")
+	}
+	return program, nil
+}
+
+// QueryDocuments runs SELECT statement and returns matching documents read from dirPath.
+func QueryDocuments(dirPath string, index DirIndex, query string) ([]Document, error) {
+	program, err := parseSelect(query)
+	if err != nil {
+		return nil, err
+	}
+	return selectDocuments(dirPath, index, program, nil)
+}
+
+// SearchDocuments runs SELECT statement like QueryDocuments and orders matching documents
+// by their relevance to MATCH conditions of the statement, the most relevant first.
+func SearchDocuments(dirPath string, index DirIndex, query string) ([]ScoredDocument, error) {
+	program, err := parseSelect(query)
+	if err != nil {
+		return nil, err
+	}
+	text, err := dirTextIndex(dirPath, index)
+	if err != nil {
+		return nil, err
+	}
+	documents, err := selectDocuments(dirPath, index, program, text)
+	if err != nil {
+		return nil, err
+	}
+
+	scored := make([]ScoredDocument, len(documents))
+	for i, document := range documents {
+		scored[i].Document = document
+		for _, instruction := range program.Instructions {
+			if instruction.Op == parser.Match {
+				scored[i].Score += text.score(instruction.Key, instruction.Val.(string), document.Ref)
+			}
+		}
+	}
+	// documents come sorted by ref, so equally relevant ones keep that order
+	sort.SliceStable(scored, func(i, j int) bool { return scored[i].Score > scored[j].Score })
+	return scored, nil
+}
+
+// selectDocuments reads documents matching SELECT program from dirPath,
+// MATCH conditions are run against text or against full-text index of dirPath when text is nil.
+func selectDocuments(dirPath string, index DirIndex, program parser.Program, text *TextIndex) ([]Document, error) {
+	docs, err := ReadDocs(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	matched, err := evalDirProgram(dirPath, index, program, text)
+	if err != nil {
+		return nil, err
+	}
+	refs := refsToSlice(matched)
+
+	documents := make([]Document, 0, len(refs))
+	for _, ref := range refs {
+		path := docs.path(ref)
+		if path == "" {
+			continue
+		}
+		content, err := readLocation(filepath.Join(dirPath, path))
+		if err != nil {
+			return nil, err
+		}
+		unflatten, err := parseJson(content)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		documents = append(documents, Document{ref, path, projectJson(unflatten, program.Selection)})
+	}
+	return documents, nil
+}
@@ -0,0 +1,132 @@
+package nosqlite
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+)
+
+// Check if DOCS mapping can be serialized and deserialized back.
+func TestSerializeAndDeserializeDocs(t *testing.T) {
+	docs := DocsT{"elliot.json", "", "authors/fraser.json"}
+
+	deserialized, err := deserializeDocs(serializeDocs(docs))
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if !compareSlices(docs, deserialized) {
+		t.Fatalf("Deserialized docs different than original:\n%v\n%v", docs, deserialized)
+	}
+
+	if _, err := deserializeDocs(serializeDocs(docs)[:10]); err == nil {
+		t.Fatalf("Expected error for truncated DOCS file")
+	}
+
+	corrupt := serializeDocs(docs)
+	copy(corrupt, []byte{0xff, 0xff, 0xff, 0xff})
+	if _, err := deserializeDocs(corrupt); !errors.Is(err, ErrCorruptIndex) {
+		t.Fatalf("Expected ErrCorruptIndex for corrupt DOCS header, got %v", err)
+	}
+}
+
+func assertDocuments(t *testing.T, documents []Document, expected []Document) {
+	if len(documents) != len(expected) {
+		t.Fatalf("Expected documents different than actual:\n%v\n%v", expected, documents)
+	}
+	for i, document := range documents {
+		actualValue, _ := json.Marshal(document.Value)
+		expectedValue, _ := json.Marshal(expected[i].Value)
+		if document.Ref != expected[i].Ref || document.Path != expected[i].Path || string(actualValue) != string(expectedValue) {
+			t.Fatalf("Expected documents different than actual:\n%v\n%v", expected, documents)
+		}
+	}
+}
+
+// Check if query returns whole documents and projections on SELECT list.
+func TestQueryDocuments(t *testing.T) {
+	index := readTestIndex(t, "./db")
+
+	documents, err := QueryDocuments("./db", &index, "SELECT c.name, c.social.twitter FROM c WHERE c.age > 20")
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	assertDocuments(t, documents, []Document{
+		{0, "0", map[string]interface{}{"name": "Elliot", "social": map[string]interface{}{"twitter": "https://twitter.com"}}},
+	})
+
+	documents, err = QueryDocuments("./db", &index, "SELECT c.social.facebook, c.social.twitter, c.arr[1] FROM c WHERE c.age > 20")
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	assertDocuments(t, documents, []Document{
+		{0, "0", map[string]interface{}{
+			"social": map[string]interface{}{"facebook": "https://facebook.com", "twitter": "https://twitter.com"},
+			"arr":    map[string]interface{}{"1": 3},
+		}},
+	})
+
+	documents, err = QueryDocuments("./db", &index, "SELECT * FROM c WHERE c.type = 'Author'")
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	author, err := parseJson(readTestFile(t, "./db/1"))
+	check(err)
+	assertDocuments(t, documents, []Document{{1, "1", author}})
+
+	documents, err = QueryDocuments("./db", &index, "SELECT c.arr FROM c")
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	assertDocuments(t, documents, []Document{
+		{0, "0", map[string]interface{}{"arr": []interface{}{2.0, 3.0}}},
+		{1, "1", map[string]interface{}{}},
+	})
+}
+
+// Check if refs are mapped to arbitrary file names through DOCS file.
+func TestQueryDocumentsThroughDocs(t *testing.T) {
+	dirPath := t.TempDir()
+	check(os.WriteFile(dirPath+"/elliot.json", readTestFile(t, "./db/0"), 0644))
+	check(os.WriteFile(dirPath+"/fraser.json", readTestFile(t, "./db/1"), 0644))
+
+	index, docs, err := IndexDir(dirPath)
+	check(err)
+	check(SaveIndex(index, dirPath))
+	check(SaveDocs(docs, dirPath))
+
+	documents, err := QueryDocuments(dirPath, &index, "SELECT c.name FROM c WHERE c.age < 20")
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	assertDocuments(t, documents, []Document{{1, "fraser.json", map[string]interface{}{"name": "Fraser"}}})
+
+	id, err := InsertDocument(dirPath, &index, []byte(`{"name": "Ann", "age": 12}`))
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	check(DeleteDocument(dirPath, &index, 0))
+
+	documents, err = QueryDocuments(dirPath, &index, "SELECT c.name FROM c")
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	assertDocuments(t, documents, []Document{
+		{1, "fraser.json", map[string]interface{}{"name": "Fraser"}},
+		{id, "2", map[string]interface{}{"name": "Ann"}},
+	})
+	if _, err := os.Stat(dirPath + "/elliot.json"); !os.IsNotExist(err) {
+		t.Fatalf("Expected deleted document file to be removed")
+	}
+}
+
+// Check if selected keys with the same last level are all kept in the projection.
+func TestProjectJsonCollision(t *testing.T) {
+	unflatten, err := parseJson([]byte(`{"a": {"name": "x"}, "b": {"name": "y", "id": 1}}`))
+	check(err)
+
+	projection, _ := json.Marshal(projectJson(unflatten, []string{"/a/name", "/b/name"}))
+	if expected := `{"a":{"name":"x"},"b":{"name":"y"}}`; string(projection) != expected {
+		t.Fatalf("Expected projection different than actual:\n%s\n%s", expected, projection)
+	}
+}
@@ -0,0 +1,647 @@
+package nosqlite
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+/* Full-text index of string values under configured keys.
+** Strings are split into words on every character which is neither a letter nor a digit,
+** words are lower cased and composed canonically, stopwords are dropped and the rest is stemmed
+** by stripping a few common English suffixes. Every key keeps postings of its terms, refs of documents
+** with frequencies of the term in them, and the number of terms of every document, used by BM25 ranking.
+** TEXT file is written next to INDEX by DB.Index. AddToIndex, RemoveFromIndex and ReplaceInIndex append
+** terms of changed documents to TEXT.delta journal, which ReadTextIndex replays on top of TEXT,
+** and fold it into a new TEXT file once it outgrows the file itself.
+** DB keeps the index in memory, other MATCH conditions read it when a query has them. */
+
+// TEXT file binary layout
+// {magic = "NSQT"}{format version = 2}{n of keys}{key}...{key}{n of documents}{ref}{n of terms}...{n of words}{word}{n of postings}{ref}{frequency}...{n of documents}...{crc32c}
+// every number is an uvarint, {key} and {word} are {n bytes}{bytes}
+// and every {ref} is stored as the difference to the previous ref of the same list;
+// documents and words of every key follow all the keys, in their order.
+// Format version 1 files have every {key} right before its documents.
+//
+// TEXT.delta journal binary layout
+// {op byte = 'a' | 'r'}{n bytes}{ref}{n of keys}{key}{n of terms}{term}...{key}...{op byte}...
+// every number is an uvarint, {key} and {term} are {n bytes}{bytes}
+// and terms are all terms of the document under the key, so replaying a record twice changes nothing
+
+const (
+	textFileName      = "TEXT"
+	textDeltaFileName = "TEXT.delta"
+	textMagic         = "NSQT"
+	textFormatVersion = 2
+)
+
+// BM25 parameters
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// ErrNoTextIndex is returned by MATCH conditions on keys without full-text index.
+var ErrNoTextIndex = errors.New("No full-text index")
+
+var stopwords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true, "but": true,
+	"by": true, "for": true, "from": true, "has": true, "have": true, "in": true, "into": true, "is": true,
+	"it": true, "its": true, "of": true, "on": true, "or": true, "that": true, "the": true, "their": true,
+	"this": true, "to": true, "was": true, "were": true, "will": true, "with": true,
+}
+
+// TextIndex holds full-text indexes of string values under its keys.
+type TextIndex struct {
+	fields map[string]*textField
+}
+
+type textField struct {
+	lengths     map[Ref]int            // n of terms of every document with the key
+	totalLength int                    // sum of lengths, for the average length of BM25
+	postings    map[string]map[Ref]int // frequencies of a term by refs of documents holding it
+}
+
+func newTextField() *textField {
+	return &textField{make(map[Ref]int), 0, make(map[string]map[Ref]int)}
+}
+
+// NewTextIndex returns empty full-text index of flattened keys like "/description".
+func NewTextIndex(keys []string) *TextIndex {
+	t := &TextIndex{make(map[string]*textField, len(keys))}
+	for _, key := range keys {
+		t.fields[key] = newTextField()
+	}
+	return t
+}
+
+// Keys returns sorted keys of the index.
+func (t *TextIndex) Keys() []string {
+	keys := make([]string, 0, len(t.fields))
+	for key := range t.fields {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// stemWord strips plural and a few other common English suffixes, keeping at least 3 letters of the stem.
+func stemWord(word string) string {
+	stripped := func(suffix, replacement string) (string, bool) {
+		stem, found := strings.CutSuffix(word, suffix)
+		if !found || utf8.RuneCountInString(stem)+len(replacement) < 3 {
+			return word, false
+		}
+		return stem + replacement, true
+	}
+	for _, rule := range [][2]string{{"sses", "ss"}, {"ies", "y"}, {"ss", "ss"}, {"s", ""}} {
+		if stem, ok := stripped(rule[0], rule[1]); ok {
+			word = stem
+			break
+		}
+	}
+	for _, suffix := range []string{"ingly", "edly", "ing", "ed", "ly"} {
+		if stem, ok := stripped(suffix, ""); ok {
+			return stem
+		}
+	}
+	return word
+}
+
+// analyzeText returns terms of text in order, stopwords excluded.
+func analyzeText(text string) []string {
+	words := strings.FieldsFunc(norm.NFC.String(strings.ToLower(text)), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	terms := words[:0]
+	for _, word := range words {
+		if !stopwords[word] {
+			terms = append(terms, stemWord(word))
+		}
+	}
+	return terms
+}
+
+// documentTerms returns terms of string values of flatten by keys of t, stopwords excluded.
+func (t *TextIndex) documentTerms(flatten flattenJsonT) map[string][]string {
+	terms := make(map[string][]string)
+	for flattenValue := range flatten {
+		if _, hasField := t.fields[flattenValue.key]; hasField && flattenValue.valueType == StrType {
+			terms[flattenValue.key] = append(terms[flattenValue.key], analyzeText(flattenValue.value.(string))...)
+		}
+	}
+	return terms
+}
+
+func (t *TextIndex) addDocument(ref Ref, flatten flattenJsonT) {
+	t.addTerms(ref, t.documentTerms(flatten))
+}
+
+// addTerms sets terms of document ref by keys, which must not have any other terms of it.
+func (t *TextIndex) addTerms(ref Ref, terms map[string][]string) {
+	for key, keyTerms := range terms {
+		field, hasField := t.fields[key]
+		if !hasField {
+			continue
+		}
+		field.totalLength += len(keyTerms) - field.lengths[ref]
+		field.lengths[ref] = len(keyTerms)
+
+		frequencies := make(map[string]int, len(keyTerms))
+		for _, term := range keyTerms {
+			frequencies[term]++
+		}
+		for term, frequency := range frequencies {
+			if field.postings[term] == nil {
+				field.postings[term] = make(map[Ref]int)
+			}
+			field.postings[term][ref] = frequency
+		}
+	}
+}
+
+// removeTerms removes terms of document ref by keys previously added.
+func (t *TextIndex) removeTerms(ref Ref, terms map[string][]string) {
+	for key, keyTerms := range terms {
+		field, hasField := t.fields[key]
+		if !hasField {
+			continue
+		}
+		field.totalLength -= field.lengths[ref]
+		delete(field.lengths, ref)
+		for _, term := range keyTerms {
+			delete(field.postings[term], ref)
+			if len(field.postings[term]) == 0 {
+				delete(field.postings, term)
+			}
+		}
+	}
+}
+
+func (t *TextIndex) field(key string) (*textField, error) {
+	if t != nil {
+		if field, hasField := t.fields[key]; hasField {
+			return field, nil
+		}
+	}
+	return nil, fmt.Errorf("%w of %q", ErrNoTextIndex, key)
+}
+
+// match returns refs of documents holding every term of text under key.
+func (t *TextIndex) match(key, text string) (fileRefs, error) {
+	field, err := t.field(key)
+	if err != nil {
+		return fileRefs{}, err
+	}
+	terms := analyzeText(text)
+	if len(terms) == 0 {
+		return fileRefs{}, nil
+	}
+	var fr fileRefs
+	for i, term := range terms {
+		termRefs := fileRefs{}
+		for ref := range field.postings[term] {
+			termRefs.Set(uint(ref))
+		}
+		if i == 0 {
+			fr = termRefs
+		} else {
+			fr = fr.Intersect(termRefs)
+		}
+	}
+	return fr, nil
+}
+
+// score returns BM25 score of document ref for terms of text under key.
+func (t *TextIndex) score(key, text string, ref Ref) float64 {
+	field, err := t.field(key)
+	if err != nil || len(field.lengths) == 0 {
+		return 0
+	}
+	nDocs := float64(len(field.lengths))
+	avgLength := float64(field.totalLength) / nDocs
+
+	score := 0.0
+	for _, term := range analyzeText(text) {
+		frequency := float64(field.postings[term][ref])
+		if frequency == 0 {
+			continue
+		}
+		nTermDocs := float64(len(field.postings[term]))
+		idf := math.Log((nDocs-nTermDocs+0.5)/(nTermDocs+0.5) + 1)
+		norm := bm25K1 * (1 - bm25B + bm25B*float64(field.lengths[ref])/avgLength)
+		score += idf * frequency * (bm25K1 + 1) / (frequency + norm)
+	}
+	return score
+}
+
+func sortedRefs[V any](refs map[Ref]V) []Ref {
+	sorted := make([]Ref, 0, len(refs))
+	for ref := range refs {
+		sorted = append(sorted, ref)
+	}
+	slices.Sort(sorted)
+	return sorted
+}
+
+func serializeTextIndex(t *TextIndex) []byte {
+	buff := make([]byte, 0, 512)
+	appendString := func(s string) {
+		buff = binary.AppendUvarint(buff, uint64(len(s)))
+		buff = append(buff, s...)
+	}
+
+	buff = append(buff, textMagic...)                        // {magic}
+	buff = binary.AppendUvarint(buff, textFormatVersion)     // {format version}
+	buff = binary.AppendUvarint(buff, uint64(len(t.fields))) // {n of keys}
+	keys := t.Keys()
+	for _, key := range keys {
+		appendString(key) // {key}
+	}
+	for _, key := range keys {
+		field := t.fields[key]
+		buff = binary.AppendUvarint(buff, uint64(len(field.lengths))) // {n of documents}
+		prev := Ref(0)
+		for _, ref := range sortedRefs(field.lengths) {
+			buff = binary.AppendUvarint(buff, uint64(ref-prev))           // {ref}
+			buff = binary.AppendUvarint(buff, uint64(field.lengths[ref])) // {n of terms}
+			prev = ref
+		}
+
+		words := make([]string, 0, len(field.postings))
+		for word := range field.postings {
+			words = append(words, word)
+		}
+		slices.Sort(words)
+		buff = binary.AppendUvarint(buff, uint64(len(words))) // {n of words}
+		for _, word := range words {
+			postings := field.postings[word]
+			appendString(word)                                       // {word}
+			buff = binary.AppendUvarint(buff, uint64(len(postings))) // {n of postings}
+			prev := Ref(0)
+			for _, ref := range sortedRefs(postings) {
+				buff = binary.AppendUvarint(buff, uint64(ref-prev))      // {ref}
+				buff = binary.AppendUvarint(buff, uint64(postings[ref])) // {frequency}
+				prev = ref
+			}
+		}
+	}
+	return binary.BigEndian.AppendUint32(buff, crc32cChecksum(buff)) // {crc32c}
+}
+
+func deserializeTextIndex(textBytes []byte) (*TextIndex, error) {
+	corrupt := func(msg string) error {
+		return fmt.Errorf("%w: TEXT file %s", ErrCorruptIndex, msg)
+	}
+	if len(textBytes) < len(textMagic)+4 || string(textBytes[:len(textMagic)]) != textMagic {
+		return nil, corrupt("has no magic")
+	}
+	body := textBytes[:len(textBytes)-4]
+	if crc32cChecksum(body) != binary.BigEndian.Uint32(textBytes[len(body):]) {
+		return nil, corrupt("checksum mismatch")
+	}
+
+	r := &uvarintReader{body: body, pos: len(textMagic)}
+	readRefs := func(yield func(ref Ref, n int)) {
+		ref := Ref(0)
+		for i, nRefs := 0, r.uvarint(); i < nRefs && !r.malformed; i++ {
+			ref += Ref(r.uvarint())
+			yield(ref, r.uvarint())
+		}
+	}
+
+	version := r.uvarint()
+	if !r.malformed && version != 1 && version != textFormatVersion {
+		return nil, corrupt(fmt.Sprintf("has unsupported format version %d", version))
+	}
+	nKeys := r.uvarint()
+	keys := []string{}
+	for i := 0; i < nKeys && version > 1 && !r.malformed; i++ {
+		keys = append(keys, r.string())
+	}
+	t := &TextIndex{make(map[string]*textField)}
+	for i := 0; i < nKeys && !r.malformed; i++ {
+		field := newTextField()
+		if version > 1 {
+			t.fields[keys[i]] = field
+		} else {
+			t.fields[r.string()] = field
+		}
+		readRefs(func(ref Ref, length int) {
+			field.lengths[ref] = length
+			field.totalLength += length
+		})
+		for j, nWords := 0, r.uvarint(); j < nWords && !r.malformed; j++ {
+			postings := make(map[Ref]int)
+			field.postings[r.string()] = postings
+			readRefs(func(ref Ref, frequency int) { postings[ref] = frequency })
+		}
+	}
+	if r.malformed || r.pos != len(body) {
+		return nil, corrupt(fmt.Sprintf("is malformed at byte %d", r.pos))
+	}
+	return t, nil
+}
+
+// uvarintReader reads uvarints and strings of TEXT and TEXT.delta files, any of them past the end is malformed.
+type uvarintReader struct {
+	body      []byte
+	pos       int
+	malformed bool
+}
+
+func (r *uvarintReader) uvarint() int {
+	n, size := binary.Uvarint(r.body[min(r.pos, len(r.body)):])
+	if size <= 0 || n > math.MaxInt32 {
+		r.malformed = true
+		return 0
+	}
+	r.pos += size
+	return int(n)
+}
+
+func (r *uvarintReader) string() string {
+	n := r.uvarint()
+	if r.malformed || r.pos+n > len(r.body) {
+		r.malformed = true
+		return ""
+	}
+	r.pos += n
+	return string(r.body[r.pos-n : r.pos])
+}
+
+// SaveTextIndex writes t to TEXT file of dirPath and drops TEXT.delta journal, whose changes t already holds.
+func SaveTextIndex(t *TextIndex, dirPath string) error {
+	textPath, err := writeTempFile(dirPath, ".TEXT-", serializeTextIndex(t))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(textPath)
+	return replaceTextFile(textPath, dirPath)
+}
+
+// replaceTextFile renames fully written tmpPath over TEXT file of dirPath and removes the journal,
+// whose changes the new file already holds.
+func replaceTextFile(tmpPath, dirPath string) error {
+	if err := os.Rename(tmpPath, filepath.Join(dirPath, textFileName)); err != nil {
+		return err
+	}
+	return removeFile(filepath.Join(dirPath, textDeltaFileName))
+}
+
+// ReadTextIndex returns nil TextIndex when dirPath has no TEXT file.
+func ReadTextIndex(dirPath string) (*TextIndex, error) {
+	textBytes, err := os.ReadFile(filepath.Join(dirPath, textFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	t, err := deserializeTextIndex(textBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	deltaBytes, err := os.ReadFile(filepath.Join(dirPath, textDeltaFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return t, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := replayTextDelta(t, deltaBytes); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// readTextKeys returns keys of TEXT file of dirPath reading only its beginning, none when dirPath has no TEXT file.
+func readTextKeys(dirPath string) ([]string, error) {
+	f, err := os.Open(filepath.Join(dirPath, textFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	corrupt := fmt.Errorf("%w: TEXT file has malformed keys", ErrCorruptIndex)
+	r := bufio.NewReader(f)
+	magic := make([]byte, len(textMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != textMagic {
+		return nil, corrupt
+	}
+	version, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, corrupt
+	}
+	if version == 1 {
+		t, err := ReadTextIndex(dirPath)
+		if err != nil {
+			return nil, err
+		}
+		return t.Keys(), nil
+	}
+	nKeys, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, corrupt
+	}
+	keys := []string{}
+	for i := uint64(0); i < nKeys; i++ {
+		n, err := binary.ReadUvarint(r)
+		if err != nil || n > math.MaxInt32 {
+			return nil, corrupt
+		}
+		key := make([]byte, n)
+		if _, err := io.ReadFull(r, key); err != nil {
+			return nil, corrupt
+		}
+		keys = append(keys, string(key))
+	}
+	return keys, nil
+}
+
+func removeTextIndex(dirPath string) error {
+	if err := removeFile(filepath.Join(dirPath, textFileName)); err != nil {
+		return err
+	}
+	return removeFile(filepath.Join(dirPath, textDeltaFileName))
+}
+
+// removeFile removes path, which may not exist.
+func removeFile(path string) error {
+	err := os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// IndexText builds full-text index of keys from every document stored in dirPath.
+func IndexText(dirPath string, keys []string) (*TextIndex, error) {
+	docs, err := ReadDocs(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := documentIds(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	t := NewTextIndex(keys)
+	for _, ref := range ids {
+		path := docs.path(ref)
+		content, err := readLocation(filepath.Join(dirPath, path))
+		if err != nil {
+			return nil, err
+		}
+		flatten, err := flattenDocument(content, BinaryCollation)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		t.addDocument(ref, flatten)
+	}
+	return t, nil
+}
+
+func serializeTextDelta(op byte, ref Ref, terms map[string][]string) []byte {
+	appendString := func(buff []byte, s string) []byte {
+		buff = binary.AppendUvarint(buff, uint64(len(s)))
+		return append(buff, s...)
+	}
+
+	keys := make([]string, 0, len(terms))
+	for key := range terms {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	record := binary.AppendUvarint(nil, uint64(ref))          // {ref}
+	record = binary.AppendUvarint(record, uint64(len(terms))) // {n of keys}
+	for _, key := range keys {
+		record = appendString(record, key)                             // {key}
+		record = binary.AppendUvarint(record, uint64(len(terms[key]))) // {n of terms}
+		for _, term := range terms[key] {
+			record = appendString(record, term) // {term}
+		}
+	}
+
+	buff := append([]byte{op}, binary.AppendUvarint(nil, uint64(len(record)))...) // {op byte}{n bytes}
+	return append(buff, record...)
+}
+
+// replayTextDelta applies every record of TEXT.delta journal to t.
+func replayTextDelta(t *TextIndex, deltaBytes []byte) error {
+	r := &uvarintReader{body: deltaBytes}
+	for r.pos < len(deltaBytes) {
+		recordPos, op := r.pos, deltaBytes[r.pos]
+		r.pos++
+		if n := r.uvarint(); r.malformed || r.pos+n > len(deltaBytes) {
+			return fmt.Errorf("%w: truncated TEXT.delta record at byte %d", ErrCorruptIndex, recordPos)
+		}
+		ref := Ref(r.uvarint())
+		terms := make(map[string][]string)
+		for i, nKeys := 0, r.uvarint(); i < nKeys && !r.malformed; i++ {
+			key := r.string()
+			keyTerms := []string{}
+			for j, nTerms := 0, r.uvarint(); j < nTerms && !r.malformed; j++ {
+				keyTerms = append(keyTerms, r.string())
+			}
+			terms[key] = keyTerms
+		}
+		if r.malformed {
+			return fmt.Errorf("%w: malformed TEXT.delta record at byte %d", ErrCorruptIndex, recordPos)
+		}
+
+		switch op {
+		case deltaAddRecord:
+			t.addTerms(ref, terms)
+		case deltaRemoveRecord:
+			t.removeTerms(ref, terms)
+		default:
+			return fmt.Errorf("%w: unknown TEXT.delta op %c", ErrCorruptIndex, op)
+		}
+	}
+	return nil
+}
+
+// updateTextIndex replaces terms of oldDoc with terms of newDoc under ref in full-text index of dirPath,
+// if it has one, appending the change to TEXT.delta journal. Either of the documents may be nil.
+// Full-text index kept in memory by index is changed too, otherwise only keys of TEXT file are read.
+func updateTextIndex(dirPath string, index DirIndex, ref Ref, oldDoc, newDoc []byte) error {
+	t := index.textIndex()
+	if t == nil {
+		keys, err := readTextKeys(dirPath)
+		if err != nil || len(keys) == 0 {
+			return err
+		}
+		t = NewTextIndex(keys)
+	} else if len(t.fields) == 0 {
+		return nil
+	}
+
+	records := make([]byte, 0, 256)
+	for _, change := range []struct {
+		op  byte
+		doc []byte
+	}{{deltaRemoveRecord, oldDoc}, {deltaAddRecord, newDoc}} {
+		if change.doc == nil {
+			continue
+		}
+		flatten, err := flattenDocument(change.doc, BinaryCollation)
+		if err != nil {
+			return err
+		}
+		terms := t.documentTerms(flatten)
+		if change.op == deltaRemoveRecord {
+			t.removeTerms(ref, terms)
+		} else {
+			t.addTerms(ref, terms)
+		}
+		records = append(records, serializeTextDelta(change.op, ref, terms)...)
+	}
+
+	deltaFilePath := filepath.Join(dirPath, textDeltaFileName)
+	f, err := os.OpenFile(deltaFilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(records)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return compactTextIndex(dirPath, index)
+}
+
+// compactTextIndex folds TEXT.delta journal into a new TEXT file once it outgrows the file itself.
+func compactTextIndex(dirPath string, index DirIndex) error {
+	deltaInfo, err := os.Stat(filepath.Join(dirPath, textDeltaFileName))
+	if err != nil {
+		return err
+	}
+	textInfo, err := os.Stat(filepath.Join(dirPath, textFileName))
+	if err == nil && deltaInfo.Size() <= textInfo.Size() {
+		return nil
+	}
+	t := index.textIndex()
+	if t == nil {
+		if t, err = ReadTextIndex(dirPath); err != nil || t == nil {
+			return err
+		}
+	}
+	return SaveTextIndex(t, dirPath)
+}
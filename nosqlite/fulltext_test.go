@@ -0,0 +1,240 @@
+package nosqlite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+// Check if text is split into lower cased and stemmed words without stopwords.
+func TestAnalyzeText(t *testing.T) {
+	terms := analyzeText("The Fastest databases are indexing quickly, Über-fast!")
+	expected := []string{"fastest", "database", "index", "quick", "über", "fast"}
+	if !compareSlices(terms, expected) {
+		t.Fatalf("Expected terms different than actual:\n%v\n%v", expected, terms)
+	}
+
+	assert := func(word, expected string) {
+		if actual := stemWord(word); actual != expected {
+			t.Fatalf("Expected %q stemmed to %q, got %q", word, expected, actual)
+		}
+	}
+	assert("classes", "class")
+	assert("stories", "story")
+	assert("glass", "glass")
+	assert("bus", "bus")
+	assert("indexed", "index")
+	assert("red", "red")
+}
+
+func writeTextTestDocs(t *testing.T) string {
+	t.Helper()
+	dirPath := t.TempDir()
+	for i, doc := range []string{
+		`{"name": "a", "year": 2021, "description": "A fast database for documents", "tags": ["go", "db"]}`,
+		`{"name": "b", "year": 2019, "description": "Fast, faster, fastest: a database of databases", "tags": ["rust"]}`,
+		`{"name": "c", "year": 2022, "description": "Slow file storage", "tags": ["go"]}`,
+		`{"name": "d", "year": 2023, "description": "The database is fast and fast again, fast database"}`,
+	} {
+		check(os.WriteFile(fmt.Sprintf("%s/%d", dirPath, i), []byte(doc), 0644))
+	}
+	return dirPath
+}
+
+// Check if MATCH conditions combine with other conditions and Search orders documents by BM25.
+func TestQueryTextIndex(t *testing.T) {
+	dirPath := writeTextTestDocs(t)
+	db, err := OpenWith(dirPath, DirOptions{TextKeys: []string{"/description", "/tags/*"}})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	defer db.Close()
+	assert := func(query string, expected ...Ref) {
+		t.Helper()
+		refs, err := db.Exec(query)
+		if err != nil {
+			t.Fatalf("Got unexpected error for %s: %v", query, err)
+		}
+		if !compareSlices(refs, expected) {
+			t.Fatalf("Expected refs for %s different than actual:\n%v\n%v", query, expected, refs)
+		}
+	}
+
+	assert("SELECT * FROM c WHERE MATCH(c.description, 'fast database')", 0, 1, 3)
+	assert("SELECT * FROM c WHERE MATCH(c.description, 'FAST Databases') AND c.year > 2020", 0, 3)
+	assert("SELECT * FROM c WHERE MATCH(c.description, 'fast database') AND c.year > 2020 OR MATCH(c.tags[*], 'go')", 0, 2, 3)
+	assert("SELECT * FROM c WHERE NOT MATCH(c.description, 'slow')", 0, 1, 3)
+	assert("SELECT * FROM c WHERE MATCH(c.description, 'the')")
+	assert("SELECT * FROM c WHERE MATCH(c.description, 'fast slow')")
+
+	if _, err := db.Exec("SELECT * FROM c WHERE MATCH(c.name, 'a')"); !errors.Is(err, ErrNoTextIndex) {
+		t.Fatalf("Expected ErrNoTextIndex, got %v", err)
+	}
+	entries, err := db.Entries()
+	check(err)
+	if _, err := QueryIndex(entries, "SELECT * FROM c WHERE MATCH(c.description, 'fast')"); !errors.Is(err, ErrNoTextIndex) {
+		t.Fatalf("Expected ErrNoTextIndex, got %v", err)
+	}
+
+	documents, err := db.Search("SELECT c.name FROM c WHERE MATCH(c.description, 'fast database')")
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	names := make([]string, 0, len(documents))
+	for i, document := range documents {
+		names = append(names, document.Value.(map[string]interface{})["name"].(string))
+		if document.Score <= 0 || (i > 0 && document.Score > documents[i-1].Score) {
+			t.Fatalf("Expected positive scores in descending order, got %v", documents)
+		}
+	}
+	if expected := []string{"d", "a", "b"}; !compareSlices(names, expected) {
+		t.Fatalf("Expected documents different than actual:\n%v\n%v", expected, names)
+	}
+}
+
+// Check if TEXT file is kept up to date by INSERT, UPDATE and DELETE and its keys persist across Open.
+func TestTextIndexMaintenance(t *testing.T) {
+	dirPath := writeTextTestDocs(t)
+	db, err := OpenWith(dirPath, DirOptions{TextKeys: []string{"/description"}})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	assert := func(query string, expected ...Ref) {
+		t.Helper()
+		refs, err := db.Exec(query)
+		if err != nil {
+			t.Fatalf("Got unexpected error for %s: %v", query, err)
+		}
+		if !compareSlices(refs, expected) {
+			t.Fatalf("Expected refs for %s different than actual:\n%v\n%v", query, expected, refs)
+		}
+	}
+
+	textBytes := readTestFile(t, dirPath+"/"+textFileName)
+	assert(`INSERT INTO c VALUES '{"name": "e", "description": "Fast database, slow network"}'`, 4)
+	assert("UPDATE c SET c.description = 'Slow documents' WHERE c.name = 'a'", 0)
+
+	// changes are journaled next to TEXT file, which is read with them
+	if !bytes.Equal(readTestFile(t, dirPath+"/"+textFileName), textBytes) {
+		t.Fatalf("Expected TEXT file to stay as it was until the journal outgrows it")
+	}
+	read, err := ReadTextIndex(dirPath)
+	check(err)
+	if !bytes.Equal(serializeTextIndex(read), serializeTextIndex(db.index.text)) {
+		t.Fatalf("Expected TEXT file with its journal to be identical to the index in memory")
+	}
+
+	assert("DELETE FROM c WHERE c.name = 'd'", 3)
+	assert("SELECT * FROM c WHERE MATCH(c.description, 'fast database')", 1, 4)
+	assert("SELECT * FROM c WHERE MATCH(c.description, 'slow')", 0, 2, 4)
+	check(db.Close())
+	if _, err := os.Stat(dirPath + "/" + textDeltaFileName); !os.IsNotExist(err) {
+		t.Fatalf("Expected Close to fold TEXT journal into TEXT file")
+	}
+
+	rebuilt, err := IndexText(dirPath, []string{"/description"})
+	check(err)
+	if !bytes.Equal(readTestFile(t, dirPath+"/"+textFileName), serializeTextIndex(rebuilt)) {
+		t.Fatalf("Expected updated TEXT file to be identical to rebuilt one")
+	}
+
+	// reopened without options keeps keys of its TEXT file, even when it is indexed again
+	db, err = Open(dirPath)
+	check(err)
+	check(db.Index())
+	assert("SELECT * FROM c WHERE MATCH(c.description, 'document')", 0)
+	check(db.Close())
+
+	// different keys rebuild TEXT file right away
+	db, err = OpenWith(dirPath, DirOptions{TextKeys: []string{"/name"}})
+	check(err)
+	assert("SELECT * FROM c WHERE MATCH(c.name, 'e')", 4)
+	if _, err := db.Exec("SELECT * FROM c WHERE MATCH(c.description, 'slow')"); !errors.Is(err, ErrNoTextIndex) {
+		t.Fatalf("Expected ErrNoTextIndex, got %v", err)
+	}
+	check(db.Close())
+}
+
+// Check if TEXT file is read back as written and damaged file is reported as corrupt.
+func TestTextIndexFile(t *testing.T) {
+	dirPath := writeTextTestDocs(t)
+	text, err := IndexText(dirPath, []string{"/description", "/tags/*"})
+	check(err)
+	check(SaveTextIndex(text, dirPath))
+
+	read, err := ReadTextIndex(dirPath)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if !bytes.Equal(serializeTextIndex(read), serializeTextIndex(text)) {
+		t.Fatalf("Expected read TEXT index to be identical to saved one")
+	}
+
+	textBytes := readTestFile(t, dirPath+"/"+textFileName)
+	textBytes[len(textMagic)+3] ^= 0xff
+	if _, err := deserializeTextIndex(textBytes); !errors.Is(err, ErrCorruptIndex) {
+		t.Fatalf("Expected ErrCorruptIndex, got %v", err)
+	}
+
+	if text, err := ReadTextIndex(t.TempDir()); text != nil || err != nil {
+		t.Fatalf("Expected no TEXT index, got %v %v", text, err)
+	}
+
+	// format version 1 has every key right before its documents
+	v1 := append([]byte(textMagic), 1, 1, 2, '/', 'd', 1, 0, 1, 1, 4, 'f', 'a', 's', 't', 1, 0, 1)
+	v1 = binary.BigEndian.AppendUint32(v1, crc32cChecksum(v1))
+	expected := NewTextIndex([]string{"/d"})
+	expected.addTerms(0, map[string][]string{"/d": {"fast"}})
+	if read, err := deserializeTextIndex(v1); err != nil || !bytes.Equal(serializeTextIndex(read), serializeTextIndex(expected)) {
+		t.Fatalf("Expected format version 1 TEXT file to be read, got %v", err)
+	}
+}
+
+// Check if changes of documents outside DB are journaled reading only keys of TEXT file.
+func TestTextIndexJournal(t *testing.T) {
+	dirPath := writeTextTestDocs(t)
+	text, err := IndexText(dirPath, []string{"/description"})
+	check(err)
+	check(SaveTextIndex(text, dirPath))
+	index := IndexT{}
+	check(SaveIndex(index, dirPath))
+
+	if keys, err := readTextKeys(dirPath); err != nil || !compareSlices(keys, []string{"/description"}) {
+		t.Fatalf("Expected TEXT keys, got %v %v", keys, err)
+	}
+
+	oldDoc := readTestFile(t, dirPath+"/0")
+	newDoc := []byte(`{"description": "Slow network"}`)
+	check(ReplaceInIndex(dirPath, &index, 0, oldDoc, newDoc))
+	check(AddToIndex(dirPath, &index, 4, []byte(`{"description": "Fast network"}`)))
+	check(os.WriteFile(dirPath+"/0", newDoc, 0644))
+	check(os.WriteFile(dirPath+"/4", []byte(`{"description": "Fast network"}`), 0644))
+
+	rebuilt, err := IndexText(dirPath, []string{"/description"})
+	check(err)
+	read, err := ReadTextIndex(dirPath)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if !bytes.Equal(serializeTextIndex(read), serializeTextIndex(rebuilt)) {
+		t.Fatalf("Expected TEXT file with its journal to be identical to rebuilt one")
+	}
+	if actual, expected := read.fields["/description"].totalLength, rebuilt.fields["/description"].totalLength; actual != expected {
+		t.Fatalf("Expected total length %d, got %d", expected, actual)
+	}
+
+	// replaying a record again changes nothing
+	deltaBytes := readTestFile(t, dirPath+"/"+textDeltaFileName)
+	check(replayTextDelta(read, deltaBytes))
+	if !bytes.Equal(serializeTextIndex(read), serializeTextIndex(rebuilt)) {
+		t.Fatalf("Expected TEXT journal replayed twice to give the same index")
+	}
+
+	check(os.WriteFile(dirPath+"/"+textDeltaFileName, deltaBytes[:len(deltaBytes)-1], 0644))
+	if _, err := ReadTextIndex(dirPath); !errors.Is(err, ErrCorruptIndex) {
+		t.Fatalf("Expected ErrCorruptIndex for truncated journal, got %v", err)
+	}
+}
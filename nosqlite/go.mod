@@ -1,3 +1,5 @@
 module github.com/jacnik/nosqlite
 
 go 1.21.0
+
+require golang.org/x/text v0.14.0
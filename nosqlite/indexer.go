@@ -0,0 +1,186 @@
+package nosqlite
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+/* Parallel indexing pipeline.
+** Documents are handed out in order of filePaths to a pool of workers, each of them reads,
+** parses and flattens its documents and aggregates them into its own shard.
+** Shards are merged once every worker is done and refs of every value are sorted,
+** so the index does not depend on the number of workers nor on their scheduling. */
+
+// IndexOptions configures IndexFilesWith.
+type IndexOptions struct {
+	// Workers is the number of documents read and aggregated concurrently, GOMAXPROCS when not positive.
+	Workers int
+	// Progress, when set, is called after every indexed document with the number of indexed documents so far.
+	// Until every collection file is read, total counts files not read yet as single documents.
+	// Calls are serialized but come from worker goroutines.
+	Progress func(indexed, total int)
+	// Collation of indexed string values, BinaryCollation by default.
+	Collation Collation
+	// Docs lists locations of documents indexed before by their refs, like the ones IndexDocuments returns.
+	// Documents found at these locations keep their refs and new ones get refs after all of them.
+	Docs DocsT
+}
+
+// IndexFilesWith indexes filePaths like IndexFiles, spreading the work over opts.Workers goroutines.
+// When some files can not be indexed, the error of the first of them in filePaths order is returned.
+func IndexFilesWith(filePaths []string, opts IndexOptions) (IndexT, error) {
+	index, _, err := IndexDocuments(filePaths, opts)
+	return index, err
+}
+
+// IndexDocuments indexes filePaths like IndexFilesWith and returns locations of the documents by their refs,
+// file paths for json files and file paths followed by line offsets for lines of collection files.
+func IndexDocuments(filePaths []string, opts IndexOptions) (IndexT, DocsT, error) {
+	nWorkers := opts.Workers
+	if nWorkers <= 0 {
+		nWorkers = runtime.GOMAXPROCS(0)
+	}
+	// a single collection file may hold every document, so workers are not limited by the number of files
+	nWorkers = max(nWorkers, 1)
+
+	// errors by position of documents, only the first one in filePaths order is returned
+	errs := make(map[int]error)
+	var failed atomic.Bool
+	var mu sync.Mutex
+	indexed, total, reportedTotal := 0, len(filePaths), len(filePaths)
+
+	indexDocument := func(shard aggregateT, seq int, ref Ref, src sourceDocument) {
+		err := func() error {
+			bytes, err := readSourceDocument(src)
+			if err != nil {
+				return err
+			}
+			unflatten, err := parseJson(bytes)
+			if err != nil {
+				return fmt.Errorf("%s: %w", src.location, err)
+			}
+			aggregateJson(shard, collateFlatten(flattenJson(unflatten), opts.Collation), ref)
+			return nil
+		}()
+
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			errs[seq] = err
+			failed.Store(true)
+			return
+		}
+		indexed++
+		if opts.Progress != nil {
+			reportedTotal = total
+			opts.Progress(indexed, total)
+		}
+	}
+
+	type job struct {
+		seq int
+		ref Ref
+		src sourceDocument
+	}
+	jobs := make(chan job, nWorkers)
+	shards := make([]aggregateT, nWorkers)
+	var wg sync.WaitGroup
+	for w := range shards {
+		shards[w] = make(aggregateT)
+		wg.Add(1)
+		go func(shard aggregateT) {
+			defer wg.Done()
+			for j := range jobs {
+				indexDocument(shard, j.seq, j.ref, j.src)
+			}
+		}(shards[w])
+	}
+
+	// documents are handed out in order, so every document before a failed one is still indexed
+	refs := newRefAssigner(opts.Docs)
+	seq := 0
+	errStop := errors.New("stop")
+	err := eachSourceDocument(filePaths, func(src sourceDocument) error {
+		if failed.Load() {
+			return errStop
+		}
+		mu.Lock()
+		total = seq + len(filePaths) - src.fileIdx
+		mu.Unlock()
+		jobs <- job{seq, refs.assign(src.location), src}
+		seq++
+		return nil
+	})
+	close(jobs)
+	wg.Wait()
+
+	if err != nil && !errors.Is(err, errStop) {
+		errs[seq] = err
+	}
+	if len(errs) > 0 {
+		firstSeq := seq + 1
+		for errSeq := range errs {
+			firstSeq = min(firstSeq, errSeq)
+		}
+		return nil, nil, errs[firstSeq]
+	}
+	if opts.Progress != nil && reportedTotal != seq {
+		opts.Progress(indexed, seq)
+	}
+	return indexAgregate(mergeAggregates(shards)), refs.docs, nil
+}
+
+// refAssigner hands out refs to document locations in the order they are found.
+type refAssigner struct {
+	previous map[string]Ref
+	docs     DocsT
+}
+
+// newRefAssigner keeps refs of locations listed in previous docs, new locations get refs after all of them
+// and locations not found again are left deleted.
+func newRefAssigner(previous DocsT) *refAssigner {
+	a := &refAssigner{make(map[string]Ref, len(previous)), make(DocsT, len(previous), len(previous)+16)}
+	for ref, location := range previous {
+		if location != "" {
+			a.previous[location] = Ref(ref)
+		}
+	}
+	return a
+}
+
+func (a *refAssigner) assign(location string) Ref {
+	if ref, found := a.previous[location]; found {
+		a.docs[ref] = location
+		return ref
+	}
+	a.docs = append(a.docs, location)
+	return Ref(len(a.docs) - 1)
+}
+
+// mergeAggregates merges shards into the first of them, keeping refs of every value sorted.
+func mergeAggregates(shards []aggregateT) aggregateT {
+	agg := shards[0]
+	for _, shard := range shards[1:] {
+		for aggregateKey, shardValues := range shard {
+			fileRefsMap, hasFileRefsMap := agg[aggregateKey]
+			if !hasFileRefsMap {
+				agg[aggregateKey] = shardValues
+				continue
+			}
+			for aggregateValue, fileRefs := range shardValues {
+				fileRefsMap[aggregateValue] = append(fileRefsMap[aggregateValue], fileRefs...)
+			}
+		}
+	}
+	// refs kept from opts.Docs are not handed out in order even by a single worker
+	for _, fileRefsMap := range agg {
+		for _, fileRefs := range fileRefsMap {
+			slices.Sort(fileRefs)
+		}
+	}
+	return agg
+}
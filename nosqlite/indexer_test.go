@@ -0,0 +1,108 @@
+package nosqlite
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTestFiles(t *testing.T, n int) []string {
+	t.Helper()
+	dirPath := t.TempDir()
+	paths := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		path := filepath.Join(dirPath, fmt.Sprintf("%03d", i))
+		doc := fmt.Sprintf(`{"n": %d, "s": "v%d", "even": %t, "arr": [%d, null], "nested": {"tag": "t%d"}}`, i%7, i%11, i%2 == 0, i%3, i%5)
+		check(os.WriteFile(path, []byte(doc), 0644))
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// Check if index does not depend on the number of workers and matches documents merged one by one.
+func TestIndexFilesWithWorkers(t *testing.T) {
+	paths := writeTestFiles(t, 300)
+
+	expected := IndexT{}
+	for ref, path := range paths {
+		delta, err := documentIndex(readTestFile(t, path), Ref(ref), BinaryCollation)
+		check(err)
+		mergeIndex(&expected, delta)
+	}
+
+	for _, workers := range []int{0, 1, 2, 7, 64, 1000} {
+		index, err := IndexFilesWith(paths, IndexOptions{Workers: workers})
+		if err != nil {
+			t.Fatalf("Got unexpected error: %v", err)
+		}
+		if !compareIndexes(expected, index) {
+			t.Fatalf("Expected index with %d workers different than actual:\n%v\n%v", workers, expected, index)
+		}
+	}
+
+	if index, err := IndexFilesWith(nil, IndexOptions{Workers: 4}); err != nil || len(index) != 0 {
+		t.Fatalf("Expected empty index, got %v %v", index, err)
+	}
+}
+
+// Check if progress is reported once for every file.
+func TestIndexFilesProgress(t *testing.T) {
+	paths := writeTestFiles(t, 50)
+
+	calls := 0
+	_, err := IndexFilesWith(paths, IndexOptions{Workers: 4, Progress: func(indexed, total int) {
+		calls++
+		if indexed != calls || total != len(paths) {
+			t.Errorf("Expected progress %d of %d, got %d of %d", calls, len(paths), indexed, total)
+		}
+	}})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if calls != len(paths) {
+		t.Fatalf("Expected %d progress calls, got %d", len(paths), calls)
+	}
+}
+
+// Check if error of the first broken file in order is returned whatever the number of workers.
+func TestIndexFilesWithError(t *testing.T) {
+	paths := writeTestFiles(t, 200)
+	check(os.WriteFile(paths[30], []byte(`{"name": `), 0644))
+	check(os.Remove(paths[120]))
+
+	for _, workers := range []int{1, 3, 16} {
+		_, err := IndexFilesWith(paths, IndexOptions{Workers: workers})
+		if err == nil || !strings.Contains(err.Error(), paths[30]) {
+			t.Fatalf("Expected error of %s with %d workers, got %v", paths[30], workers, err)
+		}
+	}
+}
+
+// Check if a single worker keeping refs of DOCS file out of order writes an index which is read back.
+func TestIndexDocumentsKeepingRefs(t *testing.T) {
+	dirPath := t.TempDir()
+	check(os.WriteFile(filepath.Join(dirPath, "a.json"), []byte(`{"v": 1}`), 0644))
+	check(os.WriteFile(filepath.Join(dirPath, "b.json"), []byte(`{"v": 1}`), 0644))
+	check(SaveDocs(DocsT{"b.json", "a.json"}, dirPath))
+
+	names, previous, err := dirDocuments(dirPath, DirOptions{})
+	check(err)
+	paths := make([]string, 0, len(names))
+	for _, name := range names {
+		paths = append(paths, filepath.Join(dirPath, name))
+	}
+	index, _, err := IndexDocuments(paths, IndexOptions{Workers: 1, Docs: previous})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	check(SaveIndex(index, dirPath))
+	read, err := ReadIndex(dirPath)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if refs := read.EntryRefs(0, 0); !compareSlices(refs, []Ref{0, 1}) {
+		t.Fatalf("Expected sorted refs different than actual:\n%v\n%v", []Ref{0, 1}, refs)
+	}
+}
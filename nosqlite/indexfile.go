@@ -0,0 +1,210 @@
+package nosqlite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"os"
+)
+
+/* INDEX file starts with a header describing the sections that follow.
+** Header, entries and directory are guarded by their own CRC32C checksums,
+** so a truncated, damaged or foreign file is rejected instead of being misparsed.
+** Directory holds offsets of every entry and value, so MappedIndex can
+** binary search keys and values without decoding the entries.
+** Files written before the header was introduced start directly with a key,
+** which always begins with '/', and are read as they are until MigrateIndex rewrites them. */
+
+// INDEX file binary layout, format version 5
+// {magic = "NSQI"}{format version}{n of entries}{n of documents}{n entries bytes}{n directory bytes}{flags = indexEncoding | Collation<<8}{header crc32c}
+// {entries in INDEX layout}{entries crc32c}
+// {entry offsets}{first value of entries}{value offsets}{directory crc32c}
+//
+// format version 4 stores every number as 'f' entry value, integers included,
+// format version 3 only writes varintRefs flag,
+// format version 2 has no {flags} and stores entries in plainEncoding,
+// format version 1 has neither {n directory bytes} nor the directory section
+
+const (
+	indexMagic         = "NSQI"
+	indexFormatVersion = 5
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+func crc32cChecksum(data []byte) uint32 {
+	return crc32.Checksum(data, crc32cTable)
+}
+
+type indexHeader struct {
+	version    size_t
+	nEntries   size_t
+	nDocuments size_t
+	nBytes     size_t
+	nDirBytes  size_t
+	flags      size_t
+}
+
+func (h indexHeader) encoding() indexEncoding {
+	return indexEncoding(h.flags & (1<<collationShift - 1))
+}
+
+func (h indexHeader) collation() Collation {
+	return Collation(h.flags >> collationShift)
+}
+
+func indexHeaderSize(version size_t) int {
+	switch version {
+	case 1:
+		return len(indexMagic) + 4*5
+	case 2:
+		return len(indexMagic) + 4*6
+	}
+	return len(indexMagic) + 4*7
+}
+
+// encodeIndexHeader returns header of the current format version, followed by its checksum.
+func encodeIndexHeader(header indexHeader) []byte {
+	buff := bytes.NewBuffer(make([]byte, 0, indexHeaderSize(indexFormatVersion)))
+	buff.WriteString(indexMagic)                   // {magic}
+	binary.Write(buff, binary.BigEndian, []size_t{ // {format version}{n of entries}{n of documents}{n entries bytes}{n directory bytes}{flags}
+		header.version, header.nEntries, header.nDocuments, header.nBytes, header.nDirBytes, header.flags})
+	headerCrc := crc32cChecksum(buff.Bytes())
+	binary.Write(buff, binary.BigEndian, headerCrc) // {header crc32c}
+	return buff.Bytes()
+}
+
+func encodeIndexFile(index IndexT, collation Collation) []byte {
+	entriesBytes, dir := serializeIndexWithDir(index, currentEncoding)
+	dirBuff := bytes.NewBuffer(make([]byte, 0, 4*(len(dir.entryOffsets)+len(dir.firstValues)+len(dir.valueOffsets))))
+	binary.Write(dirBuff, binary.BigEndian, dir.entryOffsets) // {entry offsets}
+	binary.Write(dirBuff, binary.BigEndian, dir.firstValues)  // {first value of entries}
+	binary.Write(dirBuff, binary.BigEndian, dir.valueOffsets) // {value offsets}
+	dirBytes := dirBuff.Bytes()
+
+	header := indexHeader{indexFormatVersion, size_t(len(index)), size_t(indexRefs(index).Popcount()),
+		size_t(len(entriesBytes)), size_t(len(dirBytes)), size_t(currentEncoding) | size_t(collation)<<collationShift}
+
+	buff := bytes.NewBuffer(make([]byte, 0, indexHeaderSize(indexFormatVersion)+len(entriesBytes)+len(dirBytes)+8))
+	buff.Write(encodeIndexHeader(header)) // {header}
+	buff.Write(entriesBytes)              // {entries}
+	entriesCrc := crc32cChecksum(entriesBytes)
+	binary.Write(buff, binary.BigEndian, entriesCrc) // {entries crc32c}
+	buff.Write(dirBytes)                             // {directory}
+	dirCrc := crc32cChecksum(dirBytes)
+	binary.Write(buff, binary.BigEndian, dirCrc) // {directory crc32c}
+	return buff.Bytes()
+}
+
+// isLegacyIndexFile reports INDEX files written without header.
+func isLegacyIndexFile(fileBytes []byte) bool {
+	return len(fileBytes) == 0 || fileBytes[0] == '/'
+}
+
+// decodeIndexHeader checks header at the start of fileBytes, which may hold only the header.
+func decodeIndexHeader(fileBytes []byte) (header indexHeader, err error) {
+	if len(fileBytes) < len(indexMagic)+4 || string(fileBytes[:len(indexMagic)]) != indexMagic {
+		return header, fmt.Errorf("%w: not an INDEX file", ErrCorruptIndex)
+	}
+	version := size_t(binary.BigEndian.Uint32(fileBytes[len(indexMagic):]))
+	headerSize := indexHeaderSize(version)
+	if len(fileBytes) < headerSize {
+		return header, fmt.Errorf("%w: truncated header", ErrCorruptIndex)
+	}
+
+	headerBytes := fileBytes[:headerSize-4]
+	if crc32cChecksum(headerBytes) != binary.BigEndian.Uint32(fileBytes[headerSize-4:headerSize]) {
+		return header, fmt.Errorf("%w: header checksum mismatch", ErrCorruptIndex)
+	}
+	field := func(i int) size_t {
+		pos := len(indexMagic) + 4*i
+		if pos+4 > len(headerBytes) {
+			return 0
+		}
+		return size_t(binary.BigEndian.Uint32(headerBytes[pos : pos+4]))
+	}
+	header = indexHeader{field(0), field(1), field(2), field(3), field(4), field(5)}
+	if header.version < 1 || header.version > indexFormatVersion {
+		return header, fmt.Errorf("%w: unsupported format version %d", ErrCorruptIndex, header.version)
+	}
+	if header.encoding()&^knownEncodings != 0 || header.collation()&^knownCollations != 0 {
+		return header, fmt.Errorf("%w: unsupported flags %#x", ErrCorruptIndex, header.flags)
+	}
+	return header, nil
+}
+
+// indexFileSections checks header and section sizes of INDEX file with header
+// and returns the header, entries and directory sections.
+func indexFileSections(fileBytes []byte) (header indexHeader, entriesBytes, dirBytes []byte, err error) {
+	header, err = decodeIndexHeader(fileBytes)
+	if err != nil {
+		return header, nil, nil, err
+	}
+	headerSize := indexHeaderSize(header.version)
+
+	sectionsSize := int(header.nBytes) + 4
+	if header.version > 1 {
+		sectionsSize += int(header.nDirBytes) + 4
+	}
+	if headerSize+sectionsSize != len(fileBytes) {
+		return header, nil, nil, fmt.Errorf("%w: expected %d bytes after header, got %d", ErrCorruptIndex, sectionsSize, len(fileBytes)-headerSize)
+	}
+
+	entriesEnd := headerSize + int(header.nBytes)
+	entriesBytes = fileBytes[headerSize:entriesEnd]
+	if header.version > 1 {
+		dirBytes = fileBytes[entriesEnd+4 : len(fileBytes)-4]
+	}
+	return header, entriesBytes, dirBytes, nil
+}
+
+func decodeIndexFile(fileBytes []byte) (IndexT, error) {
+	if isLegacyIndexFile(fileBytes) {
+		return deserializeIndex(fileBytes, plainEncoding)
+	}
+	header, entriesBytes, dirBytes, err := indexFileSections(fileBytes)
+	if err != nil {
+		return nil, err
+	}
+	entriesEnd := indexHeaderSize(header.version) + len(entriesBytes)
+	if crc32cChecksum(entriesBytes) != binary.BigEndian.Uint32(fileBytes[entriesEnd:entriesEnd+4]) {
+		return nil, fmt.Errorf("%w: entries checksum mismatch", ErrCorruptIndex)
+	}
+	if header.version > 1 && crc32cChecksum(dirBytes) != binary.BigEndian.Uint32(fileBytes[len(fileBytes)-4:]) {
+		return nil, fmt.Errorf("%w: directory checksum mismatch", ErrCorruptIndex)
+	}
+
+	index, err := deserializeIndex(entriesBytes, header.encoding())
+	if err != nil {
+		return nil, err
+	}
+	if size_t(len(index)) != header.nEntries {
+		return nil, fmt.Errorf("%w: expected %d entries, got %d", ErrCorruptIndex, header.nEntries, len(index))
+	}
+	return index, nil
+}
+
+// MigrateIndex rewrites INDEX file of dirPath written without header or in an older format version
+// like SaveIndex does, folding INDEX.delta journal into it. It returns whether the file was migrated.
+func MigrateIndex(dirPath string) (bool, error) {
+	fileBytes, err := os.ReadFile(dirPath + "/INDEX")
+	if err != nil {
+		return false, err
+	}
+	// collations were added in format version 4, so older files are binary
+	collation := BinaryCollation
+	if !isLegacyIndexFile(fileBytes) {
+		header, _, _, err := indexFileSections(fileBytes)
+		if err != nil || header.version == indexFormatVersion {
+			return false, err
+		}
+		collation = header.collation()
+	}
+	index, err := ReadIndex(dirPath)
+	if err != nil {
+		return false, err
+	}
+	// integers of older files stay float values until the directory is indexed again
+	return true, SaveIndexWith(index, dirPath, collation)
+}
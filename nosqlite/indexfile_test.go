@@ -0,0 +1,155 @@
+package nosqlite
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Check if INDEX file with header can be encoded and decoded back.
+func TestEncodeAndDecodeIndexFile(t *testing.T) {
+	index := indexTestFiles(t, []string{"./db/0", "./db/1"})
+	fileBytes := encodeIndexFile(index, BinaryCollation)
+
+	if string(fileBytes[:4]) != indexMagic {
+		t.Fatalf("Expected INDEX file to start with magic, got %q", fileBytes[:4])
+	}
+	if nDocuments := binary.BigEndian.Uint32(fileBytes[12:16]); nDocuments != 2 {
+		t.Fatalf("Expected 2 documents in header, got %d", nDocuments)
+	}
+
+	decoded, err := decodeIndexFile(fileBytes)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if !compareIndexes(index, decoded) {
+		t.Fatalf("Decoded index different than original:\n%v\n%v", index, decoded)
+	}
+}
+
+// Check if damaged, truncated or foreign INDEX files are rejected.
+func TestDecodeCorruptIndexFile(t *testing.T) {
+	fileBytes := encodeIndexFile(indexTestFiles(t, []string{"./db/0", "./db/1"}), BinaryCollation)
+	assert := func(what string, damaged []byte) {
+		if _, err := decodeIndexFile(damaged); !errors.Is(err, ErrCorruptIndex) {
+			t.Fatalf("Expected ErrCorruptIndex for %s, got %v", what, err)
+		}
+	}
+	headerSize := indexHeaderSize(indexFormatVersion)
+	damage := func(pos int) []byte {
+		damaged := append([]byte{}, fileBytes...)
+		damaged[pos] ^= 0xff
+		return damaged
+	}
+
+	assert("damaged header", damage(9))
+	assert("damaged header checksum", damage(headerSize-1))
+	assert("damaged entries", damage(headerSize+3))
+	entriesEnd := headerSize + int(binary.BigEndian.Uint32(fileBytes[16:20]))
+	assert("damaged entries checksum", damage(entriesEnd+1))
+	assert("damaged directory checksum", damage(len(fileBytes)-1))
+	assert("damaged directory", damage(len(fileBytes)-6))
+	assert("truncated file", fileBytes[:len(fileBytes)-5])
+	assert("truncated header", fileBytes[:10])
+	assert("foreign file", []byte("{\"name\": \"Elliot\"}"))
+
+	version := append([]byte{}, fileBytes[:headerSize-4]...)
+	binary.BigEndian.PutUint32(version[4:8], indexFormatVersion+1)
+	version = binary.BigEndian.AppendUint32(version, crc32cChecksum(version))
+	assert("unsupported version", append(version, fileBytes[headerSize:]...))
+
+	flags := append([]byte{}, fileBytes[:headerSize-4]...)
+	binary.BigEndian.PutUint32(flags[24:28], uint32(knownEncodings)+1)
+	flags = binary.BigEndian.AppendUint32(flags, crc32cChecksum(flags))
+	assert("unsupported flags", append(flags, fileBytes[headerSize:]...))
+
+	collation := append([]byte{}, fileBytes[:headerSize-4]...)
+	binary.BigEndian.PutUint32(collation[24:28], uint32(currentEncoding)|uint32(knownCollations+1)<<collationShift)
+	collation = binary.BigEndian.AppendUint32(collation, crc32cChecksum(collation))
+	assert("unsupported collation", append(collation, fileBytes[headerSize:]...))
+}
+
+// Check if INDEX file without header is read and migrated to the current format.
+func TestMigrateIndex(t *testing.T) {
+	index := indexTestFiles(t, []string{"./db/0", "./db/1"})
+	dirPath := copyDb(t)
+	check(os.WriteFile(dirPath+"/INDEX", serializeIndex(index, plainEncoding), 0644))
+
+	if legacy := readTestIndex(t, dirPath); !compareIndexes(index, legacy) {
+		t.Fatalf("Expected index different than actual:\n%v\n%v", index, legacy)
+	}
+
+	migrated, err := MigrateIndex(dirPath)
+	if err != nil || !migrated {
+		t.Fatalf("Expected INDEX file to be migrated, got %v %v", migrated, err)
+	}
+	if fileBytes := readTestFile(t, dirPath+"/INDEX"); string(fileBytes[:4]) != indexMagic {
+		t.Fatalf("Expected migrated INDEX file to start with magic, got %q", fileBytes[:4])
+	}
+	if current := readTestIndex(t, dirPath); !compareIndexes(index, current) {
+		t.Fatalf("Expected index different than actual:\n%v\n%v", index, current)
+	}
+
+	if migrated, err := MigrateIndex(dirPath); err != nil || migrated {
+		t.Fatalf("Expected current INDEX file not to be migrated, got %v %v", migrated, err)
+	}
+
+	// format version 1 has no directory
+	entriesBytes := serializeIndex(index, plainEncoding)
+	v1 := []byte(indexMagic)
+	for _, field := range []int{1, len(index), 2, len(entriesBytes)} {
+		v1 = binary.BigEndian.AppendUint32(v1, uint32(field))
+	}
+	v1 = binary.BigEndian.AppendUint32(v1, crc32cChecksum(v1))
+	v1 = append(v1, entriesBytes...)
+	v1 = binary.BigEndian.AppendUint32(v1, crc32cChecksum(entriesBytes))
+	check(os.WriteFile(dirPath+"/INDEX", v1, 0644))
+
+	if old := readTestIndex(t, dirPath); !compareIndexes(index, old) {
+		t.Fatalf("Expected index different than actual:\n%v\n%v", index, old)
+	}
+	if migrated, err := MigrateIndex(dirPath); err != nil || !migrated {
+		t.Fatalf("Expected version 1 INDEX file to be migrated, got %v %v", migrated, err)
+	}
+	mapped, err := MapIndex(dirPath)
+	if err != nil {
+		t.Fatalf("Expected migrated INDEX file to be mapped, got %v", err)
+	}
+	check(mapped.Close())
+
+	// format version 4 keeps its collation
+	v4 := encodeIndexFile(index, FoldCase)
+	binary.BigEndian.PutUint32(v4[len(indexMagic):], 4)
+	headerSize := indexHeaderSize(4)
+	binary.BigEndian.PutUint32(v4[headerSize-4:], crc32cChecksum(v4[:headerSize-4]))
+	check(os.WriteFile(dirPath+"/INDEX", v4, 0644))
+	if migrated, err := MigrateIndex(dirPath); err != nil || !migrated {
+		t.Fatalf("Expected version 4 INDEX file to be migrated, got %v %v", migrated, err)
+	}
+	if collation, err := ReadCollation(dirPath); err != nil || collation != FoldCase {
+		t.Fatalf("Expected collation %d of migrated INDEX file, got %d %v", FoldCase, collation, err)
+	}
+
+	// pending journal is folded into the migrated file, which replaces the old one by rename
+	check(os.WriteFile(dirPath+"/INDEX", serializeIndex(index, plainEncoding), 0644))
+	journaled := readTestIndex(t, dirPath)
+	check(AddToIndex(dirPath, &journaled, 5, []byte(`{"age": 40}`)))
+	if migrated, err := MigrateIndex(dirPath); err != nil || !migrated {
+		t.Fatalf("Expected INDEX file with journal to be migrated, got %v %v", migrated, err)
+	}
+	if _, err := os.Stat(deltaPath(dirPath)); !os.IsNotExist(err) {
+		t.Fatalf("Expected journal to be folded into migrated INDEX file")
+	}
+	if current := readTestIndex(t, dirPath); !compareIndexes(journaled, current) {
+		t.Fatalf("Expected index different than actual:\n%v\n%v", journaled, current)
+	}
+	names, err := listDir(dirPath)
+	check(err)
+	for _, name := range names {
+		if strings.HasPrefix(name, ".INDEX-") {
+			t.Fatalf("Expected no temporary INDEX file left, got %s", name)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package nosqlite
+
+import (
+	"errors"
+	"os"
+
+	"github.com/jacnik/nosqlite/parser"
+)
+
+/* DB keeps INDEX file mapped instead of decoding it, see MappedIndex, and changes
+** journaled in INDEX.delta in a small in memory overlay.
+** Documents are added under new refs and changed by removing all their values before adding new ones,
+** so refs of changed documents are masked out of the mapped snapshot and the overlay holds all their current values.
+** Compacting writes a new snapshot and maps it in place of the old one.
+** Full-text index of TEXT file and its TEXT.delta journal is kept next to it, so MATCH does not read the file. */
+
+type journaledIndex struct {
+	mapped  *MappedIndex
+	changed fileRefs
+	overlay IndexT
+	text    *TextIndex // without keys when the directory has no TEXT file
+}
+
+// openJournaledIndex maps INDEX file of dirPath, replays its INDEX.delta journal into the overlay
+// and reads full-text index of the directory.
+func openJournaledIndex(dirPath string) (*journaledIndex, error) {
+	mapped, err := mapIndexFile(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	index := &journaledIndex{mapped: mapped}
+	deltaBytes, err := os.ReadFile(deltaPath(dirPath))
+	if err == nil {
+		err = replayIndexDelta(index, deltaBytes)
+	} else if errors.Is(err, os.ErrNotExist) {
+		err = nil
+	}
+	if err == nil {
+		index.text, err = ReadTextIndex(dirPath)
+	}
+	if index.text == nil {
+		index.text = NewTextIndex(nil)
+	}
+	if err != nil {
+		mapped.Close()
+		return nil, err
+	}
+	return index, nil
+}
+
+func (index *journaledIndex) conditionRefs(key string, op parser.OpType, val interface{}, valueType IndexEntryType) fileRefs {
+	refs := getFileRefs(index.mapped, key, op, val, valueType)
+	if len(index.overlay) == 0 && index.changed.IsEmpty() {
+		return refs
+	}
+	return refs.Difference(index.changed).Union(getFileRefs(index.overlay, key, op, val, valueType))
+}
+
+func (index *journaledIndex) applyDelta(op deltaOp, delta IndexT) {
+	index.changed = index.changed.Union(indexRefs(delta))
+	index.overlay.applyDelta(op, delta)
+}
+
+func (index *journaledIndex) compact(dirPath string) error {
+	full, err := ReadIndex(dirPath)
+	if err != nil {
+		return err
+	}
+	if err := SaveIndexWith(full, dirPath, index.mapped.Collation()); err != nil {
+		return err
+	}
+	mapped, err := mapIndexFile(dirPath)
+	if err != nil {
+		return err
+	}
+	index.mapped.Close()
+	index.mapped, index.changed, index.overlay = mapped, fileRefs{}, nil
+	return nil
+}
+
+func (index *journaledIndex) textIndex() *TextIndex { return index.text }
+
+// Close releases the mapped INDEX file.
+func (index *journaledIndex) Close() error {
+	index.changed, index.overlay, index.text = fileRefs{}, nil, nil
+	return index.mapped.Close()
+}
@@ -0,0 +1,327 @@
+package nosqlite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+)
+
+/* MappedIndex reads INDEX file through a memory map.
+** Only header and section sizes are checked when it is opened, entries and values are
+** located through directory offsets and decoded one at a time while a query
+** binary searches them, so opening does not depend on size of the index.
+** Every offset is checked when it is read, an entry is decoded once for all its values
+** read by a single lookup.
+** Front coded keys and strings are decoded from the closest one stored whole,
+** at most frontCodingInterval of them for every key or value.
+** Checksums are not verified, a damaged offset or value decodes to a zero value
+** and damaged file indexes are cut short instead. */
+
+// ErrPendingDelta is returned by MapIndex when INDEX.delta journal was not folded into INDEX yet.
+var ErrPendingDelta = errors.New("Index has changes pending in INDEX.delta")
+
+type MappedIndex struct {
+	data         []byte
+	unmap        func() error
+	entries      []byte
+	entryOffsets []byte
+	firstValues  []byte
+	valueOffsets []byte
+	nEntries     int
+	nValues      int
+	encoding     indexEncoding
+	collation    Collation
+}
+
+// MapIndex maps INDEX file of dirPath, which must be written in a format with directory
+// and must not have pending INDEX.delta journal.
+func MapIndex(dirPath string) (*MappedIndex, error) {
+	if _, err := os.Stat(deltaPath(dirPath)); err == nil {
+		return nil, ErrPendingDelta
+	}
+	return mapIndexFile(dirPath)
+}
+
+// mapIndexFile maps INDEX file of dirPath, ignoring its journal.
+func mapIndexFile(dirPath string) (*MappedIndex, error) {
+	f, err := os.Open(dirPath + "/INDEX")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	data, unmap, err := mmapFile(f, int(info.Size()))
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := newMappedIndex(data)
+	if err != nil {
+		unmap()
+		return nil, err
+	}
+	m.unmap = unmap
+	return m, nil
+}
+
+func newMappedIndex(data []byte) (*MappedIndex, error) {
+	if isLegacyIndexFile(data) {
+		return nil, fmt.Errorf("%w: INDEX without header must be migrated before mapping", ErrCorruptIndex)
+	}
+	header, entries, dirBytes, err := indexFileSections(data)
+	if err != nil {
+		return nil, err
+	}
+	if header.version < 2 {
+		return nil, fmt.Errorf("%w: INDEX format version %d must be migrated before mapping", ErrCorruptIndex, header.version)
+	}
+
+	// {entry offsets}{first value of entries}{value offsets}
+	nEntries := int(header.nEntries)
+	if len(dirBytes)%4 != 0 || len(dirBytes)/4 < 2*nEntries {
+		return nil, fmt.Errorf("%w: directory does not match %d entries", ErrCorruptIndex, nEntries)
+	}
+	return &MappedIndex{
+		data:         data,
+		entries:      entries,
+		entryOffsets: dirBytes[:4*nEntries],
+		firstValues:  dirBytes[4*nEntries : 8*nEntries],
+		valueOffsets: dirBytes[8*nEntries:],
+		nEntries:     nEntries,
+		nValues:      len(dirBytes)/4 - 2*nEntries,
+		encoding:     header.encoding(),
+		collation:    header.collation(),
+	}, nil
+}
+
+// Close unmaps INDEX file, the index must not be used afterwards.
+func (m *MappedIndex) Close() error {
+	m.entries, m.entryOffsets, m.firstValues, m.valueOffsets = nil, nil, nil, nil
+	m.nEntries, m.nValues = 0, 0
+	if m.unmap == nil {
+		return nil
+	}
+	unmap := m.unmap
+	m.unmap = nil
+	return unmap()
+}
+
+// Collation returns collation of string values of the index, which queries are collated with.
+func (m *MappedIndex) Collation() Collation { return m.collation }
+
+// Query returns file refs of documents matching the query.
+func (m *MappedIndex) Query(query string) ([]Ref, error) {
+	return QueryIndex(m, query)
+}
+
+// u32 returns i-th big endian uint32 of table.
+func (m *MappedIndex) u32(table []byte, i int) size_t {
+	return size_t(binary.BigEndian.Uint32(table[4*i : 4*i+4]))
+}
+
+// entriesU32 returns big endian uint32 at pos of entries or 0 when it does not fit.
+func (m *MappedIndex) entriesU32(pos int) size_t {
+	if pos < 0 || pos+4 > len(m.entries) {
+		return 0
+	}
+	return size_t(binary.BigEndian.Uint32(m.entries[pos : pos+4]))
+}
+
+// entriesPos returns offset read from the directory, or -1 when it points outside of entries.
+func (m *MappedIndex) entriesPos(offset size_t) int {
+	if uint64(offset) >= uint64(len(m.entries)) {
+		return -1
+	}
+	return int(offset)
+}
+
+// entryOffset returns offset in entries of i-th entry, or -1 when the directory is damaged.
+func (m *MappedIndex) entryOffset(i int) int {
+	return m.entriesPos(m.u32(m.entryOffsets, i))
+}
+
+// firstValue returns position in value offsets of the first value of i-th entry, at most the number of values.
+func (m *MappedIndex) firstValue(i int) int {
+	if i >= m.nEntries {
+		return m.nValues
+	}
+	return int(min(uint64(m.u32(m.firstValues, i)), uint64(m.nValues)))
+}
+
+// valueOffset returns offset in entries of j-th value of i-th entry, or -1 when the directory is damaged.
+func (m *MappedIndex) valueOffset(i, j int) int {
+	k := m.firstValue(i) + j
+	if k >= m.nValues {
+		return -1
+	}
+	return m.entriesPos(m.u32(m.valueOffsets, k))
+}
+
+func (m *MappedIndex) NumEntries() int { return m.nEntries }
+
+// frontCoded decodes front coded string stored at offset(k) for every k from the closest
+// multiple of frontCodingInterval up to i, and returns the last one with position after it.
+func (m *MappedIndex) frontCoded(i int, offset func(k int) int) (string, int) {
+	str, endPos := "", -1
+	for k := i - i%frontCodingInterval; k <= i; k++ {
+		shared, suffix, pos := readFrontCoded(m.entries, offset(k))
+		if pos < 0 || shared > len(str) {
+			return "", -1
+		}
+		str, endPos = str[:shared]+string(suffix), pos
+	}
+	return str, endPos
+}
+
+func (m *MappedIndex) EntryKey(i int) (string, IndexEntryType) {
+	if m.encoding&frontCodedStrings != 0 {
+		key, pos := m.frontCoded(i, m.entryOffset)
+		if pos < 0 || pos >= len(m.entries) {
+			return key, 0
+		}
+		return key, IndexEntryType(m.entries[pos])
+	}
+
+	pos := m.entryOffset(i)
+	if pos < 0 {
+		return "", 0
+	}
+	keyLen := bytes.IndexByte(m.entries[pos:], byte(NUL))
+	if keyLen < 0 || pos+keyLen+1 >= len(m.entries) {
+		return string(m.entries[pos:]), 0
+	}
+	return string(m.entries[pos : pos+keyLen]), IndexEntryType(m.entries[pos+keyLen+1])
+}
+
+func (m *MappedIndex) NumValues(i int) int {
+	return max(m.firstValue(i+1)-m.firstValue(i), 0)
+}
+
+// valueSize returns size of the value at pos, without its file indexes.
+func (m *MappedIndex) valueSize(valueType IndexEntryType, pos int) int {
+	switch valueType {
+	case FloatType, IntType:
+		return 8
+	case StrType:
+		if m.encoding&frontCodedStrings != 0 {
+			if _, _, endPos := readFrontCoded(m.entries, pos); endPos >= 0 {
+				return endPos - pos
+			}
+			return len(m.entries) - pos
+		}
+		if n := bytes.IndexByte(m.entries[pos:], byte(NUL)); n >= 0 {
+			return n + 1
+		}
+		return len(m.entries) - pos
+	case BoolType:
+		return 1
+	}
+	return 0
+}
+
+// mappedEntry is i-th entry of MappedIndex with its type decoded once for reading many of its values.
+type mappedEntry struct {
+	m         *MappedIndex
+	i         int
+	valueType IndexEntryType
+}
+
+func (m *MappedIndex) entry(i int) mappedEntry {
+	_, valueType := m.EntryKey(i)
+	return mappedEntry{m, i, valueType}
+}
+
+func (e mappedEntry) value(j int) interface{} {
+	m := e.m
+	if e.valueType == StrType && m.encoding&frontCodedStrings != 0 {
+		str, _ := m.frontCoded(j, func(k int) int { return m.valueOffset(e.i, k) })
+		return str
+	}
+	pos := m.valueOffset(e.i, j)
+	if pos < 0 {
+		return zeroValue(e.valueType)
+	}
+	size := m.valueSize(e.valueType, pos)
+	if pos+size > len(m.entries) {
+		return zeroValue(e.valueType)
+	}
+	switch e.valueType {
+	case FloatType:
+		return math.Float64frombits(binary.BigEndian.Uint64(m.entries[pos : pos+8]))
+	case IntType:
+		return int64(binary.BigEndian.Uint64(m.entries[pos : pos+8]))
+	case StrType:
+		return string(m.entries[pos : pos+size-1])
+	case BoolType:
+		return m.entries[pos] != 0
+	}
+	return nil
+}
+
+// eachRef passes every file index of j-th value of the entry to yield.
+func (e mappedEntry) eachRef(j int, yield func(Ref)) {
+	m := e.m
+	pos := m.valueOffset(e.i, j)
+	if pos < 0 {
+		return
+	}
+	pos += m.valueSize(e.valueType, pos)
+
+	if m.encoding&varintRefs != 0 {
+		readVarintRefs(m.entries, pos, yield)
+		return
+	}
+	nRefs := int(m.entriesU32(pos))
+	pos += 4
+	nRefs = max(min(nRefs, (len(m.entries)-pos)/4), 0)
+	for k := 0; k < nRefs; k++ {
+		yield(Ref(m.entriesU32(pos + 4*k)))
+	}
+}
+
+func (m *MappedIndex) EntryValue(i, j int) interface{} {
+	return m.entry(i).value(j)
+}
+
+// entryValues returns reader of values of i-th entry, which decodes the entry only once.
+func (m *MappedIndex) entryValues(i int) func(j int) interface{} {
+	return m.entry(i).value
+}
+
+func (m *MappedIndex) EntryRefs(i, j int) []Ref {
+	refs := make([]Ref, 0, 4)
+	m.entry(i).eachRef(j, func(ref Ref) { refs = append(refs, ref) })
+	return refs
+}
+
+// entryFileRefs returns reader decoding file indexes of values in [begin, end) of i-th entry straight into bitmap.
+func (m *MappedIndex) entryFileRefs(i int) func(begin, end int) fileRefs {
+	e := m.entry(i)
+	return func(begin, end int) fileRefs {
+		fr := fileRefs{}
+		for j := begin; j < end; j++ {
+			e.eachRef(j, func(ref Ref) { fr.Set(uint(ref)) })
+		}
+		return fr
+	}
+}
+
+func zeroValue(valueType IndexEntryType) interface{} {
+	switch valueType {
+	case FloatType:
+		return 0.0
+	case IntType:
+		return int64(0)
+	case StrType:
+		return ""
+	case BoolType:
+		return false
+	}
+	return nil
+}
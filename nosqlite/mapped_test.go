@@ -0,0 +1,141 @@
+package nosqlite
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func compareIndexReaders(t *testing.T, expected, actual IndexReader) {
+	t.Helper()
+	if expected.NumEntries() != actual.NumEntries() {
+		t.Fatalf("Expected %d entries, got %d", expected.NumEntries(), actual.NumEntries())
+	}
+	for i := 0; i < expected.NumEntries(); i++ {
+		expectedKey, expectedType := expected.EntryKey(i)
+		actualKey, actualType := actual.EntryKey(i)
+		if expectedKey != actualKey || expectedType != actualType || expected.NumValues(i) != actual.NumValues(i) {
+			t.Fatalf("Expected entry %v %c with %d values, got %v %c with %d values",
+				expectedKey, expectedType, expected.NumValues(i), actualKey, actualType, actual.NumValues(i))
+		}
+		for j := 0; j < expected.NumValues(i); j++ {
+			if expected.EntryValue(i, j) != actual.EntryValue(i, j) || !compareSlices(expected.EntryRefs(i, j), actual.EntryRefs(i, j)) {
+				t.Fatalf("Expected value of %v different than actual:\n%v %v\n%v %v", expectedKey,
+					expected.EntryValue(i, j), expected.EntryRefs(i, j), actual.EntryValue(i, j), actual.EntryRefs(i, j))
+			}
+		}
+	}
+}
+
+// Check if mapped INDEX gives the same entries and query results as decoded one.
+func TestMapIndex(t *testing.T) {
+	index := readTestIndex(t, "./db")
+	mapped, err := MapIndex("./db")
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	defer mapped.Close()
+
+	compareIndexReaders(t, index, mapped)
+
+	for _, query := range []string{
+		"SELECT * FROM c WHERE c.social.twitter = 'https://twitter.com'",
+		"SELECT * FROM c WHERE c.age > 20 OR c.name = 'Fraser'",
+		"SELECT * FROM c WHERE c.age BETWEEN 10 AND 20",
+		"SELECT * FROM c WHERE c.active = FALSE",
+		"SELECT * FROM c WHERE c.name IS NULL OR c.active IS NOT NULL",
+		"SELECT * FROM c WHERE NOT c.type != 'Reader'",
+	} {
+		expected, err := QueryIndex(&index, query)
+		check(err)
+		actual, err := mapped.Query(query)
+		if err != nil {
+			t.Fatalf("Got unexpected error for %s: %v", query, err)
+		}
+		if !compareSlices(expected, actual) {
+			t.Fatalf("Expected refs for %s different than actual:\n%v\n%v", query, expected, actual)
+		}
+	}
+}
+
+// Check if values of a larger mapped index are binary searched like decoded ones.
+func TestMapLargeIndex(t *testing.T) {
+	dirPath := t.TempDir()
+	index := IndexT{}
+	for ref := Ref(0); ref < 500; ref++ {
+		doc := fmt.Sprintf(`{"n": %d, "s": "v%03d", "even": %t, "tag": "t%d", "opt": null, "key%c": "\u0000%d"}`,
+			ref%97, ref, ref%2 == 0, ref%5, 'a'+ref%26, ref%3)
+		delta, err := documentIndex([]byte(doc), ref, BinaryCollation)
+		check(err)
+		mergeIndex(&index, delta)
+	}
+	check(SaveIndex(index, dirPath))
+
+	mapped, err := MapIndex(dirPath)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	defer mapped.Close()
+	compareIndexReaders(t, index, mapped)
+
+	for _, query := range []string{
+		"SELECT * FROM c WHERE c.n = 13",
+		"SELECT * FROM c WHERE c.n >= 90 AND c.even = TRUE",
+		"SELECT * FROM c WHERE c.s BETWEEN 'v100' AND 'v120' OR c.tag = 't3'",
+		"SELECT * FROM c WHERE c.s < 'v010' AND NOT c.opt IS NULL",
+		"SELECT * FROM c WHERE c.keyq IS NOT NULL OR c.keyz = '\x001'",
+	} {
+		expected, err := QueryIndex(&index, query)
+		check(err)
+		actual, err := mapped.Query(query)
+		if err != nil {
+			t.Fatalf("Got unexpected error for %s: %v", query, err)
+		}
+		if !compareSlices(expected, actual) {
+			t.Fatalf("Expected refs for %s different than actual:\n%v\n%v", query, expected, actual)
+		}
+	}
+}
+
+// Check if INDEX with pending journal or old format is not mapped and damaged directory does not break queries.
+func TestMapIndexErrors(t *testing.T) {
+	dirPath := copyDb(t)
+	index := readTestIndex(t, dirPath)
+	check(AddToIndex(dirPath, &index, 5, []byte(`{"age": 40}`)))
+	if _, err := MapIndex(dirPath); !errors.Is(err, ErrPendingDelta) {
+		t.Fatalf("Expected ErrPendingDelta, got %v", err)
+	}
+
+	check(os.WriteFile(dirPath+"/INDEX", serializeIndex(index, plainEncoding), 0644))
+	check(os.Remove(deltaPath(dirPath)))
+	if _, err := MapIndex(dirPath); !errors.Is(err, ErrCorruptIndex) {
+		t.Fatalf("Expected ErrCorruptIndex for INDEX without header, got %v", err)
+	}
+
+	// directory is checked only when its offsets are read, so damaged offsets give no values instead of panicking
+	fileBytes := encodeIndexFile(index, BinaryCollation)
+	_, _, dirBytes, err := indexFileSections(fileBytes)
+	check(err)
+	for i := range dirBytes {
+		dirBytes[i] = 0xff
+	}
+	check(os.WriteFile(dirPath+"/INDEX", fileBytes, 0644))
+	mapped, err := MapIndex(dirPath)
+	if err != nil {
+		t.Fatalf("Got unexpected error for damaged directory: %v", err)
+	}
+	defer mapped.Close()
+	for _, query := range []string{"SELECT * FROM c WHERE c.age > 20", "SELECT * FROM c WHERE c.name LIKE 'F%'"} {
+		if refs, err := mapped.Query(query); err != nil || len(refs) != 0 {
+			t.Fatalf("Expected no refs for %s with damaged directory, got %v %v", query, refs, err)
+		}
+	}
+	if _, err := ReadIndex(dirPath); !errors.Is(err, ErrCorruptIndex) {
+		t.Fatalf("Expected ErrCorruptIndex for damaged directory, got %v", err)
+	}
+
+	if _, err := MapIndex(t.TempDir()); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Expected missing INDEX error, got %v", err)
+	}
+}
@@ -0,0 +1,135 @@
+package nosqlite
+
+import (
+	"cmp"
+	"sort"
+	"strings"
+
+	"github.com/jacnik/nosqlite/parser"
+)
+
+/* String matching of LIKE, STARTS_WITH, ENDS_WITH and CONTAINS conditions.
+** String values of an entry are sorted, so values sharing a prefix are adjacent:
+** STARTS_WITH takes refs of the binary searched range of its prefix as they are,
+** LIKE scans only the range of the literal prefix of its pattern, up to the first '%' or '_',
+** and ENDS_WITH and CONTAINS scan every value of the entry.
+** Conditions on LOWER or UPPER of a key scan every value too, as converted values are no longer sorted. */
+
+// likePrefix returns the literal part of pattern before its first wildcard.
+func likePrefix(pattern string) string {
+	if i := strings.IndexAny(pattern, "%_"); i >= 0 {
+		return pattern[:i]
+	}
+	return pattern
+}
+
+// matchLike reports whether value matches LIKE pattern, '%' matches any sequence of characters
+// and '_' a single character. Wildcards can not be escaped.
+func matchLike(pattern, value string) bool {
+	p, v := []rune(pattern), []rune(value)
+	pi, vi := 0, 0
+	// position of the last '%' in pattern and of value matched by it, to backtrack to
+	anyPi, anyVi := -1, 0
+	for vi < len(v) {
+		switch {
+		case pi < len(p) && p[pi] == '%':
+			anyPi, anyVi = pi, vi
+			pi++
+		case pi < len(p) && (p[pi] == '_' || p[pi] == v[vi]):
+			pi++
+			vi++
+		case anyPi >= 0:
+			// let the last '%' match one more character
+			anyVi++
+			pi, vi = anyPi+1, anyVi
+		default:
+			return false
+		}
+	}
+	for pi < len(p) && p[pi] == '%' {
+		pi++
+	}
+	return pi == len(p)
+}
+
+// matchingRefs returns refs of string values of the entry matching pattern of op.
+func matchingRefs(index IndexReader, entryIdx int, op parser.OpType, pattern string) fileRefs {
+	prefix, match := "", func(value string) bool { return true }
+	switch op {
+	case parser.StartsWith:
+		prefix = pattern
+	case parser.Like:
+		prefix = likePrefix(pattern)
+		if pattern != prefix+"%" {
+			match = func(value string) bool { return matchLike(pattern, value) }
+		}
+	case parser.EndsWith:
+		match = func(value string) bool { return strings.HasSuffix(value, pattern) }
+	case parser.Contains:
+		match = func(value string) bool { return strings.Contains(value, pattern) }
+	default:
+		panic("Unknown operator")
+	}
+
+	values, refs := entryValues(index, entryIdx), valuesRefs(index, entryIdx)
+	n := index.NumValues(entryIdx)
+	begin := sort.Search(n, func(j int) bool {
+		return values(j).(string) >= prefix
+	})
+	end := begin + sort.Search(n-begin, func(j int) bool {
+		return !strings.HasPrefix(values(begin+j).(string), prefix)
+	})
+	if op == parser.StartsWith || (op == parser.Like && pattern == prefix+"%") {
+		return refs(begin, end)
+	}
+
+	fr := fileRefs{}
+	for valueIdx := begin; valueIdx < end; valueIdx++ {
+		if match(values(valueIdx).(string)) {
+			fr = fr.Union(refs(valueIdx, valueIdx+1))
+		}
+	}
+	return fr
+}
+
+// convertedRefs returns refs of string values of the entry which satisfy op once converted by LOWER or UPPER.
+func convertedRefs(index IndexReader, entryIdx int, op parser.OpType, caseValue parser.CaseValue) fileRefs {
+	convert := strings.ToLower
+	if caseValue.Func == parser.Upper {
+		convert = strings.ToUpper
+	}
+	match := func(value string) bool {
+		switch op {
+		case parser.Like:
+			return matchLike(caseValue.Val.(string), value)
+		case parser.Between:
+			r := caseValue.Val.(parser.Range)
+			return value >= r.From.(string) && value <= r.To.(string)
+		}
+		valueCmp := cmp.Compare(value, caseValue.Val.(string))
+		switch op {
+		case parser.Eq:
+			return valueCmp == 0
+		case parser.Ne:
+			return valueCmp != 0
+		case parser.Gt:
+			return valueCmp > 0
+		case parser.Ge:
+			return valueCmp >= 0
+		case parser.Lt:
+			return valueCmp < 0
+		case parser.Le:
+			return valueCmp <= 0
+		}
+		panic("Unknown operator")
+	}
+
+	values, refs := entryValues(index, entryIdx), valuesRefs(index, entryIdx)
+	fr := fileRefs{}
+	for valueIdx := 0; valueIdx < index.NumValues(entryIdx); valueIdx++ {
+		if match(convert(values(valueIdx).(string))) {
+			fr = fr.Union(refs(valueIdx, valueIdx+1))
+		}
+	}
+	return fr
+}
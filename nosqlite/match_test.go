@@ -0,0 +1,74 @@
+package nosqlite
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+// Check if LIKE patterns match whole values with '%' and '_' wildcards.
+func TestMatchLike(t *testing.T) {
+	assert := func(pattern, value string, expected bool) {
+		if actual := matchLike(pattern, value); actual != expected {
+			t.Fatalf("Expected %q LIKE %q to be %t, got %t", value, pattern, expected, actual)
+		}
+	}
+	assert("https://%", "https://twitter.com", true)
+	assert("https://%", "http://twitter.com", false)
+	assert("%.com", "https://twitter.com", true)
+	assert("%witt%", "https://twitter.com", true)
+	assert("%a%b%c", "xaxbxbxc", true)
+	assert("%a%b%c", "xaxbxbx", false)
+	assert("El_iot", "Elliot", true)
+	assert("El_iot", "Eliot", false)
+	assert("Ż_ł%", "Żółw", true)
+	assert("%", "", true)
+	assert("_", "", false)
+	assert("Elliot", "Elliot", true)
+	assert("Elliot", "Elliott", false)
+	assert("%a", "%ba", true)
+
+	if prefix := likePrefix("ab_c%"); prefix != "ab" {
+		t.Fatalf("Expected prefix ab, got %s", prefix)
+	}
+}
+
+// Check if string conditions find refs both in memory and memory mapped index.
+func TestQueryIndexStringMatching(t *testing.T) {
+	dirPath := t.TempDir()
+	names := []string{"Elliot", "Ellie", "Fraser", "Ella", "ella", "Bella", "Eli"}
+	paths := make([]string, len(names))
+	for i, name := range names {
+		paths[i] = fmt.Sprintf("%s/%d", dirPath, i)
+		check(os.WriteFile(paths[i], []byte(fmt.Sprintf(`{"name": %q, "age": %d}`, name, i)), 0644))
+	}
+	index := indexTestFiles(t, paths)
+	check(SaveIndex(index, dirPath))
+	mapped, err := MapIndex(dirPath)
+	check(err)
+	defer mapped.Close()
+
+	assert := func(query string, expected ...Ref) {
+		for _, reader := range []IndexReader{&index, mapped} {
+			refs, err := QueryIndex(reader, query)
+			if err != nil {
+				t.Fatalf("Got unexpected error for %s: %v", query, err)
+			}
+			if !compareSlices(refs, expected) {
+				t.Fatalf("Expected refs for %s different than actual:\n%v\n%v", query, expected, refs)
+			}
+		}
+	}
+
+	assert("SELECT * FROM c WHERE c.name LIKE 'Ell%'", 0, 1, 3)
+	assert("SELECT * FROM c WHERE c.name LIKE 'El%i%'", 0, 1, 6)
+	assert("SELECT * FROM c WHERE c.name LIKE '_lla'", 3, 4)
+	assert("SELECT * FROM c WHERE c.name LIKE 'Eli'", 6)
+	assert("SELECT * FROM c WHERE c.name LIKE 'Z%'")
+	assert("SELECT * FROM c WHERE STARTS_WITH(c.name, 'Ell')", 0, 1, 3)
+	assert("SELECT * FROM c WHERE STARTS_WITH(c.name, '')", 0, 1, 2, 3, 4, 5, 6)
+	assert("SELECT * FROM c WHERE ENDS_WITH(c.name, 'lla')", 3, 4, 5)
+	assert("SELECT * FROM c WHERE CONTAINS(c.name, 'll') AND c.age > 3", 4, 5)
+	assert("SELECT * FROM c WHERE NOT CONTAINS(c.name, 'e')", 0, 3, 6)
+	assert("SELECT * FROM c WHERE c.age LIKE '1%'")
+}
@@ -0,0 +1,20 @@
+//go:build unix
+
+package nosqlite
+
+import (
+	"os"
+	"syscall"
+)
+
+// mmapFile maps size bytes of f read only, returned unmap releases the mapping.
+func mmapFile(f *os.File, size int) ([]byte, func() error, error) {
+	if size == 0 {
+		return nil, func() error { return nil }, nil
+	}
+	data, err := syscall.Mmap(int(f.Fd()), 0, size, syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func() error { return syscall.Munmap(data) }, nil
+}
@@ -1,7 +1,6 @@
-package main
+package nosqlite
 
 import (
-	"bufio"
 	"bytes"
 	"cmp"
 	"encoding/binary"
@@ -9,19 +8,20 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"math"
 	"os"
-	"path/filepath"
 	"slices"
+	"sort"
 	"strconv"
-	"strings"
 
 	"github.com/jacnik/bitflags"
 	"github.com/jacnik/nosqlite/parser"
 )
 
 type size_t uint32
+
+// Ref identifies a document of a database directory.
+type Ref uint32
 type separator byte
 
 const (
@@ -38,13 +38,15 @@ type IndexEntryType byte
 
 const (
 	FloatType IndexEntryType = 'f'
+	IntType   IndexEntryType = 'i' // numbers written without fraction or exponent which fit in int64
 	StrType   IndexEntryType = 's'
+	BoolType  IndexEntryType = 'b'
 	NullType  IndexEntryType = 'n'
 )
 
 type ValueRefs struct {
 	value interface{}
-	refs  []size_t
+	refs  []Ref
 }
 
 func (v ValueRefs) String() string {
@@ -57,8 +59,39 @@ type IndexEntry struct {
 	values    []ValueRefs
 }
 
+func (v ValueRefs) Value() interface{} { return v.value }
+
+// Refs returns sorted file refs of documents holding the value.
+func (v ValueRefs) Refs() []Ref { return v.refs }
+
+func (e IndexEntry) Key() string { return e.key }
+
+func (e IndexEntry) Type() IndexEntryType { return e.valueType }
+
+func (e IndexEntry) Values() []ValueRefs { return e.values }
+
 type IndexT []IndexEntry
 
+// IndexReader gives access to entries of an index sorted by key and type, and to their sorted values.
+// IndexT holds them decoded in memory, MappedIndex decodes them lazily from mapped INDEX file.
+type IndexReader interface {
+	NumEntries() int
+	EntryKey(i int) (string, IndexEntryType)
+	NumValues(i int) int
+	EntryValue(i, j int) interface{}
+	EntryRefs(i, j int) []Ref
+}
+
+func (index IndexT) NumEntries() int { return len(index) }
+
+func (index IndexT) EntryKey(i int) (string, IndexEntryType) { return index[i].key, index[i].valueType }
+
+func (index IndexT) NumValues(i int) int { return len(index[i].values) }
+
+func (index IndexT) EntryValue(i, j int) interface{} { return index[i].values[j].value }
+
+func (index IndexT) EntryRefs(i, j int) []Ref { return index[i].values[j].refs }
+
 /* Types used when aggregating json */
 /* **** */
 type aggregateKeyT struct {
@@ -66,48 +99,69 @@ type aggregateKeyT struct {
 	valueType IndexEntryType
 }
 type aggregateValueT interface{}
-type aggregateFileRefT []size_t
+type aggregateFileRefT []Ref
 type aggregateT map[aggregateKeyT]map[aggregateValueT]aggregateFileRefT
 
-type flattenJsonT map[aggregateKeyT]aggregateValueT
+// flattenValueT is a single value of flattened json together with its key and type.
+type flattenValueT struct {
+	aggregateKeyT
+	value aggregateValueT
+}
+
+// flattenJsonT is a set, elements of an array may share wildcard key and value.
+type flattenJsonT map[flattenValueT]struct{}
 
 /* **** */
 
-func check(err error) {
-	if err != nil {
-		log.Fatal(err)
-		panic(err)
-	}
-}
+// ErrCorruptIndex is returned when INDEX, INDEX.delta or DOCS file can not be decoded.
+var ErrCorruptIndex = errors.New("Corrupt index")
 
-func listDir(path string) []string {
+func listDir(path string) ([]string, error) {
 	files, err := os.ReadDir(path)
-	check(err)
+	if err != nil {
+		return nil, err
+	}
 
 	names := make([]string, 0, 16)
 	for _, entry := range files {
-		if !entry.IsDir() && entry.Name() != "INDEX" {
+		if !entry.IsDir() && !isDatabaseFile(entry.Name()) {
 			names = append(names, entry.Name())
 		}
 	}
-	return names
+	return names, nil
 }
 
-func readFile(path string) []byte {
+func readFile(path string) ([]byte, error) {
 	jsonFile, err := os.Open(path)
-	check(err)
+	if err != nil {
+		return nil, err
+	}
 	defer jsonFile.Close()
 
-	bytes, err := io.ReadAll(jsonFile)
-	check(err)
-
-	return bytes
+	return io.ReadAll(jsonFile)
 }
 
-func parseJson(bytes []byte) interface{} {
+// parseJson decodes numbers as json.Number, so integers keep their precision until they are flattened.
+func parseJson(doc []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(doc))
+	decoder.UseNumber()
 	var result interface{}
-	json.Unmarshal(bytes, &result)
-	return result
+	if err := decoder.Decode(&result); err != nil {
+		return nil, err
+	}
+	if rest := bytes.TrimSpace(doc[decoder.InputOffset():]); len(rest) > 0 {
+		return nil, fmt.Errorf("invalid character %q after top-level value", rest[0])
+	}
+	return result, nil
+}
+
+// numberValue returns integer literal as int64 and any other number as float64.
+func numberValue(number json.Number) (aggregateValueT, IndexEntryType) {
+	if i, err := strconv.ParseInt(string(number), 10, 64); err == nil {
+		return i, IntType
+	}
+	f, _ := strconv.ParseFloat(string(number), 64)
+	return f, FloatType
 }
 
 func flattenJsonMap(flatten flattenJsonT, prefix string, jMap map[string]interface{}) {
@@ -116,9 +170,12 @@ func flattenJsonMap(flatten flattenJsonT, prefix string, jMap map[string]interfa
 	}
 }
 
+// flattenJsonArr flattens every element under its position and under parser.WildcardKey,
+// so a condition on the wildcard holds when any element satisfies it.
 func flattenJsonArr(flatten flattenJsonT, prefix string, jArr []interface{}) {
 	for i, jItem := range jArr {
 		flattenWithPrefix(flatten, prefix+"/"+strconv.Itoa(i), jItem)
+		flattenWithPrefix(flatten, prefix+"/"+parser.WildcardKey, jItem)
 	}
 }
 
@@ -129,11 +186,18 @@ func flattenWithPrefix(flatten flattenJsonT, prefix string, unflatten interface{
 	case []interface{}:
 		flattenJsonArr(flatten, prefix, v)
 	case string:
-		flatten[aggregateKeyT{prefix, StrType}] = v
+		flatten[flattenValueT{aggregateKeyT{prefix, StrType}, v}] = struct{}{}
+	case json.Number:
+		value, valueType := numberValue(v)
+		flatten[flattenValueT{aggregateKeyT{prefix, valueType}, value}] = struct{}{}
+	case int64:
+		flatten[flattenValueT{aggregateKeyT{prefix, IntType}, v}] = struct{}{}
 	case float64:
-		flatten[aggregateKeyT{prefix, FloatType}] = v
+		flatten[flattenValueT{aggregateKeyT{prefix, FloatType}, v}] = struct{}{}
+	case bool:
+		flatten[flattenValueT{aggregateKeyT{prefix, BoolType}, v}] = struct{}{}
 	default:
-		flatten[aggregateKeyT{prefix, NullType}] = v
+		flatten[flattenValueT{aggregateKeyT{prefix, NullType}, v}] = struct{}{}
 	}
 }
 
@@ -143,12 +207,13 @@ func flattenJson(unflatten interface{}) flattenJsonT {
 	return flatten
 }
 
-func aggregateJson(agg aggregateT, flatten flattenJsonT, fileIdx size_t) {
-	for aggregateKey, aggregateValue := range flatten {
+func aggregateJson(agg aggregateT, flatten flattenJsonT, fileIdx Ref) {
+	for flattenValue := range flatten {
+		aggregateKey, aggregateValue := flattenValue.aggregateKeyT, flattenValue.value
 		fileRefsMap, hasFileRefsMap := agg[aggregateKey]
 		if !hasFileRefsMap {
 			fileRefsMap = make(map[aggregateValueT]aggregateFileRefT)
-			fileRefsMap[aggregateValue] = []size_t{fileIdx}
+			fileRefsMap[aggregateValue] = []Ref{fileIdx}
 			agg[aggregateKey] = fileRefsMap
 		} else {
 			fileRefsMap[aggregateValue] = append(fileRefsMap[aggregateValue], fileIdx)
@@ -156,29 +221,103 @@ func aggregateJson(agg aggregateT, flatten flattenJsonT, fileIdx size_t) {
 	}
 }
 
-func sortValues(values map[aggregateValueT]aggregateFileRefT, valueType IndexEntryType) []ValueRefs {
-	aggregateValueCmp := func(a, b aggregateValueT) int {
-		/* Should return a negative number when a < b,
-		** a positive number when a > b
-		** and zero when a == b. */
-		switch valueType {
-		case FloatType:
-			return cmp.Compare(a.(float64), b.(float64))
-		case StrType:
-			return cmp.Compare(a.(string), b.(string))
-		case NullType:
-			return 0
+func boolCmp(a, b bool) int {
+	/* false < true */
+	switch {
+	case a == b:
+		return 0
+	case !a:
+		return -1
+	default:
+		return 1
+	}
+}
+
+func aggregateValueCmp(a, b aggregateValueT, valueType IndexEntryType) int {
+	/* Should return a negative number when a < b,
+	** a positive number when a > b
+	** and zero when a == b. */
+	switch valueType {
+	case FloatType:
+		return cmp.Compare(a.(float64), b.(float64))
+	case IntType:
+		return cmp.Compare(a.(int64), b.(int64))
+	case StrType:
+		return cmp.Compare(a.(string), b.(string))
+	case BoolType:
+		return boolCmp(a.(bool), b.(bool))
+	case NullType:
+		return 0
+	}
+	message := fmt.Sprintf("Unknown type %c\n", valueType)
+	panic(message)
+}
+
+// compareNumbers compares int64 and float64 numbers by their exact values.
+func compareNumbers(a, b interface{}) int {
+	switch a := a.(type) {
+	case int64:
+		switch b := b.(type) {
+		case int64:
+			return cmp.Compare(a, b)
+		case float64:
+			return compareIntFloat(a, b)
+		}
+	case float64:
+		switch b := b.(type) {
+		case int64:
+			return -compareIntFloat(b, a)
+		case float64:
+			return cmp.Compare(a, b)
 		}
-		message := fmt.Sprintf("Unknown type %c\n", valueType)
-		panic(message)
 	}
+	panic("got unknown type")
+}
+
+// valueKind returns type of query value, which is the same for integers and floats.
+func valueKind(val interface{}) IndexEntryType {
+	switch val.(type) {
+	case string:
+		return StrType
+	case int, int64, float64:
+		return FloatType
+	case bool:
+		return BoolType
+	case nil:
+		return NullType
+	}
+	return 0
+}
+
+// compareIntFloat compares i and f without rounding i to float64.
+func compareIntFloat(i int64, f float64) int {
+	switch {
+	case math.IsNaN(f):
+		// like cmp.Compare, NaN is lower than any number
+		return 1
+	case f >= math.MaxInt64:
+		// float64(math.MaxInt64) is rounded up to 2^63
+		return -1
+	case f < math.MinInt64:
+		return 1
+	}
+	trunc := math.Trunc(f)
+	if c := cmp.Compare(i, int64(trunc)); c != 0 {
+		return c
+	}
+	return cmp.Compare(0, f-trunc)
+}
+
+func sortValues(values map[aggregateValueT]aggregateFileRefT, valueType IndexEntryType) []ValueRefs {
 
 	keys := make([]aggregateValueT, 0, len(values))
 	for k := range values {
 		keys = append(keys, k)
 	}
 
-	slices.SortFunc(keys, aggregateValueCmp)
+	slices.SortFunc(keys, func(a, b aggregateValueT) int {
+		return aggregateValueCmp(a, b, valueType)
+	})
 
 	sortedRefs := make([]ValueRefs, 0, len(keys))
 	for _, key := range keys {
@@ -228,8 +367,109 @@ func printIndex(index IndexT) {
 	fmt.Printf("\n")
 }
 
-func serializeIndex(index IndexT) []byte {
+// indexEncoding tells how file indexes and strings are stored in INDEX entries,
+// INDEX file header stores it as {flags}.
+type indexEncoding size_t
+
+const (
+	// {n file indexes}{file indexes} as uvarints, file indexes are sorted and each is stored as a difference to the previous one,
+	// otherwise as 4 byte big endian ints
+	varintRefs indexEncoding = 1 << iota
+	// keys and string values as {n shared bytes}{n suffix bytes}{suffix}, see appendFrontCoded,
+	// otherwise as NUL terminated strings
+	frontCodedStrings
+)
+
+const (
+	// encoding of INDEX files written before {flags} were added
+	plainEncoding indexEncoding = 0
+	// encoding of INDEX files written now
+	currentEncoding = varintRefs | frontCodedStrings
+	// every encoding bit known to this version
+	knownEncodings = varintRefs | frontCodedStrings
+)
+
+// Every frontCodingInterval-th key and every frontCodingInterval-th string value of an entry
+// is stored whole, so it can be decoded without the ones before it.
+const frontCodingInterval = 16
+
+// appendFrontCoded appends s as {n shared bytes}{n suffix bytes}{suffix} uvarints and bytes,
+// where shared bytes are the common prefix with prev.
+func appendFrontCoded(buff *bytes.Buffer, s, prev string) {
+	shared := 0
+	for shared < len(s) && shared < len(prev) && s[shared] == prev[shared] {
+		shared++
+	}
+	var varint [binary.MaxVarintLen64]byte
+	buff.Write(binary.AppendUvarint(varint[:0], uint64(shared)))        // {n shared bytes}
+	buff.Write(binary.AppendUvarint(varint[:0], uint64(len(s)-shared))) // {n suffix bytes}
+	buff.WriteString(s[shared:])                                        // {suffix}
+}
+
+// readFrontCoded reads string stored by appendFrontCoded at pos.
+// It returns n of bytes shared with the previous string, the suffix and position after it,
+// or -1 position when it is malformed.
+func readFrontCoded(bytes []byte, pos int) (int, []byte, int) {
+	if pos < 0 || pos > len(bytes) {
+		return 0, nil, -1
+	}
+	shared, size := binary.Uvarint(bytes[pos:])
+	if size <= 0 || shared > uint64(len(bytes)) {
+		return 0, nil, -1
+	}
+	pos += size
+	suffixLen, size := binary.Uvarint(bytes[pos:])
+	if size <= 0 || suffixLen > uint64(len(bytes)-pos-size) {
+		return 0, nil, -1
+	}
+	pos += size
+	return int(shared), bytes[pos : pos+int(suffixLen)], pos + int(suffixLen)
+}
+
+// indexDir holds offsets of entries and values in serialized entries,
+// and position of the first value of every entry in value offsets.
+type indexDir struct {
+	entryOffsets []size_t
+	firstValues  []size_t
+	valueOffsets []size_t
+}
+
+func serializeIndex(index IndexT, encoding indexEncoding) []byte {
+	entriesBytes, _ := serializeIndexWithDir(index, encoding)
+	return entriesBytes
+}
+
+func serializeIndexWithDir(index IndexT, encoding indexEncoding) ([]byte, indexDir) {
+	w := indexWriter{
+		encoding: encoding,
+		buff:     bytes.NewBuffer(make([]byte, 0, 512)),
+		dir: indexDir{
+			entryOffsets: make([]size_t, 0, len(index)),
+			firstValues:  make([]size_t, 0, len(index)),
+			valueOffsets: make([]size_t, 0, len(index)),
+		},
+	}
+	for _, indexEntry := range index {
+		w.appendEntry(indexEntry)
+	}
+	return w.buff.Bytes(), w.dir
+}
+
+// indexWriter serializes entries one by one, recording their offsets in dir.
+// Bytes taken out of buff are counted in base, so offsets stay relative to the first entry.
+type indexWriter struct {
+	encoding indexEncoding
+	buff     *bytes.Buffer
+	dir      indexDir
+	base     size_t
+	nEntries int
+	nValues  size_t
+	prevKey  string
+}
+
+func (w *indexWriter) appendEntry(indexEntry IndexEntry) {
 	stringSep := byte(NUL)
+	encoding := w.encoding
 
 	appendInt := func(buff *bytes.Buffer, i size_t) {
 		binary.Write(buff, binary.BigEndian, i)
@@ -239,107 +479,268 @@ func serializeIndex(index IndexT) []byte {
 		binary.Write(buff, binary.BigEndian, f)
 	}
 
-	appendFileRefs := func(buff *bytes.Buffer, fileRefs []size_t) {
+	appendUvarint := func(buff *bytes.Buffer, i size_t) {
+		var varint [binary.MaxVarintLen32]byte
+		buff.Write(binary.AppendUvarint(varint[:0], uint64(i)))
+	}
+
+	appendStr := func(buff *bytes.Buffer, s, prev string) {
+		if encoding&frontCodedStrings != 0 {
+			appendFrontCoded(buff, s, prev)
+			return
+		}
+		buff.WriteString(s)       // {string}
+		buff.WriteByte(stringSep) // {string sep}
+	}
+
+	appendFileRefs := func(buff *bytes.Buffer, fileRefs []Ref) {
+		if encoding&varintRefs != 0 {
+			appendUvarint(buff, size_t(len(fileRefs))) // {n file indexes}
+			prev := Ref(0)
+			for _, fileRef := range fileRefs { // {file indexes}
+				appendUvarint(buff, size_t(fileRef-prev))
+				prev = fileRef
+			}
+			return
+		}
 		appendInt(buff, size_t(len(fileRefs))) // {n file indexes}
 		for _, fileRef := range fileRefs {     // {file indexes}
-			appendInt(buff, fileRef)
+			appendInt(buff, size_t(fileRef))
 		}
 	}
+	appendValueOffset := func(buff *bytes.Buffer) {
+		w.dir.valueOffsets = append(w.dir.valueOffsets, w.base+size_t(buff.Len()))
+		w.nValues++
+	}
+
 	appendFloatRefs := func(buff *bytes.Buffer, valueRefs []ValueRefs) {
 		appendInt(buff, size_t(len(valueRefs))) // {n of values}
 		for _, valueRef := range valueRefs {
+			appendValueOffset(buff)
 			appendFloat(buff, valueRef.value.(float64)) // {value}
 			appendFileRefs(buff, valueRef.refs)
 		}
 	}
 
+	appendIntegerRefs := func(buff *bytes.Buffer, valueRefs []ValueRefs) {
+		appendInt(buff, size_t(len(valueRefs))) // {n of values}
+		for _, valueRef := range valueRefs {
+			appendValueOffset(buff)
+			binary.Write(buff, binary.BigEndian, valueRef.value.(int64)) // {value}
+			appendFileRefs(buff, valueRef.refs)
+		}
+	}
+
 	appendStringRefs := func(buff *bytes.Buffer, valueRefs []ValueRefs) {
+		appendInt(buff, size_t(len(valueRefs))) // {n of values}
+		prev := ""
+		for i, valueRef := range valueRefs {
+			appendValueOffset(buff)
+			if i%frontCodingInterval == 0 {
+				prev = ""
+			}
+			appendStr(buff, valueRef.value.(string), prev) // {value}
+			prev = valueRef.value.(string)
+			appendFileRefs(buff, valueRef.refs)
+		}
+	}
+
+	appendBoolRefs := func(buff *bytes.Buffer, valueRefs []ValueRefs) {
 		appendInt(buff, size_t(len(valueRefs))) // {n of values}
 		for _, valueRef := range valueRefs {
-			buff.WriteString(valueRef.value.(string)) // {value}
-			buff.WriteByte(stringSep)                 // {string sep}
+			appendValueOffset(buff)
+			if valueRef.value.(bool) { // {value}
+				buff.WriteByte(1)
+			} else {
+				buff.WriteByte(0)
+			}
 			appendFileRefs(buff, valueRef.refs)
 		}
 	}
 
 	appendNullRefs := func(buff *bytes.Buffer, valueRefs []ValueRefs) {
 		for _, valueRef := range valueRefs {
+			appendValueOffset(buff)
 			appendFileRefs(buff, valueRef.refs)
 		}
 	}
 
-	buff := bytes.NewBuffer(make([]byte, 0, 512))
+	buff := w.buff
+	w.dir.entryOffsets = append(w.dir.entryOffsets, w.base+size_t(buff.Len()))
+	w.dir.firstValues = append(w.dir.firstValues, w.nValues)
 
-	for _, indexEntry := range index {
-		buff.WriteString(indexEntry.key)           // {key}
-		buff.WriteByte(stringSep)                  // {string sep}
-		buff.WriteByte(byte(indexEntry.valueType)) // {type byte = 'f' | 's' | 'n'}
+	if w.nEntries%frontCodingInterval == 0 {
+		w.prevKey = ""
+	}
+	appendStr(buff, indexEntry.key, w.prevKey) // {key}
+	buff.WriteByte(byte(indexEntry.valueType)) // {type byte = 'f' | 'i' | 's' | 'b' | 'n'}
+	w.prevKey = indexEntry.key
+	w.nEntries++
+
+	switch indexEntry.valueType {
+	case FloatType:
+		appendFloatRefs(buff, indexEntry.values)
+	case IntType:
+		appendIntegerRefs(buff, indexEntry.values)
+	case StrType:
+		appendStringRefs(buff, indexEntry.values)
+	case BoolType:
+		appendBoolRefs(buff, indexEntry.values)
+	case NullType:
+		appendNullRefs(buff, indexEntry.values)
+	default:
+		message := fmt.Sprintf("Unknown type %c\n", indexEntry.valueType)
+		panic(message)
+	}
+}
 
-		switch indexEntry.valueType {
-		case FloatType:
-			appendFloatRefs(buff, indexEntry.values)
-		case StrType:
-			appendStringRefs(buff, indexEntry.values)
-		case NullType:
-			appendNullRefs(buff, indexEntry.values)
-		default:
-			message := fmt.Sprintf("Unknown type %c\n", indexEntry.valueType)
-			panic(message)
+// readVarintRefs decodes file indexes stored as varintRefs at pos and passes each of them to yield.
+// It returns position after them or -1 when they are malformed.
+func readVarintRefs(bytes []byte, pos int, yield func(Ref)) int {
+	if pos < 0 || pos > len(bytes) {
+		return -1
+	}
+	nRefs, size := binary.Uvarint(bytes[pos:])
+	// every file index takes at least 1 byte
+	if size <= 0 || nRefs > uint64(len(bytes)-pos-size) {
+		return -1
+	}
+	pos += size
+
+	ref := uint64(0)
+	for i := uint64(0); i < nRefs; i++ {
+		delta, size := binary.Uvarint(bytes[pos:])
+		if size <= 0 || (i > 0 && delta == 0) {
+			return -1
+		}
+		ref += delta
+		if ref > math.MaxUint32 {
+			return -1
 		}
+		yield(Ref(ref))
+		pos += size
 	}
-	return buff.Bytes()
+	return pos
 }
 
-func deserializeIndex(bytes []byte) IndexT {
+func deserializeIndex(bytes []byte, encoding indexEncoding) (IndexT, error) {
 	stringSep := byte(NUL)
+	// smallest {n file indexes}
+	minRefsSize := 4
+	if encoding&varintRefs != 0 {
+		minRefsSize = 1
+	}
+
+	// first problem found while reading, every reader returns len(bytes) after it to stop further reading
+	var err error
+	corrupt := func(pos int, what string) int {
+		if err == nil {
+			err = fmt.Errorf("%w: %s at byte %d", ErrCorruptIndex, what, pos)
+		}
+		return len(bytes)
+	}
+
+	readStr := func(bytes []byte, pos int, prev string) (string, int) {
+		if encoding&frontCodedStrings != 0 {
+			shared, suffix, endPos := readFrontCoded(bytes, pos)
+			if endPos < 0 {
+				return "", corrupt(pos, "malformed string")
+			}
+			if shared > len(prev) {
+				return "", corrupt(pos, "string prefix longer than previous string")
+			}
+			return prev[:shared] + string(suffix), endPos
+		}
 
-	readStr := func(bytes []byte, pos int) (string, int) {
 		endPos := pos
 		for ; endPos < len(bytes); endPos++ {
 			if bytes[endPos] == stringSep {
 				break
 			}
 		}
+		if endPos >= len(bytes) {
+			return "", corrupt(pos, "unterminated string")
+		}
 
 		s := string(bytes[pos:endPos])
 		return s, endPos + 1
 	}
 
 	readType := func(bytes []byte, pos int) (IndexEntryType, int) {
+		if pos >= len(bytes) {
+			return 0, corrupt(pos, "missing entry type")
+		}
 		entryType := IndexEntryType(bytes[pos])
 		return entryType, pos + 1
 	}
 
 	readFloat := func(bytes []byte, pos int) (float64, int) {
 		endPos := pos + 8
+		if endPos > len(bytes) {
+			return 0, corrupt(pos, "truncated float")
+		}
 		bits := binary.BigEndian.Uint64(bytes[pos:endPos])
 		float := math.Float64frombits(bits)
 		return float, endPos
 	}
 
+	readInteger := func(bytes []byte, pos int) (int64, int) {
+		endPos := pos + 8
+		if endPos > len(bytes) {
+			return 0, corrupt(pos, "truncated integer")
+		}
+		return int64(binary.BigEndian.Uint64(bytes[pos:endPos])), endPos
+	}
+
+	readBool := func(bytes []byte, pos int) (bool, int) {
+		if pos >= len(bytes) {
+			return false, corrupt(pos, "truncated bool")
+		}
+		return bytes[pos] != 0, pos + 1
+	}
+
 	readInt := func(bytes []byte, pos int) (size_t, int) {
 		endPos := pos + 4
+		if endPos > len(bytes) {
+			return 0, corrupt(pos, "truncated int")
+		}
 		intVal := binary.BigEndian.Uint32(bytes[pos:endPos])
 		return size_t(intVal), endPos
 	}
 
-	readFileRefs := func(bytes []byte, pos int) ([]size_t, int) {
+	readFileRefs := func(bytes []byte, pos int) ([]Ref, int) {
+		if encoding&varintRefs != 0 {
+			refs := make([]Ref, 0, 4)
+			endPos := readVarintRefs(bytes, pos, func(ref Ref) { refs = append(refs, ref) })
+			if endPos < 0 {
+				return nil, corrupt(pos, "malformed file indexes")
+			}
+			return refs, endPos
+		}
+
 		nIntRefs, newPos := readInt(bytes, pos)
 		pos = newPos
-		refs := make([]size_t, 0, nIntRefs)
+		if int(nIntRefs) > (len(bytes)-pos)/4 {
+			return nil, corrupt(pos, "too many file indexes")
+		}
+		refs := make([]Ref, 0, nIntRefs)
 		for i := 0; i < int(nIntRefs); i++ {
 			intRef, newPos := readInt(bytes, pos)
 			pos = newPos
-			refs = append(refs, intRef)
+			refs = append(refs, Ref(intRef))
 		}
 
 		return refs, pos
 	}
 
 	readFloatValueRefs := func(bytes []byte, pos int, nValues size_t) ([]ValueRefs, int) {
+		// every value takes at least 1 byte value and n of file indexes
+		if int(nValues) > (len(bytes)-pos)/(1+minRefsSize) {
+			return nil, corrupt(pos, "too many values")
+		}
 		values := make([]ValueRefs, 0, nValues)
 
-		for i := size_t(0); i < nValues; i++ {
+		for i := size_t(0); i < nValues && err == nil; i++ {
 			floatVal, newPos := readFloat(bytes, pos)
 			pos = newPos
 			refs, newPos := readFileRefs(bytes, pos)
@@ -351,12 +752,40 @@ func deserializeIndex(bytes []byte) IndexT {
 		return values, pos
 	}
 
+	readIntegerValueRefs := func(bytes []byte, pos int, nValues size_t) ([]ValueRefs, int) {
+		// every value takes at least 1 byte value and n of file indexes
+		if int(nValues) > (len(bytes)-pos)/(1+minRefsSize) {
+			return nil, corrupt(pos, "too many values")
+		}
+		values := make([]ValueRefs, 0, nValues)
+
+		for i := size_t(0); i < nValues && err == nil; i++ {
+			intVal, newPos := readInteger(bytes, pos)
+			pos = newPos
+			refs, newPos := readFileRefs(bytes, pos)
+			pos = newPos
+			valueRefs := ValueRefs{intVal, refs}
+			values = append(values, valueRefs)
+		}
+
+		return values, pos
+	}
+
 	readStrValueRefs := func(bytes []byte, pos int, nValues size_t) ([]ValueRefs, int) {
+		// every value takes at least 1 byte value and n of file indexes
+		if int(nValues) > (len(bytes)-pos)/(1+minRefsSize) {
+			return nil, corrupt(pos, "too many values")
+		}
 		values := make([]ValueRefs, 0, nValues)
 
-		for i := size_t(0); i < nValues; i++ {
-			str, newPos := readStr(bytes, pos)
+		prev := ""
+		for i := size_t(0); i < nValues && err == nil; i++ {
+			if i%frontCodingInterval == 0 {
+				prev = ""
+			}
+			str, newPos := readStr(bytes, pos, prev)
 			pos = newPos
+			prev = str
 			refs, newPos := readFileRefs(bytes, pos)
 			pos = newPos
 			valueRefs := ValueRefs{str, refs}
@@ -366,14 +795,38 @@ func deserializeIndex(bytes []byte) IndexT {
 		return values, pos
 	}
 
+	readBoolValueRefs := func(bytes []byte, pos int, nValues size_t) ([]ValueRefs, int) {
+		// every value takes at least 1 byte value and n of file indexes
+		if int(nValues) > (len(bytes)-pos)/(1+minRefsSize) {
+			return nil, corrupt(pos, "too many values")
+		}
+		values := make([]ValueRefs, 0, nValues)
+
+		for i := size_t(0); i < nValues && err == nil; i++ {
+			boolVal, newPos := readBool(bytes, pos)
+			pos = newPos
+			refs, newPos := readFileRefs(bytes, pos)
+			pos = newPos
+			valueRefs := ValueRefs{boolVal, refs}
+			values = append(values, valueRefs)
+		}
+
+		return values, pos
+	}
+
 	readNullValueRefs := func(bytes []byte, pos int) ([]ValueRefs, int) {
 		refs, pos := readFileRefs(bytes, pos)
 		return []ValueRefs{{nil, refs}}, pos
 	}
 
 	index := make(IndexT, 0, 32)
-	for initPos := 0; initPos < len(bytes); {
-		key, pos := readStr(bytes, initPos)
+	prevKey := ""
+	for initPos := 0; initPos < len(bytes) && err == nil; {
+		if len(index)%frontCodingInterval == 0 {
+			prevKey = ""
+		}
+		key, pos := readStr(bytes, initPos, prevKey)
+		prevKey = key
 		entryType, pos := readType(bytes, pos)
 
 		switch entryType {
@@ -383,87 +836,202 @@ func deserializeIndex(bytes []byte) IndexT {
 			pos = newPos
 			entry := IndexEntry{key, entryType, values}
 			index = append(index, entry)
+		case IntType:
+			nValues, newPos := readInt(bytes, pos)
+			values, newPos := readIntegerValueRefs(bytes, newPos, nValues)
+			pos = newPos
+			entry := IndexEntry{key, entryType, values}
+			index = append(index, entry)
 		case StrType:
 			nValues, newPos := readInt(bytes, pos)
 			values, newPos := readStrValueRefs(bytes, newPos, nValues)
 			pos = newPos
 			entry := IndexEntry{key, entryType, values}
 			index = append(index, entry)
+		case BoolType:
+			nValues, newPos := readInt(bytes, pos)
+			values, newPos := readBoolValueRefs(bytes, newPos, nValues)
+			pos = newPos
+			entry := IndexEntry{key, entryType, values}
+			index = append(index, entry)
 		case NullType:
 			values, newPos := readNullValueRefs(bytes, pos)
 			pos = newPos
 			entry := IndexEntry{key, entryType, values}
 			index = append(index, entry)
+		default:
+			pos = corrupt(pos-1, fmt.Sprintf("unknown entry type %c", entryType))
 		}
 
 		initPos = pos
 	}
 
-	return index
+	if err != nil {
+		return nil, err
+	}
+	return index, nil
 }
 
-func IndexFiles(filePaths []string) IndexT { // todo err
-	indexAggregator := make(aggregateT)
-	for fileIdx, path := range filePaths {
-		unflatten := parseJson(readFile(path))
-		flatten := flattenJson(unflatten)
-		aggregateJson(indexAggregator, flatten, size_t(fileIdx))
+// IndexFiles indexes filePaths under refs of their positions, reading them concurrently.
+func IndexFiles(filePaths []string) (IndexT, error) {
+	return IndexFilesWith(filePaths, IndexOptions{})
+}
+
+// SaveIndex writes index to INDEX file of dirPath, keeping collation of the INDEX file it replaces.
+func SaveIndex(index IndexT, dirPath string) error {
+	collation, err := ReadCollation(dirPath)
+	if err != nil {
+		return err
 	}
+	return SaveIndexWith(index, dirPath, collation)
+}
 
-	index := indexAgregate(indexAggregator)
-	return index
+// SaveIndexWith writes index to INDEX file of dirPath, marking its string values as collated with collation.
+func SaveIndexWith(index IndexT, dirPath string, collation Collation) error {
+	indexPath, err := writeTempFile(dirPath, ".INDEX-", encodeIndexFile(index, collation))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(indexPath)
+	return replaceIndexFile(indexPath, dirPath)
 }
 
-func SaveIndex(index IndexT, dirPath string) error {
-	indexBytes := serializeIndex(index)
-	err := os.WriteFile(dirPath+"/INDEX", indexBytes, 0644)
+// writeTempFile writes data to a new hidden file of dirPath named by pattern, ready to be renamed
+// over a database file, and returns its path. The caller removes it when it is not renamed.
+func writeTempFile(dirPath, pattern string, data []byte) (string, error) {
+	f, err := os.CreateTemp(dirPath, pattern)
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0644)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// replaceIndexFile renames fully written tmpPath over INDEX file of dirPath, so that the INDEX file
+// is never seen half written, and removes the journal whose changes the new snapshot already contains.
+func replaceIndexFile(tmpPath, dirPath string) error {
+	if err := os.Chmod(tmpPath, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, dirPath+"/INDEX"); err != nil {
+		return err
+	}
+	err := os.Remove(deltaPath(dirPath))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
 	return err
 }
 
-func ReadIndex(dirPath string) IndexT { // TODO err
-	indexBytes := readFile(dirPath + "/INDEX")
-	index := deserializeIndex(indexBytes)
-	return index
+func ReadIndex(dirPath string) (IndexT, error) {
+	indexBytes, err := readFile(dirPath + "/INDEX")
+	if err != nil {
+		return nil, err
+	}
+	index, err := decodeIndexFile(indexBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	deltaBytes, err := os.ReadFile(deltaPath(dirPath))
+	if errors.Is(err, os.ErrNotExist) {
+		return index, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := replayIndexDelta(&index, deltaBytes); err != nil {
+		return nil, err
+	}
+	return index, nil
 }
 
 type nullQuery struct {
 	Key string
 }
 
-func queryForNullRefs(index *IndexT, query *nullQuery) []size_t {
-	indexEntryCmp := func(entry IndexEntry, key string) int {
-		return valueWithTypeCmp(entry.key, key, entry.valueType, NullType)
+// findEntry returns position of the first entry not lower than key of valueType
+// and whether it holds exactly that key and type.
+func findEntry(index IndexReader, key string, valueType IndexEntryType) (int, bool) {
+	n := index.NumEntries()
+	entryIdx := sort.Search(n, func(i int) bool {
+		entryKey, entryType := index.EntryKey(i)
+		return valueWithTypeCmp(entryKey, key, entryType, valueType) >= 0
+	})
+	if entryIdx < n {
+		entryKey, entryType := index.EntryKey(entryIdx)
+		return entryIdx, entryKey == key && entryType == valueType
 	}
+	return entryIdx, false
+}
 
-	if entryIdx, found := slices.BinarySearchFunc(*index, query.Key, indexEntryCmp); found {
-		entry := (*index)[entryIdx]
-		return entry.values[0].refs
+func queryForNullRefs(index IndexReader, query *nullQuery) []Ref {
+	if entryIdx, found := findEntry(index, query.Key, NullType); found {
+		return index.EntryRefs(entryIdx, 0)
 	}
 	return nil
 }
 
-func getFileRefs(index *IndexT, queryKey string, op parser.OpType, queryVal interface{}, queryType IndexEntryType) fileRefs {
-	// TODO propagate fileRefs = bitflags.BitFlags Type for file indexes throughout the project
-	refsArrTofileRefs := func(refs []size_t) fileRefs {
+// unionRefs returns refs of values in [begin, end) range of the entry.
+func unionRefs(index IndexReader, entryIdx, begin, end int) fileRefs {
+	return valuesRefs(index, entryIdx)(begin, end)
+}
+
+// entryRefsReader and entryValuesReader are implemented by MappedIndex to decode an entry once for many of its values.
+type entryRefsReader interface {
+	entryFileRefs(i int) func(begin, end int) fileRefs
+}
+
+type entryValuesReader interface {
+	entryValues(i int) func(j int) interface{}
+}
+
+// valuesRefs returns reader of union of refs of values in [begin, end) of entryIdx entry of index,
+// MappedIndex decodes the entry only once for all of them and its file indexes into bitmap without intermediate slice.
+func valuesRefs(index IndexReader, entryIdx int) func(begin, end int) fileRefs {
+	if bitmapReader, isBitmapReader := index.(entryRefsReader); isBitmapReader {
+		return bitmapReader.entryFileRefs(entryIdx)
+	}
+	return func(begin, end int) fileRefs {
 		fr := fileRefs{}
-		for _, ref := range refs {
-			fr.Set(uint(ref))
+		for valueIdx := begin; valueIdx < end; valueIdx++ {
+			fr = fr.Union(sliceToRefs(index.EntryRefs(entryIdx, valueIdx)))
 		}
 		return fr
 	}
+}
 
-	indexEntryCmp := func(entry IndexEntry, key string) int {
-		return valueWithTypeCmp(entry.key, key, entry.valueType, queryType)
+// entryValues returns reader of values of entryIdx entry of index,
+// MappedIndex decodes the entry only once for all of them.
+func entryValues(index IndexReader, entryIdx int) func(valueIdx int) interface{} {
+	if valuesReader, isValuesReader := index.(entryValuesReader); isValuesReader {
+		return valuesReader.entryValues(entryIdx)
 	}
+	return func(valueIdx int) interface{} { return index.EntryValue(entryIdx, valueIdx) }
+}
 
-	valueRefCmp := func(valueRef ValueRefs, val interface{}) int {
+func getFileRefs(index IndexReader, queryKey string, op parser.OpType, queryVal interface{}, queryType IndexEntryType) fileRefs {
+	// TODO propagate fileRefs = bitflags.BitFlags Type for file indexes throughout the project
+	valueRefCmp := func(value interface{}, val interface{}) int {
 		switch v := val.(type) {
 		case string:
-			return cmp.Compare(valueRef.value.(string), v)
+			return cmp.Compare(value.(string), v)
 		case int:
-			return cmp.Compare(valueRef.value.(float64), float64(v))
-		case float64:
-			return cmp.Compare(valueRef.value.(float64), v)
+			return compareNumbers(value, int64(v))
+		case int64, float64:
+			return compareNumbers(value, v)
+		case bool:
+			return boolCmp(value.(bool), v)
 		case nil:
 			// TODO
 			return 0
@@ -472,13 +1040,92 @@ func getFileRefs(index *IndexT, queryKey string, op parser.OpType, queryVal inte
 		}
 	}
 
-	if entryIdx, found := slices.BinarySearchFunc(*index, queryKey, indexEntryCmp); found {
-		entry := (*index)[entryIdx]
-		if refIdx, found := slices.BinarySearchFunc(entry.values, queryVal, valueRefCmp); found {
-			return refsArrTofileRefs(entry.values[refIdx].refs)
+	// lowerBound returns position of the first value >= val,
+	// upperBound returns position of the first value > val.
+	lowerBound := func(entryIdx int, values func(int) interface{}, val interface{}) int {
+		return sort.Search(index.NumValues(entryIdx), func(j int) bool {
+			return valueRefCmp(values(j), val) >= 0
+		})
+	}
+	upperBound := func(entryIdx int, values func(int) interface{}, val interface{}) int {
+		return sort.Search(index.NumValues(entryIdx), func(j int) bool {
+			return valueRefCmp(values(j), val) > 0
+		})
+	}
+
+	valuesRange := func(entryIdx int, values func(int) interface{}) (begin, end int) {
+		switch op {
+		case parser.Eq, parser.Is:
+			return lowerBound(entryIdx, values, queryVal), upperBound(entryIdx, values, queryVal)
+		case parser.Gt:
+			return upperBound(entryIdx, values, queryVal), index.NumValues(entryIdx)
+		case parser.Ge:
+			return lowerBound(entryIdx, values, queryVal), index.NumValues(entryIdx)
+		case parser.Lt:
+			return 0, lowerBound(entryIdx, values, queryVal)
+		case parser.Le:
+			return 0, upperBound(entryIdx, values, queryVal)
+		case parser.Between:
+			r := queryVal.(parser.Range)
+			return lowerBound(entryIdx, values, r.From), upperBound(entryIdx, values, r.To)
+		default:
+			panic("Unknown operator")
 		}
 	}
-	return refsArrTofileRefs(nil)
+
+	// values are compared only within their type, so bounds of different types match no value
+	if r, isRange := queryVal.(parser.Range); isRange && valueKind(r.From) != valueKind(r.To) {
+		return fileRefs{}
+	}
+	if op == parser.Is && queryType == NullType {
+		return sliceToRefs(queryForNullRefs(index, &nullQuery{queryKey}))
+	}
+	if op == parser.IsNot {
+		// key is present with any non null value
+		fr := fileRefs{}
+		entryIdx, _ := findEntry(index, queryKey, 0) // 0 sorts before every type
+		for ; entryIdx < index.NumEntries(); entryIdx++ {
+			entryKey, entryType := index.EntryKey(entryIdx)
+			if entryKey != queryKey {
+				break
+			}
+			if entryType != NullType {
+				fr = fr.Union(unionRefs(index, entryIdx, 0, index.NumValues(entryIdx)))
+			}
+		}
+		return fr
+	}
+
+	entryRefs := func(entryIdx int) fileRefs {
+		if caseValue, isCaseValue := queryVal.(parser.CaseValue); isCaseValue {
+			return convertedRefs(index, entryIdx, op, caseValue)
+		}
+		switch op {
+		case parser.Like, parser.StartsWith, parser.EndsWith, parser.Contains:
+			return matchingRefs(index, entryIdx, op, queryVal.(string))
+		}
+		values := entryValues(index, entryIdx)
+		if op == parser.Ne {
+			// key holds a different value of the same type
+			begin, end := lowerBound(entryIdx, values, queryVal), upperBound(entryIdx, values, queryVal)
+			return unionRefs(index, entryIdx, 0, begin).Union(unionRefs(index, entryIdx, end, index.NumValues(entryIdx)))
+		}
+		begin, end := valuesRange(entryIdx, values)
+		return unionRefs(index, entryIdx, begin, end)
+	}
+
+	entryTypes := []IndexEntryType{queryType}
+	if queryType == IntType || queryType == FloatType {
+		// integers and floats are compared by value, so a number is looked for in entries of both types
+		entryTypes = []IndexEntryType{FloatType, IntType}
+	}
+	fr := fileRefs{}
+	for _, entryType := range entryTypes {
+		if entryIdx, found := findEntry(index, queryKey, entryType); found {
+			fr = fr.Union(entryRefs(entryIdx))
+		}
+	}
+	return fr
 }
 
 // stack based refs operations: unions and intersections
@@ -505,38 +1152,117 @@ func (s *refStack) Or(f fileRefs) {
 	l := len(s.stack) - 1
 	s.stack[l] = s.stack[l].Union(f)
 }
+func (s *refStack) AndPop() {
+	s.And(s.Pop())
+}
+func (s *refStack) OrPop() {
+	s.Or(s.Pop())
+}
+func (s *refStack) Not(live fileRefs) {
+	l := len(s.stack) - 1
+	s.stack[l] = s.stack[l].Complement(live)
+}
 
 // <<*******
 
-func QueryIndex(index *IndexT, query string) {
-	refsToSlice := func(refs fileRefs) []uint {
-		refsSlice := make([]uint, 0, 32)
-		for av := range refs.Traverse() {
-			refsSlice = append(refsSlice, av)
-		}
-		return refsSlice
+func refsToSlice(refs fileRefs) []Ref {
+	refsSlice := make([]Ref, 0, 32)
+	for av := range refs.Traverse() {
+		refsSlice = append(refsSlice, Ref(av))
 	}
+	return refsSlice
+}
+
+func sliceToRefs(refs []Ref) fileRefs {
+	fr := fileRefs{}
+	for _, ref := range refs {
+		fr.Set(uint(ref))
+	}
+	return fr
+}
 
-	getQueryType := func(obj any) IndexEntryType {
+// indexRefs returns refs of every document with at least one indexed value.
+func indexRefs(index IndexReader) fileRefs {
+	fr := fileRefs{}
+	for entryIdx := 0; entryIdx < index.NumEntries(); entryIdx++ {
+		fr = fr.Union(unionRefs(index, entryIdx, 0, index.NumValues(entryIdx)))
+	}
+	return fr
+}
+
+// evalProgram runs instructions of the program against index, whose string values are collated with collation,
+// and MATCH conditions against text, which may be nil when the program has none.
+// liveRefs returns refs of all documents, it is called only when the program negates a condition
+// or has no conditions at all.
+func evalProgram(index conditionIndex, program parser.Program, collation Collation, text *TextIndex, liveRefs func() (fileRefs, error)) (fileRefs, error) {
+	getQueryType := func(obj any) (IndexEntryType, error) {
+		if cv, ok := obj.(parser.CaseValue); ok {
+			obj = cv.Val
+		}
+		if r, ok := obj.(parser.Range); ok {
+			obj = r.From
+		}
 		switch obj.(type) {
 		case string:
-			return StrType
+			return StrType, nil
 		case float64:
-			return FloatType
+			return FloatType, nil
+		case int64:
+			return IntType, nil
+		case bool:
+			return BoolType, nil
 		case nil:
-			return NullType
+			return NullType, nil
 		default:
-			panic("Got Unknown query type.")
+			return 0, fmt.Errorf("Unknown query value type %T", obj)
 		}
 	}
 
-	program, err := parser.Parse(query)
-	check(err)
+	if len(program.Instructions) == 0 {
+		return liveRefs()
+	}
 
+	var live *fileRefs
 	stack := refStack{}
 	for _, instruction := range program.Instructions {
-		queryType := getQueryType(instruction.Val) // TODO add type info directly from parser
-		refs := getFileRefs(index, instruction.Key, instruction.Op, instruction.Val, queryType)
+		switch instruction.Kind {
+		case parser.Not:
+			if live == nil {
+				refs, err := liveRefs()
+				if err != nil {
+					return fileRefs{}, err
+				}
+				live = &refs
+			}
+			stack.Not(*live)
+			continue
+		case parser.AndPop:
+			stack.AndPop()
+			continue
+		case parser.OrPop:
+			stack.OrPop()
+			continue
+		}
+
+		queryType, err := getQueryType(instruction.Val) // TODO add type info directly from parser
+		if err != nil {
+			return fileRefs{}, err
+		}
+		var refs fileRefs
+		if instruction.Op == parser.Match {
+			refs, err = text.match(instruction.Key, instruction.Val.(string))
+			if err != nil {
+				return fileRefs{}, err
+			}
+		} else {
+			queryVal := collation.collateValue(instruction.Val)
+			if cv, ok := queryVal.(parser.CaseValue); ok && cv.Func == parser.Lower && collation&FoldCase != 0 && isLowerValue(cv.Val) {
+				// values are case folded already, so lower case values are folded and binary searched,
+				// others are left to the scan of lower cased values, which they never equal
+				queryVal = collation.collateValue(cv.Val)
+			}
+			refs = index.conditionRefs(instruction.Key, instruction.Op, queryVal, queryType)
+		}
 
 		switch instruction.Kind {
 		case parser.Push:
@@ -546,81 +1272,44 @@ func QueryIndex(index *IndexT, query string) {
 		case parser.Or:
 			stack.Or(refs)
 		default:
-			panic("Unknown instruction type")
+			return fileRefs{}, fmt.Errorf("Unknown instruction type %c", instruction.Kind)
 		}
 	}
 
-	fmt.Printf("Refs:\n%v\n", refsToSlice(stack.Pop()))
+	return stack.Pop(), nil
 }
 
-// https://github.com/x-motemen/gore/blob/main/cli/run.go
-func cmdOpen(cmd string) (filename string, path string, index IndexT, err error) {
-	// strings.Split(cmd, )
-	fields := strings.Fields(cmd)
-	if len(fields) != 2 {
-		// Custom errors -> https://yourbasic.org/golang/create-error/
-		return "", "", nil, errors.New("Wrong number of parameters to .open") // TODO custom error end usage of .open cmd
-	}
-	path = fields[1]
-	filename = filepath.Base(path)
-	index = ReadIndex(filepath.Dir(path))
-	err = nil
-	return
+// conditionIndex returns refs of documents holding a value of key which satisfies op, see getFileRefs.
+type conditionIndex interface {
+	conditionRefs(key string, op parser.OpType, val interface{}, valueType IndexEntryType) fileRefs
 }
 
-func RunCli() int {
-	/* Commands: .help .exit .open .database */
-	reader := bufio.NewReader(os.Stdin)
-	version := "0.01"
-	fmt.Printf("NoSQLite version: %s\n", version)
-	fmt.Println("Enter \".help\" for usage hints.")
-
-	// var currIndex IndexT
-	var currName string
-	var currPath string
-
-	for {
-		fmt.Print("nosqlite> ")
-		text, _ := reader.ReadString('\n')
-		// convert CRLF to LF
-		text = strings.Replace(text, "\n", "", -1)
+// readerIndex runs conditions of QueryIndex against any IndexReader.
+type readerIndex struct{ IndexReader }
 
-		if text == ".exit" {
-			return 0
-		}
-		if text == ".database" {
-			fmt.Printf("seq  name             file\n")
-			fmt.Printf("---  ---------------  --------------------------\n")
-			fmt.Printf("%-3d  %-15s  %-26s\n", 0, currName, currPath)
-			continue
-		}
-		if strings.HasPrefix(text, ".open") { //.open /workspaces/nosqlite/nosqlite/db/INDEX
-			name, path, _, err := cmdOpen(text)
-			if err != nil {
-				fmt.Printf("%s\n", err)
-			}
-			// currIndex = index
-			currName = name
-			currPath = path
-			continue
-		}
-		fmt.Printf("Unknown \"%s\"\n", text)
-	}
+func (r readerIndex) conditionRefs(key string, op parser.OpType, val interface{}, valueType IndexEntryType) fileRefs {
+	return getFileRefs(r.IndexReader, key, op, val, valueType)
 }
 
-func main() {
-	// paths := []string{"./db/0", "./db/1"} // todo use listDir(dirPath)
-	// index := IndexFiles(paths)
-
-	// err := SaveIndex(index, "./db")
-	// check(err)
-
-	os.Exit(RunCli())
-
-	// index := ReadIndex("./db")
-	// QueryIndex(&index, "")
+// QueryIndex returns file refs of documents matching the query.
+// NOT complements to documents having at least one indexed value.
+// String values of the query are collated when index tells its collation, like MappedIndex does.
+// MATCH conditions need full-text index of a database directory, so they fail with ErrNoTextIndex.
+func QueryIndex(index IndexReader, query string) ([]Ref, error) {
+	program, err := parser.Parse(query)
+	if err != nil {
+		return nil, err
+	}
 
-	// s := "SELECT *"
-	// fmt.Println(s[0:6])
-	// fmt.Println(s[0:math.Min(6, 10)])
+	collation := BinaryCollation
+	if collated, isCollated := index.(interface{ Collation() Collation }); isCollated {
+		collation = collated.Collation()
+	}
+	refs, err := evalProgram(readerIndex{index}, program, collation, nil, func() (fileRefs, error) {
+		return indexRefs(index), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return refsToSlice(refs), nil
 }
@@ -1,6 +1,10 @@
-package main
+package nosqlite
 
 import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
 	"testing"
 
 	"github.com/jacnik/nosqlite/parser"
@@ -12,10 +16,18 @@ func compareValueRefs(expected, actual ValueRefs, valueType IndexEntryType) bool
 		if expected.value.(float64) != actual.value.(float64) {
 			return false
 		}
+	case IntType:
+		if expected.value.(int64) != actual.value.(int64) {
+			return false
+		}
 	case StrType:
 		if expected.value.(string) != actual.value.(string) {
 			return false
 		}
+	case BoolType:
+		if expected.value.(bool) != actual.value.(bool) {
+			return false
+		}
 	case NullType:
 		if expected.value != nil || actual.value != nil {
 			return false
@@ -65,34 +77,144 @@ func compareIndexes(expected, actual IndexT) bool {
 	return true
 }
 
+func check(err error) {
+	if err != nil {
+		panic(err)
+	}
+}
+
+func readTestIndex(t *testing.T, dirPath string) IndexT {
+	t.Helper()
+	index, err := ReadIndex(dirPath)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	return index
+}
+
+func indexTestFiles(t *testing.T, paths []string) IndexT {
+	t.Helper()
+	index, err := IndexFiles(paths)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	return index
+}
+
+func readTestFile(t *testing.T, path string) []byte {
+	t.Helper()
+	bytes, err := readFile(path)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	return bytes
+}
+
 // Check if index can be serialized and deseralized back.
 func TestSerializeAndDeserializeIndex(t *testing.T) {
 	paths := []string{"./db/0", "./db/1"}
-	index := IndexFiles(paths)
+	index := indexTestFiles(t, paths)
+
+	for _, encoding := range []indexEncoding{plainEncoding, varintRefs, frontCodedStrings, currentEncoding} {
+		indexBytes := serializeIndex(index, encoding)
+
+		deserializedIndex, err := deserializeIndex(indexBytes, encoding)
+		if err != nil {
+			t.Fatalf("Got unexpected error: %v", err)
+		}
 
-	indexBytes := serializeIndex(index)
+		if !compareIndexes(index, deserializedIndex) {
+			t.Fatalf("Deserialized index different than original:\n%v\n%v", index, deserializedIndex)
+		}
+	}
+}
+
+// Check if varint file indexes round trip and take less space than fixed ones.
+func TestVarintRefs(t *testing.T) {
+	refs := []Ref{0, 1, 2, 127, 128, 300, 16384, 1<<32 - 1}
+	index := IndexT{{key: "/a", valueType: FloatType, values: []ValueRefs{{value: 1.0, refs: refs}}}}
+	deserializedIndex, err := deserializeIndex(serializeIndex(index, varintRefs), varintRefs)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if !compareIndexes(index, deserializedIndex) {
+		t.Fatalf("Deserialized index different than original:\n%v\n%v", index, deserializedIndex)
+	}
 
-	deserializedIndex := deserializeIndex(indexBytes)
+	dense := make([]Ref, 10000)
+	for i := range dense {
+		dense[i] = Ref(3 * i)
+	}
+	index = IndexT{{key: "/a", valueType: BoolType, values: []ValueRefs{{value: true, refs: dense}}}}
+	fixedSize, varintSize := len(serializeIndex(index, plainEncoding)), len(serializeIndex(index, varintRefs))
+	if varintSize*3 > fixedSize {
+		t.Fatalf("Expected varint index to be at least 3 times smaller than %d bytes, got %d", fixedSize, varintSize)
+	}
 
+	assert := func(name string, refsBytes []byte) {
+		indexBytes := append([]byte("/a\x00b\x00\x00\x00\x00\x01\x01"), refsBytes...)
+		if _, err := deserializeIndex(indexBytes, varintRefs); !errors.Is(err, ErrCorruptIndex) {
+			t.Fatalf("Expected ErrCorruptIndex for %s, got %v", name, err)
+		}
+	}
+	assert("missing refs", []byte{2, 1})
+	assert("repeated ref", []byte{2, 1, 0})
+	assert("unterminated varint", []byte{1, 0x80})
+	assert("ref out of range", []byte{1, 0xff, 0xff, 0xff, 0xff, 0x7f})
+}
+
+// Check if front coded keys and strings round trip, including NUL bytes, and take less space than NUL terminated ones.
+func TestFrontCodedStrings(t *testing.T) {
+	index := IndexT{}
+	for i := 0; i < 40; i++ {
+		values := []ValueRefs{}
+		for j := 0; j < 40; j++ {
+			values = append(values, ValueRefs{fmt.Sprintf("https://example.com/profile/%02d/\x00żółw/%02d", i, j), []Ref{Ref(j)}})
+		}
+		index = append(index, IndexEntry{fmt.Sprintf("/social/network%02d", i), StrType, values})
+	}
+	index = append(index, IndexEntry{"/social/network\x00", NullType, []ValueRefs{{nil, []Ref{1}}}})
+
+	deserializedIndex, err := deserializeIndex(serializeIndex(index, frontCodedStrings), frontCodedStrings)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
 	if !compareIndexes(index, deserializedIndex) {
 		t.Fatalf("Deserialized index different than original:\n%v\n%v", index, deserializedIndex)
 	}
+
+	index = index[:len(index)-1]
+	plainSize, frontCodedSize := len(serializeIndex(index, plainEncoding)), len(serializeIndex(index, frontCodedStrings))
+	if frontCodedSize*2 > plainSize {
+		t.Fatalf("Expected front coded index to be at least 2 times smaller than %d bytes, got %d", plainSize, frontCodedSize)
+	}
+
+	assert := func(name string, indexBytes []byte) {
+		if _, err := deserializeIndex(indexBytes, frontCodedStrings); !errors.Is(err, ErrCorruptIndex) {
+			t.Fatalf("Expected ErrCorruptIndex for %s, got %v", name, err)
+		}
+	}
+	assert("truncated key", []byte("\x00\x05/a"))
+	assert("prefix of the first key", []byte("\x01\x01an\x00\x00\x00\x00"))
+	assert("truncated value", []byte("\x00\x02/as\x00\x00\x00\x01\x00\x03ab"))
 }
 
 // Check if it can create correct index from files.
 func TestIndexFiles(t *testing.T) {
 	paths := []string{"./db/0", "./db/1"}
-	index := IndexFiles(paths)
+	index := indexTestFiles(t, paths)
 
 	expected := IndexT{
-		IndexEntry{"/age", FloatType, []ValueRefs{{value: 17.0, refs: []size_t{1}}, {value: 23.0, refs: []size_t{0}}}},
-		IndexEntry{"/arr/0", FloatType, []ValueRefs{{value: 2.0, refs: []size_t{0}}}},
-		IndexEntry{"/arr/1", FloatType, []ValueRefs{{value: 3.0, refs: []size_t{0}}}},
-		IndexEntry{"/name", StrType, []ValueRefs{{value: "Elliot", refs: []size_t{0}}, {value: "Fraser", refs: []size_t{1}}}},
-		IndexEntry{"/now null behaves", NullType, []ValueRefs{{value: nil, refs: []size_t{0}}}},
-		IndexEntry{"/social/facebook", StrType, []ValueRefs{{value: "https://facebook.com", refs: []size_t{0, 1}}}},
-		IndexEntry{"/social/twitter", StrType, []ValueRefs{{value: "https://twitter.com", refs: []size_t{0, 1}}}},
-		IndexEntry{"/type", StrType, []ValueRefs{{value: "Author", refs: []size_t{1}}, {value: "Reader", refs: []size_t{0}}}}}
+		IndexEntry{"/active", BoolType, []ValueRefs{{value: false, refs: []Ref{1}}, {value: true, refs: []Ref{0}}}},
+		IndexEntry{"/age", IntType, []ValueRefs{{value: int64(17), refs: []Ref{1}}, {value: int64(23), refs: []Ref{0}}}},
+		IndexEntry{"/arr/*", IntType, []ValueRefs{{value: int64(2), refs: []Ref{0}}, {value: int64(3), refs: []Ref{0}}}},
+		IndexEntry{"/arr/0", IntType, []ValueRefs{{value: int64(2), refs: []Ref{0}}}},
+		IndexEntry{"/arr/1", IntType, []ValueRefs{{value: int64(3), refs: []Ref{0}}}},
+		IndexEntry{"/name", StrType, []ValueRefs{{value: "Elliot", refs: []Ref{0}}, {value: "Fraser", refs: []Ref{1}}}},
+		IndexEntry{"/now null behaves", NullType, []ValueRefs{{value: nil, refs: []Ref{0}}}},
+		IndexEntry{"/social/facebook", StrType, []ValueRefs{{value: "https://facebook.com", refs: []Ref{0, 1}}}},
+		IndexEntry{"/social/twitter", StrType, []ValueRefs{{value: "https://twitter.com", refs: []Ref{0, 1}}}},
+		IndexEntry{"/type", StrType, []ValueRefs{{value: "Author", refs: []Ref{1}}, {value: "Reader", refs: []Ref{0}}}}}
 
 	if !compareIndexes(index, expected) {
 		t.Fatalf("Expected index different than actual:\n%v\n%v", index, expected)
@@ -126,7 +248,7 @@ func compareRefs(a, b fileRefs) bool {
 
 // Check if it can return file idx list for simple string query.
 func TestQueryIndexForString(t *testing.T) {
-	index := ReadIndex("./db")
+	index := readTestIndex(t, "./db")
 	refs := getFileRefs(&index, "/social/twitter", parser.Eq, "https://twitter.com", StrType)
 
 	expected := fileRefs{}
@@ -139,7 +261,7 @@ func TestQueryIndexForString(t *testing.T) {
 
 // Check if it can return file idx list for simple float query.
 func TestQueryIndexForFloat(t *testing.T) {
-	index := ReadIndex("./db")
+	index := readTestIndex(t, "./db")
 	refs := getFileRefs(&index, "/age", parser.Eq, 23, FloatType)
 
 	expected := fileRefs{}
@@ -152,10 +274,10 @@ func TestQueryIndexForFloat(t *testing.T) {
 
 // Check if it can return file idx list for simple null query.
 func TestQueryIndexForNull(t *testing.T) {
-	index := ReadIndex("./db")
+	index := readTestIndex(t, "./db")
 	refs := queryForNullRefs(&index, &nullQuery{"/now null behaves"})
 
-	expected := []size_t{0}
+	expected := []Ref{0}
 
 	if !compareSlices(refs, expected) {
 		t.Fatalf("Expected refs different than actual:\n%v\n%v", expected, refs)
@@ -164,12 +286,370 @@ func TestQueryIndexForNull(t *testing.T) {
 
 // Check if it can return empty list when querying for non existing element.
 func TestQueryIndexForNonExisting(t *testing.T) {
-	index := ReadIndex("./db")
+	index := readTestIndex(t, "./db")
 	refs := queryForNullRefs(&index, &nullQuery{"/not found"})
 
-	expected := []size_t{}
+	expected := []Ref{}
 
 	if !compareSlices(refs, expected) {
 		t.Fatalf("Expected refs different than actual:\n%v\n%v", expected, refs)
 	}
 }
+
+// Check if query without WHERE clause returns all documents of the index.
+func TestQueryIndexWithoutWhere(t *testing.T) {
+	index := readTestIndex(t, "./db")
+	refs, err := QueryIndex(index, "SELECT * FROM c")
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+
+	expected := []Ref{0, 1}
+
+	if !compareSlices(refs, expected) {
+		t.Fatalf("Expected refs different than actual:\n%v\n%v", expected, refs)
+	}
+}
+
+// Check if it can return file idx list for range float queries.
+func TestQueryIndexForFloatRange(t *testing.T) {
+	index := readTestIndex(t, "./db")
+	assert := func(op parser.OpType, val interface{}, expectedRefs ...uint) {
+		refs := getFileRefs(&index, "/age", op, val, FloatType)
+
+		expected := fileRefs{}
+		expected.Set(expectedRefs...)
+
+		if !compareRefs(refs, expected) {
+			t.Fatalf("Expected refs for %c %v different than actual:\n%v\n%v", op, val, expected, refs)
+		}
+	}
+
+	assert(parser.Gt, 17.0, 0)
+	assert(parser.Gt, 16.0, 0, 1)
+	assert(parser.Ge, 17.0, 0, 1)
+	assert(parser.Lt, 23.0, 1)
+	assert(parser.Le, 23.0, 0, 1)
+	assert(parser.Lt, 17.0)
+	assert(parser.Between, parser.Range{From: 17.0, To: 20.0}, 1)
+	assert(parser.Between, parser.Range{From: 10.0, To: 30.0}, 0, 1)
+	assert(parser.Between, parser.Range{From: int64(1), To: "z"})
+	assert(parser.Between, parser.Range{From: int64(1), To: true})
+}
+
+// Check if BETWEEN bounds of different types are rejected by parser and match nothing in the index.
+func TestQueryIndexMixedBetween(t *testing.T) {
+	index := readTestIndex(t, "./db")
+	for _, query := range []string{
+		"SELECT * FROM c WHERE c.age BETWEEN 1 AND 'z'",
+		"SELECT * FROM c WHERE c.name BETWEEN 'a' AND 100",
+		"SELECT * FROM c WHERE c.age BETWEEN 1 AND TRUE",
+		"SELECT * FROM c WHERE c.active BETWEEN TRUE AND 1",
+	} {
+		var syntaxErr *parser.SyntaxError
+		if _, err := QueryIndex(index, query); !errors.As(err, &syntaxErr) {
+			t.Fatalf("Expected syntax error for %s, got %v", query, err)
+		}
+	}
+
+	assert := func(queryType IndexEntryType, r parser.Range) {
+		if refs := getFileRefs(&index, "/name", parser.Between, r, queryType); refs.Popcount() != 0 {
+			t.Fatalf("Expected no refs for BETWEEN %v AND %v, got %v", r.From, r.To, refsToSlice(refs))
+		}
+	}
+	assert(StrType, parser.Range{From: "a", To: int64(100)})
+	assert(BoolType, parser.Range{From: true, To: int64(1)})
+}
+
+// Check if integers and floats are compared by their exact values.
+func TestCompareNumbers(t *testing.T) {
+	assert := func(a, b interface{}, expected int) {
+		if actual := compareNumbers(a, b); actual != expected {
+			t.Fatalf("Expected comparison of %v and %v to be %d, got %d", a, b, expected, actual)
+		}
+	}
+	assert(int64(3), 3.0, 0)
+	assert(3.0, int64(3), 0)
+	assert(int64(3), 2.5, 1)
+	assert(2.5, int64(3), -1)
+	assert(int64(0), -0.5, 1)
+	assert(int64(-1), -0.5, -1)
+	assert(int64(1<<53+1), float64(1<<53), 1)
+	assert(int64(math.MaxInt64), float64(math.MaxInt64), -1)
+	assert(int64(math.MinInt64), float64(math.MinInt64), 0)
+	assert(int64(math.MinInt64), -1e19, 1)
+	assert(int64(-7), int64(7), -1)
+}
+
+// Check if integers keep 64-bit precision and match floats of the same value.
+func TestQueryIndexIntegers(t *testing.T) {
+	dirPath := t.TempDir()
+	for i, doc := range []string{
+		`{"id": 9007199254740993, "n": 2}`,
+		`{"id": 9007199254740992, "n": 2.5}`,
+		`{"id": 9007199254740993.0, "n": 3}`,
+		`{"id": -9223372036854775808, "n": 2.0}`,
+		`{"id": 1e2, "n": 12345678901234567890}`,
+	} {
+		check(os.WriteFile(fmt.Sprintf("%s/%d", dirPath, i), []byte(doc), 0644))
+	}
+	db, err := Open(dirPath)
+	check(err)
+	defer db.Close()
+	mapped, err := MapIndex(dirPath)
+	check(err)
+	defer mapped.Close()
+
+	assert := func(query string, expected ...Ref) {
+		t.Helper()
+		refs, err := db.Exec(query)
+		if err != nil {
+			t.Fatalf("Got unexpected error for %s: %v", query, err)
+		}
+		if !compareSlices(refs, expected) {
+			t.Fatalf("Expected refs for %s different than actual:\n%v\n%v", query, expected, refs)
+		}
+		if refs, err = mapped.Query(query); err != nil || !compareSlices(refs, expected) {
+			t.Fatalf("Expected refs for %s from mapped index different than actual:\n%v\n%v %v", query, expected, refs, err)
+		}
+	}
+
+	assert("SELECT * FROM c WHERE c.id = 9007199254740993", 0)
+	// 9007199254740993.0 is rounded to 2^53 like any float
+	assert("SELECT * FROM c WHERE c.id = 9007199254740992", 1, 2)
+	assert("SELECT * FROM c WHERE c.id > 9007199254740992", 0)
+	assert("SELECT * FROM c WHERE c.id < 0", 3)
+	assert("SELECT * FROM c WHERE c.id BETWEEN -9223372036854775808 AND -0x1", 3)
+	assert("SELECT * FROM c WHERE c.n < 2.5e0 AND c.n >= +.2_0e1", 0, 3)
+	assert("SELECT * FROM c WHERE c.id = 100", 4)
+	assert("SELECT * FROM c WHERE c.n = 2", 0, 3)
+	assert("SELECT * FROM c WHERE c.n = 2.0", 0, 3)
+	assert("SELECT * FROM c WHERE c.n > 2", 1, 2, 4)
+	assert("SELECT * FROM c WHERE c.n BETWEEN 2 AND 2.5", 0, 1, 3)
+	assert("SELECT * FROM c WHERE c.n != 2", 1, 2, 4)
+	assert("SELECT * FROM c WHERE c.n > 9223372036854775807", 4)
+
+	entries, err := db.Entries()
+	check(err)
+	if entryIdx, found := findEntry(entries, "/id", IntType); !found || entries.NumValues(entryIdx) != 3 {
+		t.Fatalf("Expected 3 integer values of /id, got %v", entries)
+	}
+}
+
+// Check if it can return file idx list for range string queries.
+func TestQueryIndexForStringRange(t *testing.T) {
+	index := readTestIndex(t, "./db")
+	assert := func(op parser.OpType, val interface{}, expectedRefs ...uint) {
+		refs := getFileRefs(&index, "/name", op, val, StrType)
+
+		expected := fileRefs{}
+		expected.Set(expectedRefs...)
+
+		if !compareRefs(refs, expected) {
+			t.Fatalf("Expected refs for %c %v different than actual:\n%v\n%v", op, val, expected, refs)
+		}
+	}
+
+	assert(parser.Gt, "Elliot", 1)
+	assert(parser.Ge, "Elliot", 0, 1)
+	assert(parser.Lt, "F", 0)
+	assert(parser.Between, parser.Range{From: "A", To: "Ez"}, 0)
+}
+
+// Check if it can return file idx list for simple bool query.
+func TestQueryIndexForBool(t *testing.T) {
+	index := readTestIndex(t, "./db")
+	refs := getFileRefs(&index, "/active", parser.Eq, true, BoolType)
+
+	expected := fileRefs{}
+	expected.Set(0)
+
+	if !compareRefs(refs, expected) {
+		t.Fatalf("Expected refs different than actual:\n%v\n%v", expected, refs)
+	}
+}
+
+// Check if it can return file idx list for IS NULL and IS NOT NULL queries.
+func TestQueryIndexForIsNull(t *testing.T) {
+	index := readTestIndex(t, "./db")
+	assert := func(key string, op parser.OpType, expectedRefs ...uint) {
+		refs := getFileRefs(&index, key, op, nil, NullType)
+
+		expected := fileRefs{}
+		expected.Set(expectedRefs...)
+
+		if !compareRefs(refs, expected) {
+			t.Fatalf("Expected refs for %v %c different than actual:\n%v\n%v", key, op, expected, refs)
+		}
+	}
+
+	assert("/now null behaves", parser.Is, 0)
+	assert("/now null behaves", parser.IsNot)
+	assert("/active", parser.Is)
+	assert("/active", parser.IsNot, 0, 1)
+	assert("/arr/1", parser.IsNot, 0)
+}
+
+// Check if truncated or damaged INDEX is reported as corrupt instead of panicking.
+func TestDeserializeCorruptIndex(t *testing.T) {
+	index := indexTestFiles(t, []string{"./db/0", "./db/1"})
+	for _, encoding := range []indexEncoding{plainEncoding, varintRefs, frontCodedStrings, currentEncoding} {
+		indexBytes := serializeIndex(index, encoding)
+		for _, n := range []int{1, 4, 7, 12, len(indexBytes) / 2, len(indexBytes) - 1} {
+			if _, err := deserializeIndex(indexBytes[:n], encoding); !errors.Is(err, ErrCorruptIndex) {
+				t.Fatalf("Expected ErrCorruptIndex for index truncated to %d bytes, got %v", n, err)
+			}
+		}
+	}
+
+	indexBytes := serializeIndex(index, plainEncoding)
+	damaged := append([]byte{}, indexBytes...)
+	damaged[len("/active\x00")] = 'x' // {type byte} of the first entry
+	if _, err := deserializeIndex(damaged, plainEncoding); !errors.Is(err, ErrCorruptIndex) {
+		t.Fatalf("Expected ErrCorruptIndex for damaged index, got %v", err)
+	}
+
+	dirPath := t.TempDir()
+	check(os.WriteFile(dirPath+"/INDEX", indexBytes[:len(indexBytes)-3], 0644))
+	if _, err := ReadIndex(dirPath); !errors.Is(err, ErrCorruptIndex) {
+		t.Fatalf("Expected ErrCorruptIndex from ReadIndex, got %v", err)
+	}
+}
+
+// Check if invalid json document fails indexing instead of exiting.
+func TestIndexFilesInvalidJson(t *testing.T) {
+	dirPath := t.TempDir()
+	check(os.WriteFile(dirPath+"/0", []byte(`{"name": `), 0644))
+
+	if _, err := IndexFiles([]string{dirPath + "/0"}); err == nil {
+		t.Fatalf("Expected error for invalid json document")
+	}
+	check(os.WriteFile(dirPath+"/1", []byte(`{"name": "Ann"} {}`), 0644))
+	if _, err := IndexFiles([]string{dirPath + "/1"}); err == nil {
+		t.Fatalf("Expected error for json document followed by another value")
+	}
+	if _, err := IndexFiles([]string{dirPath + "/missing"}); err == nil {
+		t.Fatalf("Expected error for missing document")
+	}
+}
+
+// Check if malformed query returns a syntax error with its position.
+func TestQueryIndexSyntaxError(t *testing.T) {
+	index := readTestIndex(t, "./db")
+
+	_, err := QueryIndex(&index, "SELECT * FROM c WHERE c.age >")
+	var syntaxErr *parser.SyntaxError
+	if !errors.As(err, &syntaxErr) {
+		t.Fatalf("Expected syntax error, got %v", err)
+	}
+
+	refs, err := QueryIndex(&index, "SELECT * FROM c WHERE c.age > 20")
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if !compareSlices(refs, []Ref{0}) {
+		t.Fatalf("Expected refs different than actual:\n%v\n%v", []Ref{0}, refs)
+	}
+}
+
+// Check if AND is evaluated before OR unless grouped with parentheses.
+func TestQueryIndexPrecedence(t *testing.T) {
+	index := readTestIndex(t, "./db")
+	assert := func(query string, expected ...Ref) {
+		refs, err := QueryIndex(&index, query)
+		if err != nil {
+			t.Fatalf("Got unexpected error for %s: %v", query, err)
+		}
+		if !compareSlices(refs, expected) {
+			t.Fatalf("Expected refs for %s different than actual:\n%v\n%v", query, expected, refs)
+		}
+	}
+
+	assert("SELECT * FROM c WHERE c.name = 'Fraser' OR c.age = 23 AND c.type = 'Reader'", 0, 1)
+	assert("SELECT * FROM c WHERE (c.name = 'Fraser' OR c.age = 23) AND c.type = 'Reader'", 0)
+	assert("SELECT * FROM c WHERE c.type = 'Reader' AND (c.age < 20 OR c.active = FALSE)")
+	assert("SELECT * FROM c WHERE (c.age < 20 AND c.active = FALSE) OR (c.age > 20 AND c.active = TRUE)", 0, 1)
+}
+
+// Check if NOT, != and <> exclude matching documents.
+func TestQueryIndexNot(t *testing.T) {
+	index := readTestIndex(t, "./db")
+	assert := func(query string, expected ...Ref) {
+		refs, err := QueryIndex(&index, query)
+		if err != nil {
+			t.Fatalf("Got unexpected error for %s: %v", query, err)
+		}
+		if !compareSlices(refs, expected) {
+			t.Fatalf("Expected refs for %s different than actual:\n%v\n%v", query, expected, refs)
+		}
+	}
+
+	assert("SELECT * FROM c WHERE c.type != 'Reader'", 1)
+	assert("SELECT * FROM c WHERE c.age <> 17", 0)
+	assert("SELECT * FROM c WHERE c.age != 'Reader'")
+	assert("SELECT * FROM c WHERE c.missing != 1")
+	assert("SELECT * FROM c WHERE NOT (c.age > 30)", 0, 1)
+	assert("SELECT * FROM c WHERE NOT c.name = 'Elliot'", 1)
+	assert("SELECT * FROM c WHERE NOT (c.age > 20 OR c.active = FALSE)")
+	assert("SELECT * FROM c WHERE c.active = TRUE OR NOT c.type = 'Author'", 0)
+}
+
+// Check if array elements are found at any position through wildcard keys.
+func TestQueryIndexArrays(t *testing.T) {
+	dirPath := t.TempDir()
+	docs := []string{
+		`{"tags": ["go", "db", "go"], "people": [{"name": "Ann", "age": 31}], "grid": [[1, 2], [3]]}`,
+		`{"tags": ["rust"], "people": [{"name": "Bob", "age": 17}, {"name": "Cid", "age": 45}], "grid": [[4]]}`,
+		`{"tags": "go", "people": []}`,
+	}
+	paths := make([]string, len(docs))
+	for i, doc := range docs {
+		paths[i] = fmt.Sprintf("%s/%d", dirPath, i)
+		check(os.WriteFile(paths[i], []byte(doc), 0644))
+	}
+	index := indexTestFiles(t, paths)
+
+	assert := func(query string, expected ...Ref) {
+		refs, err := QueryIndex(&index, query)
+		if err != nil {
+			t.Fatalf("Got unexpected error for %s: %v", query, err)
+		}
+		if !compareSlices(refs, expected) {
+			t.Fatalf("Expected refs for %s different than actual:\n%v\n%v", query, expected, refs)
+		}
+	}
+
+	assert("SELECT * FROM c WHERE 'go' IN c.tags", 0)
+	assert("SELECT * FROM c WHERE ARRAY_CONTAINS(c.tags, 'rust') OR ARRAY_CONTAINS(c.tags, 'db')", 0, 1)
+	assert("SELECT * FROM c WHERE c.tags[*] = 'go' AND c.tags[*] = 'db'", 0)
+	assert("SELECT * FROM c WHERE c.people[*].age > 40", 1)
+	assert("SELECT * FROM c WHERE c.people[*].name = 'Bob' AND c.people[*].age > 40", 1)
+	assert("SELECT * FROM c WHERE c.grid[*][*] BETWEEN 2 AND 3", 0)
+	assert("SELECT * FROM c WHERE 4 IN c.grid[*]", 1)
+	assert("SELECT * FROM c WHERE NOT 'go' IN c.tags", 1, 2)
+
+	// duplicate elements keep a single ref per value
+	entryIdx, found := findEntry(&index, "/tags/*", StrType)
+	if !found || !compareSlices(index.EntryRefs(entryIdx, 1), []Ref{0}) {
+		t.Fatalf("Expected single ref of 'go' under /tags/*, got %v", index[entryIdx])
+	}
+}
+
+// Check if subscripts and quoted property names find values under flattened keys.
+func TestQueryIndexSubscripts(t *testing.T) {
+	index := readTestIndex(t, "./db")
+	assert := func(query string, expected ...Ref) {
+		refs, err := QueryIndex(&index, query)
+		if err != nil {
+			t.Fatalf("Got unexpected error for %s: %v", query, err)
+		}
+		if !compareSlices(refs, expected) {
+			t.Fatalf("Expected refs for %s different than actual:\n%v\n%v", query, expected, refs)
+		}
+	}
+
+	assert("SELECT * FROM c WHERE c.arr[1] = 3", 0)
+	assert("SELECT * FROM c WHERE c.arr[0] = 3")
+	assert(`SELECT * FROM c WHERE c["now null behaves"] IS NULL`, 0)
+	assert(`SELECT * FROM c WHERE c['social']["twitter"] = 'https://twitter.com' AND c.age < 20`, 1)
+}
@@ -0,0 +1,348 @@
+package nosqlite
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/jacnik/nosqlite/parser"
+)
+
+/* Documents are stored as json files named by their decimal id next to the INDEX file.
+** Id of a document is also its file ref in the index, so it never changes
+** and the INDEX is patched incrementally instead of being rebuilt.
+** When the directory has a DOCS file, ids are resolved to paths through it. */
+
+// ErrDocumentNotFound is returned when a document id is not stored in the database directory.
+var ErrDocumentNotFound = errors.New("Document does not exist")
+
+// documentPath returns "" for ids without a document.
+func documentPath(dirPath string, id Ref) (string, error) {
+	docs, err := ReadDocs(dirPath)
+	if err != nil {
+		return "", err
+	}
+	path := docs.path(id)
+	if path == "" {
+		return "", nil
+	}
+	return filepath.Join(dirPath, path), nil
+}
+
+// documentIds returns sorted ids of all stored documents.
+func documentIds(dirPath string) ([]Ref, error) {
+	docs, err := ReadDocs(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]Ref, 0, 16)
+	if docs != nil {
+		for ref, path := range docs {
+			if path != "" {
+				ids = append(ids, Ref(ref))
+			}
+		}
+		return ids, nil
+	}
+
+	names, err := listDir(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		if id, err := strconv.ParseUint(name, 10, 32); err == nil {
+			ids = append(ids, Ref(id))
+		}
+	}
+	slices.Sort(ids)
+	return ids, nil
+}
+
+// liveRefs returns refs of all stored documents for negated conditions.
+func liveRefs(dirPath string) func() (fileRefs, error) {
+	return func() (fileRefs, error) {
+		ids, err := documentIds(dirPath)
+		return sliceToRefs(ids), err
+	}
+}
+
+// hasMatch reports whether program has MATCH conditions.
+func hasMatch(program parser.Program) bool {
+	return slices.ContainsFunc(program.Instructions, func(instruction parser.Instruction) bool {
+		return instruction.Op == parser.Match
+	})
+}
+
+// evalDirProgram runs program against index of documents stored in dirPath,
+// collating the program like INDEX file of dirPath. MATCH conditions are run against text,
+// or against full-text index of dirPath when text is nil, see dirTextIndex.
+func evalDirProgram(dirPath string, index DirIndex, program parser.Program, text *TextIndex) (fileRefs, error) {
+	collation, err := ReadCollation(dirPath)
+	if err != nil {
+		return fileRefs{}, err
+	}
+	if text == nil && hasMatch(program) {
+		if text, err = dirTextIndex(dirPath, index); err != nil {
+			return fileRefs{}, err
+		}
+	}
+	return evalProgram(index, program, collation, text, liveRefs(dirPath))
+}
+
+// dirTextIndex returns full-text index kept in memory by index, or reads TEXT file of dirPath.
+func dirTextIndex(dirPath string, index DirIndex) (*TextIndex, error) {
+	if text := index.textIndex(); text != nil {
+		return text, nil
+	}
+	return ReadTextIndex(dirPath)
+}
+
+func nextDocumentId(dirPath string) (Ref, error) {
+	docs, err := ReadDocs(dirPath)
+	if err != nil {
+		return 0, err
+	}
+	names, err := listDir(dirPath)
+	if err != nil {
+		return 0, err
+	}
+	// new document file is named by its id, so it must not clash with any existing file either
+	next := Ref(len(docs))
+	for _, name := range names {
+		if id, err := strconv.ParseUint(name, 10, 32); err == nil && Ref(id) >= next {
+			next = Ref(id) + 1
+		}
+	}
+	return next, nil
+}
+
+// setDocumentPath records path of document id in DOCS file, if the directory has one.
+func setDocumentPath(dirPath string, id Ref, path string) error {
+	docs, err := ReadDocs(dirPath)
+	if err != nil || docs == nil {
+		return err
+	}
+	for int(id) >= len(docs) {
+		docs = append(docs, "")
+	}
+	docs[id] = path
+	return SaveDocs(docs, dirPath)
+}
+
+func flattenDocument(doc []byte, collation Collation) (flattenJsonT, error) {
+	unflatten, err := parseJson(doc)
+	if err != nil {
+		return nil, err
+	}
+	return collateFlatten(flattenJson(unflatten), collation), nil
+}
+
+func readDocument(dirPath string, id Ref) (string, []byte, error) {
+	docs, err := ReadDocs(dirPath)
+	if err != nil {
+		return "", nil, err
+	}
+	return readDocumentOf(dirPath, docs, id)
+}
+
+// readDocumentOf reads document with given id located through docs of dirPath.
+func readDocumentOf(dirPath string, docs DocsT, id Ref) (string, []byte, error) {
+	path := docs.path(id)
+	if path != "" {
+		path = filepath.Join(dirPath, path)
+	}
+	if path == "" {
+		return "", nil, fmt.Errorf("%w: %d", ErrDocumentNotFound, id)
+	}
+	if _, _, isLine := splitLocation(path); isLine {
+		return "", nil, fmt.Errorf("%w: %d", ErrReadOnlyDocument, id)
+	}
+	doc, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil, fmt.Errorf("%w: %d", ErrDocumentNotFound, id)
+	}
+	return path, doc, err
+}
+
+// InsertDocument stores a new json document in dirPath and returns its id.
+func InsertDocument(dirPath string, index DirIndex, doc []byte) (Ref, error) {
+	if _, err := flattenDocument(doc, BinaryCollation); err != nil {
+		return 0, err
+	}
+
+	id, err := nextDocumentId(dirPath)
+	if err != nil {
+		return 0, err
+	}
+	name := strconv.FormatUint(uint64(id), 10)
+	if err := os.WriteFile(filepath.Join(dirPath, name), doc, 0644); err != nil {
+		return 0, err
+	}
+	if err := setDocumentPath(dirPath, id, name); err != nil {
+		return 0, err
+	}
+	return id, AddToIndex(dirPath, index, id, doc)
+}
+
+// UpdateDocument replaces content of the document with given id.
+func UpdateDocument(dirPath string, index DirIndex, id Ref, doc []byte) error {
+	if _, err := flattenDocument(doc, BinaryCollation); err != nil {
+		return err
+	}
+	path, oldDoc, err := readDocument(dirPath, id)
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(path, doc, 0644); err != nil {
+		return err
+	}
+	return ReplaceInIndex(dirPath, index, id, oldDoc, doc)
+}
+
+// DeleteDocument removes the document with given id.
+func DeleteDocument(dirPath string, index DirIndex, id Ref) error {
+	return DeleteDocuments(dirPath, index, []Ref{id})
+}
+
+// DeleteDocuments removes documents with given ids, reading and saving DOCS file once for all of them.
+func DeleteDocuments(dirPath string, index DirIndex, ids []Ref) error {
+	docs, err := ReadDocs(dirPath)
+	if err != nil {
+		return err
+	}
+
+	deleted := 0
+	for _, id := range ids {
+		var path string
+		var oldDoc []byte
+		if path, oldDoc, err = readDocumentOf(dirPath, docs, id); err != nil {
+			break
+		}
+		if err = os.Remove(path); err != nil {
+			break
+		}
+		if docs != nil {
+			docs[id] = ""
+		}
+		deleted++
+		if err = RemoveFromIndex(dirPath, index, id, oldDoc); err != nil {
+			break
+		}
+	}
+	// documents removed before a failure are still dropped from DOCS file
+	if docs != nil && deleted > 0 {
+		if saveErr := SaveDocs(docs, dirPath); err == nil {
+			err = saveErr
+		}
+	}
+	return err
+}
+
+// setJsonPath sets val under flattened key path like "/social/twitter",
+// creating missing object properties on the way. Existing values keep their type, so an array
+// index out of range and a path going through a value which is neither object nor array are errors.
+func setJsonPath(unflatten interface{}, path []string, val interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return val, nil
+	}
+
+	var item interface{}
+	var err error
+	switch v := unflatten.(type) {
+	case map[string]interface{}:
+		if current, found := v[path[0]]; found {
+			item, err = setJsonPath(current, path[1:], val)
+		} else {
+			item, err = setJsonPath(map[string]interface{}{}, path[1:], val)
+		}
+		if err != nil {
+			return nil, err
+		}
+		v[path[0]] = item
+		return v, nil
+	case []interface{}:
+		i, atoiErr := strconv.Atoi(path[0])
+		if atoiErr != nil || i < 0 || i >= len(v) {
+			return nil, fmt.Errorf("Index %s out of range of array with %d elements", path[0], len(v))
+		}
+		if item, err = setJsonPath(v[i], path[1:], val); err != nil {
+			return nil, err
+		}
+		v[i] = item
+		return v, nil
+	}
+	return nil, fmt.Errorf("Property %s of value which is neither object nor array", path[0])
+}
+
+func applyAssignments(doc []byte, assignments []parser.Assignment) ([]byte, error) {
+	unflatten, err := parseJson(doc)
+	if err != nil {
+		return nil, err
+	}
+	for _, assignment := range assignments {
+		path := strings.Split(strings.TrimPrefix(assignment.Key, "/"), "/")
+		if unflatten, err = setJsonPath(unflatten, path, assignment.Val); err != nil {
+			return nil, fmt.Errorf("Can not set %s: %w", assignment.Key, err)
+		}
+	}
+	return json.MarshalIndent(unflatten, "", "  ")
+}
+
+// ExecStatement runs SELECT, INSERT, UPDATE or DELETE statement against documents in dirPath
+// and returns ids of matched, inserted, updated or deleted documents.
+func ExecStatement(dirPath string, index DirIndex, query string) ([]Ref, error) {
+	program, err := parser.Parse(query)
+	if err != nil {
+		return nil, err
+	}
+
+	switch program.Kind {
+	case parser.Insert:
+		id, err := InsertDocument(dirPath, index, []byte(program.Document))
+		if err != nil {
+			return nil, err
+		}
+		return []Ref{id}, nil
+	case parser.Select:
+		refs, err := evalDirProgram(dirPath, index, program, nil)
+		if err != nil {
+			return nil, err
+		}
+		return refsToSlice(refs), nil
+	}
+
+	if len(program.Instructions) == 0 {
+		return nil, errors.New("UPDATE and DELETE statements require a WHERE clause")
+	}
+
+	refs, err := evalDirProgram(dirPath, index, program, nil)
+	if err != nil {
+		return nil, err
+	}
+	ids := refsToSlice(refs)
+	if program.Kind == parser.Delete {
+		if err := DeleteDocuments(dirPath, index, ids); err != nil {
+			return nil, err
+		}
+		return ids, nil
+	}
+	for _, id := range ids {
+		_, doc, err := readDocument(dirPath, id)
+		if err == nil {
+			doc, err = applyAssignments(doc, program.Assignments)
+		}
+		if err == nil {
+			err = UpdateDocument(dirPath, index, id, doc)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ids, nil
+}
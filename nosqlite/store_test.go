@@ -0,0 +1,222 @@
+package nosqlite
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/jacnik/nosqlite/parser"
+)
+
+func copyDb(t *testing.T) string {
+	dirPath := t.TempDir()
+	for _, name := range []string{"0", "1", "INDEX"} {
+		bytes, err := os.ReadFile("./db/" + name)
+		check(err)
+		check(os.WriteFile(dirPath+"/"+name, bytes, 0644))
+	}
+	return dirPath
+}
+
+// Check if inserted document gets next id and becomes queryable.
+func TestInsertDocument(t *testing.T) {
+	dirPath := copyDb(t)
+	index := readTestIndex(t, dirPath)
+
+	id, err := InsertDocument(dirPath, &index, []byte(`{"name": "Ann", "age": 31, "social": {"twitter": "https://x.com"}}`))
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if id != 2 {
+		t.Fatalf("Expected document id 2, got %d", id)
+	}
+
+	savedIndex := readTestIndex(t, dirPath)
+	expectedIndex := indexTestFiles(t, []string{dirPath + "/0", dirPath + "/1", dirPath + "/2"})
+	if !compareIndexes(expectedIndex, savedIndex) {
+		t.Fatalf("Expected index different than actual:\n%v\n%v", expectedIndex, savedIndex)
+	}
+
+	refs := getFileRefs(&savedIndex, "/age", parser.Gt, 30.0, FloatType)
+	expected := fileRefs{}
+	expected.Set(2)
+	if !compareRefs(refs, expected) {
+		t.Fatalf("Expected refs different than actual:\n%v\n%v", expected, refs)
+	}
+
+	if _, err := InsertDocument(dirPath, &index, []byte(`{"name": `)); err == nil {
+		t.Fatalf("Expected error for invalid json document")
+	}
+}
+
+// Check if updated document replaces its old values in the index.
+func TestUpdateDocument(t *testing.T) {
+	dirPath := copyDb(t)
+	index := readTestIndex(t, dirPath)
+
+	if err := UpdateDocument(dirPath, &index, 1, []byte(`{"name": "Fraser", "age": 18}`)); err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+
+	savedIndex := readTestIndex(t, dirPath)
+	expectedIndex := indexTestFiles(t, []string{dirPath + "/0", dirPath + "/1"})
+	if !compareIndexes(expectedIndex, savedIndex) {
+		t.Fatalf("Expected index different than actual:\n%v\n%v", expectedIndex, savedIndex)
+	}
+
+	if err := UpdateDocument(dirPath, &index, 7, []byte(`{}`)); err == nil {
+		t.Fatalf("Expected error for non existing document")
+	}
+}
+
+// Check if deleted document is removed from disk and from the index.
+func TestDeleteDocument(t *testing.T) {
+	dirPath := copyDb(t)
+	index := readTestIndex(t, dirPath)
+
+	if err := DeleteDocument(dirPath, &index, 0); err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if _, err := os.Stat(dirPath + "/0"); !os.IsNotExist(err) {
+		t.Fatalf("Expected document file to be removed")
+	}
+
+	savedIndex := readTestIndex(t, dirPath)
+	expectedIndex := IndexT{
+		IndexEntry{"/active", BoolType, []ValueRefs{{value: false, refs: []Ref{1}}}},
+		IndexEntry{"/age", IntType, []ValueRefs{{value: int64(17), refs: []Ref{1}}}},
+		IndexEntry{"/name", StrType, []ValueRefs{{value: "Fraser", refs: []Ref{1}}}},
+		IndexEntry{"/social/facebook", StrType, []ValueRefs{{value: "https://facebook.com", refs: []Ref{1}}}},
+		IndexEntry{"/social/twitter", StrType, []ValueRefs{{value: "https://twitter.com", refs: []Ref{1}}}},
+		IndexEntry{"/type", StrType, []ValueRefs{{value: "Author", refs: []Ref{1}}}}}
+	if !compareIndexes(expectedIndex, savedIndex) {
+		t.Fatalf("Expected index different than actual:\n%v\n%v", expectedIndex, savedIndex)
+	}
+}
+
+// Check if INSERT, UPDATE, DELETE and SELECT statements are executed against the store.
+func TestExecStatement(t *testing.T) {
+	dirPath := copyDb(t)
+	index := readTestIndex(t, dirPath)
+
+	assert := func(query string, expected ...Ref) {
+		ids, err := ExecStatement(dirPath, &index, query)
+		if err != nil {
+			t.Fatalf("Got unexpected error for %s: %v", query, err)
+		}
+		if !compareSlices(ids, expected) {
+			t.Fatalf("Expected ids for %s different than actual:\n%v\n%v", query, expected, ids)
+		}
+	}
+
+	assert(`INSERT INTO c VALUES '{"name": "Ann", "age": 31}'`, 2)
+	assert("UPDATE c SET c.age = 32, c.social.twitter = 'https://x.com' WHERE c.name = 'Ann'", 2)
+	assert("SELECT * FROM c WHERE c.age > 31", 2)
+	assert("SELECT * FROM c WHERE c.social.twitter = 'https://x.com'", 2)
+	assert("DELETE FROM c WHERE c.age < 20", 1)
+	assert("SELECT * FROM c", 0, 2)
+
+	if _, err := ExecStatement(dirPath, &index, "DELETE FROM c"); err == nil {
+		t.Fatalf("Expected error for DELETE without WHERE clause")
+	}
+
+	savedIndex := readTestIndex(t, dirPath)
+	expectedIndex := indexTestFiles(t, []string{dirPath + "/0"})
+	delta, err := documentIndex(readTestFile(t, dirPath+"/2"), 2, BinaryCollation)
+	check(err)
+	mergeIndex(&expectedIndex, delta)
+	if !compareIndexes(expectedIndex, savedIndex) {
+		t.Fatalf("Expected index different than actual:\n%v\n%v", expectedIndex, savedIndex)
+	}
+}
+
+// Check if NOT complements to every stored document, including ones without indexed values.
+func TestExecStatementNot(t *testing.T) {
+	dirPath := copyDb(t)
+	index := readTestIndex(t, dirPath)
+
+	ids, err := ExecStatement(dirPath, &index, `INSERT INTO c VALUES '{}'`)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if !compareSlices(ids, []Ref{2}) {
+		t.Fatalf("Expected ids different than actual:\n%v\n%v", []Ref{2}, ids)
+	}
+
+	ids, err = ExecStatement(dirPath, &index, "SELECT * FROM c WHERE NOT c.age > 20")
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if !compareSlices(ids, []Ref{1, 2}) {
+		t.Fatalf("Expected ids different than actual:\n%v\n%v", []Ref{1, 2}, ids)
+	}
+
+	ids, err = ExecStatement(dirPath, &index, "DELETE FROM c WHERE c.type != 'Author' AND NOT c.age < 20")
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if !compareSlices(ids, []Ref{0}) {
+		t.Fatalf("Expected ids different than actual:\n%v\n%v", []Ref{0}, ids)
+	}
+}
+
+// Check if doubled quotes are stored as quotes and DELETE of many documents clears all of them from DOCS.
+func TestExecStatementQuotesAndDeleteMany(t *testing.T) {
+	dirPath := copyDb(t)
+	check(SaveDocs(DocsT{"0", "1"}, dirPath))
+	index := readTestIndex(t, dirPath)
+
+	ids, err := ExecStatement(dirPath, &index, `INSERT INTO c VALUES '{"name": "O''Brien", "age": 15}'`)
+	check(err)
+	if _, doc, err := readDocument(dirPath, ids[0]); err != nil || string(doc) != `{"name": "O'Brien", "age": 15}` {
+		t.Fatalf("Expected inserted document with quote, got %s %v", doc, err)
+	}
+	ids, err = ExecStatement(dirPath, &index, "SELECT * FROM c WHERE c.name = 'O''Brien'")
+	if err != nil || !compareSlices(ids, []Ref{2}) {
+		t.Fatalf("Expected ids different than actual:\n%v\n%v %v", []Ref{2}, ids, err)
+	}
+
+	ids, err = ExecStatement(dirPath, &index, "DELETE FROM c WHERE c.age < 20")
+	if err != nil || !compareSlices(ids, []Ref{1, 2}) {
+		t.Fatalf("Expected ids different than actual:\n%v\n%v %v", []Ref{1, 2}, ids, err)
+	}
+	docs, err := ReadDocs(dirPath)
+	check(err)
+	if expected := (DocsT{"0", "", ""}); !compareSlices(docs, expected) {
+		t.Fatalf("Expected docs different than actual:\n%v\n%v", expected, docs)
+	}
+	for _, name := range []string{"1", "2"} {
+		if _, err := os.Stat(dirPath + "/" + name); !os.IsNotExist(err) {
+			t.Fatalf("Expected document file %s to be removed", name)
+		}
+	}
+}
+
+// Check if SET replaces array elements in range and never changes type of a value on its path.
+func TestExecStatementSetPaths(t *testing.T) {
+	dirPath := copyDb(t)
+	index := readTestIndex(t, dirPath)
+	ids, err := ExecStatement(dirPath, &index, `INSERT INTO c VALUES '{"name": "Tag", "tags": ["a", "b"], "n": 1}'`)
+	check(err)
+	_, err = ExecStatement(dirPath, &index, "UPDATE c SET c.tags[1] = 'x', c.social.twitter = 'y' WHERE c.name = 'Tag'")
+	check(err)
+
+	for _, query := range []string{
+		"UPDATE c SET c.tags[5] = 'x' WHERE c.name = 'Tag'",
+		"UPDATE c SET c.tags[*] = 'x' WHERE c.name = 'Tag'",
+		"UPDATE c SET c.n.m = 2 WHERE c.name = 'Tag'",
+	} {
+		if _, err := ExecStatement(dirPath, &index, query); err == nil {
+			t.Fatalf("Expected error for %s", query)
+		}
+	}
+
+	doc, err := ReadDocument(dirPath, ids[0])
+	check(err)
+	unflatten, err := parseJson(doc)
+	check(err)
+	expected := `map[n:1 name:Tag social:map[twitter:y] tags:[a x]]`
+	if actual := fmt.Sprint(unflatten); actual != expected {
+		t.Fatalf("Expected document different than actual:\n%v\n%v", expected, actual)
+	}
+}
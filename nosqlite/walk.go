@@ -0,0 +1,166 @@
+package nosqlite
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+/* Documents of a database directory are found by walking its whole tree.
+** Hidden files and directories, whose names start with '.', and files of the database itself
+** are skipped, symlinks are followed unless they lead back to a directory being walked.
+** Glob patterns are matched against paths relative to the database directory with '/' separators:
+**   '*', '?' and '[...]' match within a single path element like path.Match,
+**   '**' matches any number of path elements,
+**   pattern without '/' matches base name at any depth, leading '/' anchors it to the database directory
+**   and trailing '/' matches directories only. */
+
+// DirOptions selects documents of a database directory tree and how they are indexed.
+type DirOptions struct {
+	// Include lists glob patterns of indexed files, every file is indexed when it is empty.
+	Include []string
+	// Exclude lists glob patterns of skipped files and directories, like "tmp/" or "*.bak".
+	Exclude []string
+	// Collation of string values of a new INDEX file, an existing one keeps its own collation.
+	Collation Collation
+	// TextKeys lists flattened keys, like "/description", whose string values are searched by MATCH conditions.
+	// When it is empty an existing TEXT file keeps its keys.
+	TextKeys []string
+}
+
+func isDatabaseFile(name string) bool {
+	return name == "INDEX" || name == deltaFileName || name == docsFileName || name == textFileName || name == textDeltaFileName
+}
+
+// globSegments returns path elements of pattern to match and whether it matches directories only.
+func globSegments(pattern string) ([]string, bool) {
+	dirOnly := strings.HasSuffix(pattern, "/")
+	pattern = strings.TrimSuffix(pattern, "/")
+	if strings.HasPrefix(pattern, "/") {
+		pattern = strings.TrimPrefix(pattern, "/")
+	} else if !strings.Contains(pattern, "/") {
+		pattern = "**/" + pattern
+	}
+	return strings.Split(pattern, "/"), dirOnly
+}
+
+func checkGlob(pattern string) error {
+	segments, _ := globSegments(pattern)
+	for _, segment := range segments {
+		if _, err := path.Match(segment, ""); err != nil {
+			return fmt.Errorf("Invalid glob pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+func matchSegments(pattern, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if matchSegments(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segments) == 0 {
+		return false
+	}
+	if matched, _ := path.Match(pattern[0], segments[0]); !matched {
+		return false
+	}
+	return matchSegments(pattern[1:], segments[1:])
+}
+
+// matchGlob reports whether relPath of a file or directory matches pattern.
+func matchGlob(pattern, relPath string, isDir bool) bool {
+	segments, dirOnly := globSegments(pattern)
+	if dirOnly && !isDir {
+		return false
+	}
+	return matchSegments(segments, strings.Split(relPath, "/"))
+}
+
+func matchAnyGlob(patterns []string, relPath string, isDir bool) bool {
+	for _, pattern := range patterns {
+		if matchGlob(pattern, relPath, isDir) {
+			return true
+		}
+	}
+	return false
+}
+
+// walkDir returns paths of documents in the tree of dirPath, relative to it with '/' separators,
+// ordered by name within every directory.
+func walkDir(dirPath string, opts DirOptions) ([]string, error) {
+	for _, pattern := range append(append([]string{}, opts.Include...), opts.Exclude...) {
+		if err := checkGlob(pattern); err != nil {
+			return nil, err
+		}
+	}
+	root, err := os.Stat(dirPath)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, 16)
+	var walk func(relDir string, ancestors []os.FileInfo) error
+	walk = func(relDir string, ancestors []os.FileInfo) error {
+		entries, err := os.ReadDir(filepath.Join(dirPath, filepath.FromSlash(relDir)))
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if strings.HasPrefix(name, ".") || (relDir == "" && isDatabaseFile(name)) {
+				continue
+			}
+			relPath := path.Join(relDir, name)
+			// follows symlinks, dangling ones are skipped
+			info, err := os.Stat(filepath.Join(dirPath, filepath.FromSlash(relPath)))
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			if info.IsDir() {
+				if matchAnyGlob(opts.Exclude, relPath, true) || isAncestor(ancestors, info) {
+					continue
+				}
+				if err := walk(relPath, append(ancestors[:len(ancestors):len(ancestors)], info)); err != nil {
+					return err
+				}
+				continue
+			}
+			if !info.Mode().IsRegular() || matchAnyGlob(opts.Exclude, relPath, false) {
+				continue
+			}
+			if len(opts.Include) == 0 || matchAnyGlob(opts.Include, relPath, false) {
+				names = append(names, relPath)
+			}
+		}
+		return nil
+	}
+	if err := walk("", []os.FileInfo{root}); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// isAncestor reports symlink loops, directories already being walked.
+func isAncestor(ancestors []os.FileInfo, dir os.FileInfo) bool {
+	for _, ancestor := range ancestors {
+		if os.SameFile(ancestor, dir) {
+			return true
+		}
+	}
+	return false
+}
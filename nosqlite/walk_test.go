@@ -0,0 +1,95 @@
+package nosqlite
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Check if glob patterns match paths relative to the database directory.
+func TestMatchGlob(t *testing.T) {
+	assert := func(pattern, relPath string, isDir, expected bool) {
+		if actual := matchGlob(pattern, relPath, isDir); actual != expected {
+			t.Fatalf("Expected %s matching %s (dir %t) to be %t, got %t", pattern, relPath, isDir, expected, actual)
+		}
+	}
+	assert("**/*.json", "a.json", false, true)
+	assert("**/*.json", "x/y/a.json", false, true)
+	assert("**/*.json", "x/y/a.jsonl", false, false)
+	assert("*.json", "x/y/a.json", false, true)
+	assert("/*.json", "x/a.json", false, false)
+	assert("/*.json", "a.json", false, true)
+	assert("tmp/", "tmp", true, true)
+	assert("tmp/", "x/tmp", true, true)
+	assert("tmp/", "tmp", false, false)
+	assert("people/**/2024-??.jsonl", "people/eu/de/2024-05.jsonl", false, true)
+	assert("people/**/2024-??.jsonl", "archive/people/2024-05.jsonl", false, false)
+	assert("[ab]*", "x/bob.json", false, true)
+
+	if _, err := walkDir(t.TempDir(), DirOptions{Include: []string{"[a-"}}); err == nil {
+		t.Fatalf("Expected error for invalid glob pattern")
+	}
+}
+
+// Check if the whole tree is indexed in place, skipping hidden, excluded and database files and symlink loops.
+func TestIndexDirTree(t *testing.T) {
+	dirPath := t.TempDir()
+	write := func(relPath, doc string) {
+		path := filepath.Join(dirPath, filepath.FromSlash(relPath))
+		check(os.MkdirAll(filepath.Dir(path), 0755))
+		check(os.WriteFile(path, []byte(doc), 0644))
+	}
+	write("a.json", `{"name": "Ann"}`)
+	write("people/b.json", `{"name": "Bob"}`)
+	write("people/eu/c.jsonl", "{\"name\": \"Cid\"}\n{\"name\": \"Dan\"}\n")
+	write("people/notes.txt", `not json`)
+	write("tmp/d.json", `{"name": "Tmp"}`)
+	write(".cache/e.json", `{"name": "Hidden"}`)
+	write("people/.f.json", `{"name": "Hidden"}`)
+	write("DOCS", string(serializeDocs(DocsT{})))
+	check(os.Symlink("..", filepath.Join(dirPath, "people", "eu", "loop")))
+	check(os.Symlink("people", filepath.Join(dirPath, "linked")))
+
+	opts := DirOptions{Include: []string{"**/*.json", "*.jsonl"}, Exclude: []string{"tmp/", "/linked"}}
+	names, err := walkDir(dirPath, opts)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	expected := []string{"a.json", "people/b.json", "people/eu/c.jsonl"}
+	if !compareSlices(expected, names) {
+		t.Fatalf("Expected names different than actual:\n%v\n%v", expected, names)
+	}
+
+	db, err := OpenWith(dirPath, opts)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	defer db.Close()
+	documents, err := db.Query("SELECT c.name FROM c WHERE c.name > 'Ann'")
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	assertDocuments(t, documents, []Document{
+		{1, "people/b.json", map[string]interface{}{"name": "Bob"}},
+		{2, "people/eu/c.jsonl#0", map[string]interface{}{"name": "Cid"}},
+		{3, "people/eu/c.jsonl#16", map[string]interface{}{"name": "Dan"}},
+	})
+
+	// inserted document is named by its ref, which include patterns do not select, but it is still indexed again
+	ids, err := db.Exec(`INSERT INTO c VALUES '{"name": "Eve"}'`)
+	check(err)
+	check(db.Index())
+	documents, err = db.Query("SELECT c.name FROM c WHERE c.name = 'Eve'")
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	assertDocuments(t, documents, []Document{{ids[0], "4", map[string]interface{}{"name": "Eve"}}})
+
+	// without /linked exclusion people are found twice, but the loop back to people is still skipped
+	names, err = walkDir(dirPath, DirOptions{Include: []string{"*.json"}})
+	check(err)
+	expected = []string{"a.json", "linked/b.json", "people/b.json", "tmp/d.json"}
+	if !compareSlices(expected, names) {
+		t.Fatalf("Expected names different than actual:\n%v\n%v", expected, names)
+	}
+}
@@ -1,7 +1,10 @@
 package parser
 
 import (
+	"fmt"
+	"slices"
 	"strconv"
+	"strings"
 )
 
 type tokenKind byte
@@ -25,8 +28,32 @@ const (
 	eq
 	gt
 	lt
+	ge
+	le
+	ne
 	and
 	or
+	between
+	boolean
+	null
+	is
+	not
+	insert
+	into
+	values
+	update
+	set_
+	delete_
+	quoted
+	in
+	arrayContains
+	like
+	startsWith
+	endsWith
+	contains
+	lower
+	upper
+	match
 )
 
 type token struct {
@@ -34,7 +61,24 @@ type token struct {
 	value any
 }
 
+// SyntaxError reports an invalid query together with byte position where the problem starts.
+type SyntaxError struct {
+	Pos int
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("Invalid syntax at position %d: %s", e.Pos, e.Msg)
+}
+
 func tokenize(query string) ([]token, error) {
+	tokens, _, err := tokenizeWithPositions(query)
+	return tokens, err
+}
+
+// tokenizeWithPositions returns tokens and byte positions in query where each of them starts.
+func tokenizeWithPositions(query string) ([]token, []int, error) {
+	var syntaxErr error
 	isWhitespace := func(query string, i int) bool {
 		spaces := []byte{' ', '\t', '\n'}
 		for _, s := range spaces {
@@ -72,7 +116,48 @@ func tokenize(query string) ([]token, error) {
 			return token{and, nil}
 		case "OR":
 			return token{or, nil}
-
+		case "BETWEEN":
+			return token{between, nil}
+		case "TRUE":
+			return token{boolean, true}
+		case "FALSE":
+			return token{boolean, false}
+		case "NULL":
+			return token{null, nil}
+		case "IS":
+			return token{is, nil}
+		case "NOT":
+			return token{not, nil}
+		case "INSERT":
+			return token{insert, nil}
+		case "INTO":
+			return token{into, nil}
+		case "VALUES":
+			return token{values, nil}
+		case "UPDATE":
+			return token{update, nil}
+		case "SET":
+			return token{set_, nil}
+		case "DELETE":
+			return token{delete_, nil}
+		case "IN":
+			return token{in, nil}
+		case "ARRAY_CONTAINS":
+			return token{arrayContains, nil}
+		case "LIKE":
+			return token{like, nil}
+		case "STARTS_WITH":
+			return token{startsWith, nil}
+		case "ENDS_WITH":
+			return token{endsWith, nil}
+		case "CONTAINS":
+			return token{contains, nil}
+		case "LOWER":
+			return token{lower, nil}
+		case "UPPER":
+			return token{upper, nil}
+		case "MATCH":
+			return token{match, nil}
 		}
 
 		return token{ident, identifier}
@@ -90,6 +175,9 @@ func tokenize(query string) ([]token, error) {
 	}
 
 	appendSpecial := func(tokens *[]token, query string, i int) (newPos int) {
+		if i >= len(query) {
+			return i
+		}
 		switch query[i] {
 		case '*':
 			*tokens = append(*tokens, token{star, nil})
@@ -97,13 +185,45 @@ func tokenize(query string) ([]token, error) {
 		case '.':
 			*tokens = append(*tokens, token{dot, nil})
 			return i + 1
+		case ',':
+			*tokens = append(*tokens, token{comma, nil})
+			return i + 1
+		case '(':
+			*tokens = append(*tokens, token{lparem, nil})
+			return i + 1
+		case ')':
+			*tokens = append(*tokens, token{rparem, nil})
+			return i + 1
+		case '[':
+			*tokens = append(*tokens, token{lsqbrack, nil})
+			return i + 1
+		case ']':
+			*tokens = append(*tokens, token{rsqbrack, nil})
+			return i + 1
 		case '=':
 			*tokens = append(*tokens, token{eq, nil})
 			return i + 1
+		case '!':
+			if i+1 < len(query) && query[i+1] == '=' {
+				*tokens = append(*tokens, token{ne, nil})
+				return i + 2
+			}
 		case '>':
+			if i+1 < len(query) && query[i+1] == '=' {
+				*tokens = append(*tokens, token{ge, nil})
+				return i + 2
+			}
 			*tokens = append(*tokens, token{gt, nil})
 			return i + 1
 		case '<':
+			if i+1 < len(query) && query[i+1] == '=' {
+				*tokens = append(*tokens, token{le, nil})
+				return i + 2
+			}
+			if i+1 < len(query) && query[i+1] == '>' {
+				*tokens = append(*tokens, token{ne, nil})
+				return i + 2
+			}
 			*tokens = append(*tokens, token{lt, nil})
 			return i + 1
 		}
@@ -111,89 +231,217 @@ func tokenize(query string) ([]token, error) {
 	}
 
 	appendText := func(tokens *[]token, query string, i int) (newPos int) {
-		if query[i] != '\'' {
+		// 'text' is a string value and "name" a quoted property name
+		if i >= len(query) || (query[i] != '\'' && query[i] != '"') {
 			return i
 		}
+		kind := text
+		if query[i] == '"' {
+			kind = quoted
+		}
+		// like in SQL a doubled quote stands for the quote itself, as in 'it''s'
+		quote := query[i : i+1]
 		for j := i + 1; j < len(query); j++ {
-			if query[j] == '\'' {
-				*tokens = append(*tokens, token{text, query[i+1 : j]})
-				return j + 1
+			if query[j] != query[i] {
+				continue
 			}
+			if j+1 < len(query) && query[j+1] == query[i] {
+				j++
+				continue
+			}
+			*tokens = append(*tokens, token{kind, strings.ReplaceAll(query[i+1:j], quote+quote, quote)})
+			return j + 1
 		}
+		syntaxErr = &SyntaxError{i, "Unterminated string literal"}
 		return len(query)
 	}
 
 	appendNumber := func(tokens *[]token, query string, i int) (newPos int) {
-		// TODO floats starting with `.`, ints and negative numbers
-		isDigit := func(query string, i int) bool {
-			return query[i] >= '0' && query[i] <= '9'
+		// number := [+-] (0x hexdigits | digits [. digits] [exponent] | . digits [exponent])
+		// exponent := (e | E) [+-] digits, single underscores may separate digits like in 1_000_000
+		isDigit := func(j int, hex bool) bool {
+			if j < 0 || j >= len(query) {
+				return false
+			}
+			c := query[j]
+			return c >= '0' && c <= '9' || hex && (c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F')
+		}
+		fail := func(pos int, msg string) int {
+			syntaxErr = &SyntaxError{pos, msg}
+			return len(query)
+		}
+		// readDigits returns position after digits starting at j, or -1 when an underscore does not separate two digits
+		readDigits := func(j int, hex bool) int {
+			for ; isDigit(j, hex) || (j < len(query) && query[j] == '_'); j++ {
+				if query[j] == '_' && !(isDigit(j-1, hex) && isDigit(j+1, hex)) {
+					fail(j, "Underscore must separate digits")
+					return -1
+				}
+			}
+			return j
+		}
+		isByte := func(j int, chars string) bool {
+			return j < len(query) && strings.IndexByte(chars, query[j]) >= 0
 		}
 
-		if i >= len(query)-1 || !isDigit(query, i) {
+		j := i
+		if isByte(j, "+-") {
+			j++
+		}
+		if !isDigit(j, false) && !(isByte(j, ".") && isDigit(j+1, false)) {
+			// sign without digits is reported as unexpected character
 			return i
 		}
 
-		dotsCount := 0
-		for j := i + 1; j < len(query); j++ {
-			if query[j] == '.' {
-				dotsCount++
-				j++
+		isHex, isFloat := false, false
+		if query[j] == '0' && isByte(j+1, "xX") {
+			isHex = true
+			digitsPos := j + 2
+			if j = readDigits(digitsPos, true); j < 0 {
+				return len(query)
 			}
-			if dotsCount > 1 {
-				panic("Invalid syntax. Too many dots in number") // TODO error
+			if j == digitsPos {
+				return fail(j, "Expected hexadecimal digit")
 			}
-			if !isDigit(query, j) {
-				if f, err := strconv.ParseFloat(query[i:j], 64); err == nil {
-					*tokens = append(*tokens, token{float, f})
-					return j + 1
+		} else {
+			if j = readDigits(j, false); j < 0 {
+				return len(query)
+			}
+			if isByte(j, ".") {
+				isFloat = true
+				if !isDigit(j+1, false) {
+					return fail(j+1, "Expected digit after decimal point")
+				}
+				if j = readDigits(j+1, false); j < 0 {
+					return len(query)
 				}
-				panic("Unable to cast to float")
 			}
-			if j >= len(query)-1 {
-				if f, err := strconv.ParseFloat(query[i:j+1], 64); err == nil {
-					*tokens = append(*tokens, token{float, f})
-					return j + 1
+			if isByte(j, "eE") {
+				isFloat = true
+				j++
+				if isByte(j, "+-") {
+					j++
+				}
+				if !isDigit(j, false) {
+					return fail(j, "Expected digit in exponent")
+				}
+				if j = readDigits(j, false); j < 0 {
+					return len(query)
 				}
 			}
 		}
+		if j < len(query) && (isIdentChar(query, j) || isDigit(j, true) || query[j] == '.') {
+			return fail(j, fmt.Sprintf("Unexpected character %q in number", query[j]))
+		}
 
-		return i
+		literal := strings.ReplaceAll(query[i:j], "_", "")
+		if isHex {
+			n, err := strconv.ParseInt(literal, 0, 64)
+			if err != nil {
+				return fail(i, fmt.Sprintf("Number %s out of range", query[i:j]))
+			}
+			*tokens = append(*tokens, token{integer, n})
+			return j
+		}
+		// numbers without fraction and exponent are integers, unless they do not fit in int64
+		if !isFloat {
+			if n, err := strconv.ParseInt(literal, 10, 64); err == nil {
+				*tokens = append(*tokens, token{integer, n})
+				return j
+			}
+		}
+		f, err := strconv.ParseFloat(literal, 64)
+		if err != nil {
+			return fail(i, fmt.Sprintf("Number %s out of range", query[i:j]))
+		}
+		*tokens = append(*tokens, token{float, f})
+		return j
 	}
 
 	tokens := make([]token, 0, 8)
-	for i := 0; ; {
+	positions := make([]int, 0, 8)
+	appendFuncs := []func(tokens *[]token, query string, i int) (newPos int){appendIdent, appendNumber, appendSpecial, appendText}
+	for i := 0; i < len(query); {
+		start := i
 		if isWhitespace(query, i) {
 			i++
 		}
 
-		i = appendIdent(&tokens, query, i)
-		i = appendSpecial(&tokens, query, i)
-		i = appendText(&tokens, query, i)
-		i = appendNumber(&tokens, query, i)
+		for _, appendFunc := range appendFuncs {
+			tokenPos := i
+			i = appendFunc(&tokens, query, i)
+			for len(positions) < len(tokens) {
+				positions = append(positions, tokenPos)
+			}
+		}
 
-		if i >= len(query) {
-			tokens = append(tokens, token{eof, nil})
-			break
+		if syntaxErr != nil {
+			return nil, nil, syntaxErr
+		}
+		if i == start {
+			return nil, nil, &SyntaxError{start, fmt.Sprintf("Unexpected character %q", query[start])}
 		}
 	}
+	tokens = append(tokens, token{eof, nil})
+	positions = append(positions, len(query))
 
-	return tokens, nil
+	return tokens, positions, nil
 }
 
 type OpType byte
 
 const (
-	Eq OpType = '='
-	Gt OpType = '>'
-	Lt OpType = '<'
+	Eq      OpType = '='
+	Gt      OpType = '>'
+	Lt      OpType = '<'
+	Ge      OpType = 'g'
+	Le      OpType = 'l'
+	Ne      OpType = '!'
+	Between OpType = 'b'
+	Is      OpType = 'i'
+	IsNot   OpType = 'I'
+	// Like matches string values against a pattern, where '%' stands for any sequence of characters
+	// and '_' for a single character.
+	Like       OpType = 'L'
+	StartsWith OpType = 'S'
+	EndsWith   OpType = 'E'
+	Contains   OpType = 'C'
+	// Match finds documents with every word of the text under the key in its full-text index.
+	Match OpType = 'M'
+)
+
+// Range is the value of a Between instruction. Both bounds are inclusive.
+type Range struct {
+	From interface{}
+	To   interface{}
+}
+
+type CaseFunc byte
+
+const (
+	Lower CaseFunc = 'l'
+	Upper CaseFunc = 'u'
 )
 
+// CaseValue is the value of a condition on LOWER(key) or UPPER(key),
+// Val, a string or a Range of strings, is compared to the value of key converted by Func.
+type CaseValue struct {
+	Func CaseFunc
+	Val  interface{}
+}
+
 type InstructionKind byte
 
 const (
 	Push InstructionKind = 'p'
 	And  InstructionKind = 'a'
 	Or   InstructionKind = 'o'
+	// AndPop and OrPop take no condition, they pop two topmost refs
+	// and push back their intersection or union.
+	AndPop InstructionKind = 'A'
+	OrPop  InstructionKind = 'O'
+	// Not takes no condition, it replaces the topmost refs with their complement to all live documents.
+	Not InstructionKind = 'n'
 )
 
 type Instruction struct {
@@ -203,8 +451,106 @@ type Instruction struct {
 	Val  interface{}
 }
 
+type StatementKind byte
+
+const (
+	Select StatementKind = 's'
+	Insert StatementKind = 'i'
+	Update StatementKind = 'u'
+	Delete StatementKind = 'd'
+)
+
+// Assignment is a single `c.key = value` pair of UPDATE ... SET statement.
+type Assignment struct {
+	Key string
+	Val interface{}
+}
+
 type Program struct {
+	Kind         StatementKind
 	Instructions []Instruction
+	Selection    []string     // keys of SELECT list, empty for SELECT *
+	Document     string       // json document of INSERT statement
+	Assignments  []Assignment // SET clause of UPDATE statement
+}
+
+/* WHERE clause is parsed into a tree of conditions first
+** and then compiled to instructions of the stack machine.
+**
+** expr      := andExpr { OR andExpr }
+** andExpr   := primary { AND primary }
+** primary   := NOT primary | '(' expr ')' | condition
+** condition := key ( op value | BETWEEN value AND value | IS [NOT] NULL | LIKE text )
+**            | ( LOWER | UPPER ) '(' key ')' ( op text | BETWEEN text AND text | LIKE text )
+**            | value IN key | ARRAY_CONTAINS '(' key ',' value ')'
+**            | ( STARTS_WITH | ENDS_WITH | CONTAINS | MATCH ) '(' key ',' text ')'
+** key       := ident { '.' ident | '[' subscript ']' }
+** subscript := non-negative integer | quoted name | text | '*'
+** value     := text | integer | float | TRUE | FALSE | NULL
+**
+** Keys are flattened like documents of the index: `c.arr[1]` is "/arr/1" and `c["now null behaves"]`
+** is "/now null behaves", so a quoted name holding '/' stands for nested levels.
+** `[*]` stands for any element of an array, it is compiled to WildcardKey level of the flattened key,
+** so `c.tags[*] = 'go'`, `'go' IN c.tags` and `ARRAY_CONTAINS(c.tags, 'go')` are the same condition.
+** It is only allowed in WHERE clause. */
+
+// WildcardKey is the level of flattened keys holding values of every element of an array.
+const WildcardKey = "*"
+
+type exprKind byte
+
+const (
+	condExpr exprKind = iota
+	andExpr
+	orExpr
+	notExpr
+)
+
+type expr struct {
+	kind        exprKind
+	cond        Instruction // condition of condExpr, its Kind is set when compiled
+	left, right *expr       // notExpr has only left operand
+}
+
+// compile appends instructions combining value of e with the top of the stack using kind,
+// or pushing it as a new top when kind is Push.
+func (e *expr) compile(instructions []Instruction, kind InstructionKind) []Instruction {
+	if e.kind == condExpr {
+		cond := e.cond
+		cond.Kind = kind
+		return append(instructions, cond)
+	}
+	if e.kind == notExpr {
+		instructions = e.left.compile(instructions, Push)
+		instructions = append(instructions, Instruction{Kind: Not})
+		switch kind {
+		case And:
+			return append(instructions, Instruction{Kind: AndPop})
+		case Or:
+			return append(instructions, Instruction{Kind: OrPop})
+		}
+		return instructions
+	}
+
+	// AND and OR are associative, so operands of the same operator are folded into the top directly
+	exprCmd, popCmd := And, AndPop
+	if e.kind == orExpr {
+		exprCmd, popCmd = Or, OrPop
+	}
+	if kind == Push || kind == exprCmd {
+		instructions = e.left.compile(instructions, kind)
+		if kind == Push && e.right.kind != condExpr {
+			instructions = e.right.compile(instructions, Push)
+			return append(instructions, Instruction{Kind: popCmd})
+		}
+		return e.right.compile(instructions, exprCmd)
+	}
+
+	instructions = e.compile(instructions, Push)
+	if kind == And {
+		return append(instructions, Instruction{Kind: AndPop})
+	}
+	return append(instructions, Instruction{Kind: OrPop})
 }
 
 // fmt.Println(queryForNullRefs(index, &nullQuery{"/now null behaves"}))
@@ -215,72 +561,403 @@ type Program struct {
 // }
 
 func Parse(query string) (Program, error) {
-	readSelection := func(tokens []token) (int, int) {
-		// TODO implement selection
-		if tokens[0].kind != select_ {
-			return 0, 0
-		}
-		for i := 1; i < len(tokens); i++ {
-			if tokens[i].kind == from {
-				return 0, i + 1
-			}
-		}
-
-		return 0, 0
+	var positions []int
+	syntaxError := func(i int, msg string) error {
+		return &SyntaxError{positions[i], msg}
 	}
+
 	readContainerAlias := func(tokens []token, i int) (string, int) {
 		if tokens[i].kind == ident {
 			return tokens[i].value.(string), i + 1
 		}
 		return "", i
 	}
-	readWhereClause := func(tokens []token, i int, containerAlias string) ([]Instruction, int) {
-		if tokens[i].kind != where {
-			return nil, i
+	isValue := func(t token) bool {
+		return t.kind == text || t.kind == float || t.kind == integer || t.kind == boolean || t.kind == null
+	}
+	readSubscript := func(tokens []token, i int, wildcard bool) (string, int, error) {
+		// level of `[1]`, `["name"]`, `['name']` or `[*]`, tokens[i] is '['
+		level := ""
+		switch t := tokens[i+1]; t.kind {
+		case star:
+			if !wildcard {
+				return "", i + 1, syntaxError(i+1, "Unexpected [*] outside of WHERE clause")
+			}
+			level = WildcardKey
+		case integer:
+			if t.value.(int64) < 0 {
+				return "", i + 1, syntaxError(i+1, "Expected non-negative array index")
+			}
+			level = strconv.FormatInt(t.value.(int64), 10)
+		case quoted, text:
+			level = t.value.(string)
+		default:
+			return "", i + 1, syntaxError(i+1, "Expected array index or quoted property name")
 		}
-
-		clauses := make([]Instruction, 0, 4)
+		if tokens[i+2].kind != rsqbrack {
+			return "", i + 2, syntaxError(i+2, "Expected ]")
+		}
+		return level, i + 3, nil
+	}
+	readKey := func(tokens []token, i int, containerAlias string, wildcard bool) (string, int, error) {
+		// returns "" when tokens[i] does not start a key
 		levelSep := "/"
 		keyBuilder := ""
-		op := Eq
-		cmd := Push
-		for i++; i < len(tokens); i++ {
-			if tokens[i] == (token{ident, containerAlias}) {
+		if tokens[i] == (token{ident, containerAlias}) && (tokens[i+1].kind == dot || tokens[i+1].kind == lsqbrack) {
+			i++
+		} else if tokens[i].kind == ident {
+			keyBuilder, i = levelSep+tokens[i].value.(string), i+1
+		} else {
+			return "", i, nil
+		}
+		for {
+			switch tokens[i].kind {
+			case dot:
+				if tokens[i+1].kind != ident {
+					return "", i + 1, syntaxError(i+1, "Expected property name after .")
+				}
+				keyBuilder, i = keyBuilder+levelSep+tokens[i+1].value.(string), i+2
+			case lsqbrack:
+				level, next, err := readSubscript(tokens, i, wildcard)
+				if err != nil {
+					return "", next, err
+				}
+				keyBuilder, i = keyBuilder+levelSep+level, next
+			default:
+				return keyBuilder, i, nil
+			}
+		}
+	}
+	readSelection := func(tokens []token) ([]string, int, error) {
+		// returns keys of SELECT list, nil for SELECT *, and position after FROM or 0 when there is none
+		fromIdx := slices.IndexFunc(tokens, func(t token) bool { return t.kind == from })
+		if tokens[0].kind != select_ || fromIdx < 0 {
+			return nil, 0, nil
+		}
+		if fromIdx == 1 || (fromIdx == 2 && tokens[1].kind == star) {
+			return nil, fromIdx + 1, nil
+		}
+		containerAlias, _ := readContainerAlias(tokens, fromIdx+1)
+
+		selection := make([]string, 0, 4)
+		for i := 1; ; i++ {
+			key, next, err := readKey(tokens, i, containerAlias, false)
+			if err != nil {
+				return nil, 0, err
+			}
+			if key == "" {
+				return nil, 0, syntaxError(next, "Expected property in SELECT list")
+			}
+			selection = append(selection, key)
+			if next == fromIdx {
+				return selection, fromIdx + 1, nil
+			}
+			if tokens[next].kind != comma {
+				return nil, 0, syntaxError(next, "Expected , or FROM")
+			}
+			i = next
+		}
+	}
+	readWhereClause := func(tokens []token, i int, containerAlias string) ([]Instruction, int, error) {
+		if tokens[i].kind == eof {
+			return nil, i, nil
+		}
+		if tokens[i].kind != where {
+			return nil, i, syntaxError(i, "Expected WHERE")
+		}
+		if tokens[i+1].kind == eof {
+			return nil, i, syntaxError(i, "Expected condition after WHERE")
+		}
+
+		readValue := func(i int, key string) (interface{}, int, error) {
+			if !isValue(tokens[i]) {
+				return nil, i, syntaxError(i, fmt.Sprintf("Incomplete condition on %q", key))
+			}
+			return tokens[i].value, i + 1, nil
+		}
+		readInCondition := func(i int) (*expr, int, error) {
+			// value IN key
+			val := tokens[i].value
+			if tokens[i+1].kind != in {
+				return nil, i + 1, syntaxError(i+1, "Expected IN")
+			}
+			key, i, err := readKey(tokens, i+2, containerAlias, true)
+			if err != nil {
+				return nil, i, err
+			}
+			if key == "" {
+				return nil, i, syntaxError(i, "Expected array after IN")
+			}
+			return &expr{kind: condExpr, cond: Instruction{Key: key + "/" + WildcardKey, Op: Eq, Val: val}}, i, nil
+		}
+		readFunctionCondition := func(i int) (*expr, int, error) {
+			// ARRAY_CONTAINS(key, value), string function like STARTS_WITH(key, text) or MATCH(key, text)
+			function := tokens[i].kind
+			if tokens[i+1].kind != lparem {
+				return nil, i + 1, syntaxError(i+1, "Expected ( after function name")
+			}
+			key, i, err := readKey(tokens, i+2, containerAlias, true)
+			if err != nil {
+				return nil, i, err
+			}
+			if key == "" {
+				return nil, i, syntaxError(i, "Expected property as the first argument")
+			}
+			if tokens[i].kind != comma {
+				return nil, i, syntaxError(i, "Expected , between arguments")
+			}
+			if function != arrayContains && tokens[i+1].kind != text {
+				return nil, i + 1, syntaxError(i+1, "Expected string as the second argument")
+			}
+			val, i, err := readValue(i+1, key)
+			if err != nil {
+				return nil, i, err
+			}
+			if tokens[i].kind != rparem {
+				return nil, i, syntaxError(i, "Expected )")
+			}
+
+			cond := Instruction{Key: key, Val: val}
+			switch function {
+			case arrayContains:
+				cond.Key, cond.Op = key+"/"+WildcardKey, Eq
+			case startsWith:
+				cond.Op = StartsWith
+			case endsWith:
+				cond.Op = EndsWith
+			case contains:
+				cond.Op = Contains
+			case match:
+				cond.Op = Match
+			}
+			return &expr{kind: condExpr, cond: cond}, i + 1, nil
+		}
+		readCondition := func(i int) (*expr, int, error) {
+			switch tokens[i].kind {
+			case arrayContains, startsWith, endsWith, contains, match:
+				return readFunctionCondition(i)
+			}
+			if isValue(tokens[i]) {
+				return readInCondition(i)
+			}
+			// LOWER(key) and UPPER(key) wrap compared values into CaseValue
+			caseFunc := CaseFunc(0)
+			if tokens[i].kind == lower || tokens[i].kind == upper {
+				caseFunc = Lower
+				if tokens[i].kind == upper {
+					caseFunc = Upper
+				}
+				if tokens[i+1].kind != lparem {
+					return nil, i + 1, syntaxError(i+1, "Expected ( after function name")
+				}
+				i += 2
+			}
+			key, i, err := readKey(tokens, i, containerAlias, true)
+			if err != nil {
+				return nil, i, err
+			}
+			if key == "" {
+				return nil, i, syntaxError(i, "Expected condition")
+			}
+			if caseFunc != 0 {
+				if tokens[i].kind != rparem {
+					return nil, i, syntaxError(i, "Expected )")
+				}
 				i++
 			}
-			if tokens[i].kind == dot {
-				keyBuilder += levelSep
+			readOperand := func(i int) (interface{}, int, error) {
+				if caseFunc != 0 && tokens[i].kind != text {
+					return nil, i, syntaxError(i, "Expected string compared to LOWER or UPPER")
+				}
+				return readValue(i, key)
 			}
-			if tokens[i].kind == ident {
-				keyBuilder += tokens[i].value.(string)
+			condition := func(op OpType, val interface{}) *expr {
+				if caseFunc != 0 {
+					val = CaseValue{caseFunc, val}
+				}
+				return &expr{kind: condExpr, cond: Instruction{Key: key, Op: op, Val: val}}
 			}
-			if tokens[i].kind == eq {
-				op = Eq
+
+			ops := map[tokenKind]OpType{eq: Eq, gt: Gt, lt: Lt, ge: Ge, le: Le, ne: Ne}
+			switch kind := tokens[i].kind; kind {
+			case eq, gt, lt, ge, le, ne:
+				val, i, err := readOperand(i + 1)
+				return condition(ops[kind], val), i, err
+			case between:
+				from, i, err := readOperand(i + 1)
+				if err != nil {
+					return nil, i, err
+				}
+				if tokens[i].kind != and {
+					return nil, i, syntaxError(i, "Expected AND in BETWEEN condition")
+				}
+				toPos := i + 1
+				to, i, err := readOperand(toPos)
+				if err != nil {
+					return nil, i, err
+				}
+				isNumber := func(val interface{}) bool {
+					switch val.(type) {
+					case float64, int64:
+						return true
+					}
+					return false
+				}
+				_, fromText := from.(string)
+				_, toText := to.(string)
+				if !(fromText && toText) && !(isNumber(from) && isNumber(to)) {
+					return nil, toPos, syntaxError(toPos, "Expected BETWEEN bounds to be both numbers or both strings")
+				}
+				return condition(Between, Range{from, to}), i, nil
+			case like:
+				if tokens[i+1].kind != text {
+					return nil, i + 1, syntaxError(i+1, "Expected string pattern after LIKE")
+				}
+				return condition(Like, tokens[i+1].value), i + 2, nil
+			case is:
+				if caseFunc != 0 {
+					return nil, i, syntaxError(i, "Expected comparison after LOWER or UPPER")
+				}
+				// only IS NULL and IS NOT NULL are supported
+				isOp, i := Is, i+1
+				if tokens[i].kind == not {
+					isOp, i = IsNot, i+1
+				}
+				if tokens[i].kind != null {
+					return nil, i, syntaxError(i, "Expected NULL")
+				}
+				return &expr{kind: condExpr, cond: Instruction{Key: key, Op: isOp}}, i + 1, nil
 			}
-			if tokens[i].kind == gt {
-				op = Gt
+			return nil, i, syntaxError(i, fmt.Sprintf("Incomplete condition on %q", key))
+		}
+
+		var readOr func(i int) (*expr, int, error)
+		var readPrimary func(i int) (*expr, int, error)
+		readPrimary = func(i int) (*expr, int, error) {
+			if tokens[i].kind == not {
+				operand, i, err := readPrimary(i + 1)
+				return &expr{kind: notExpr, left: operand}, i, err
+			}
+			if tokens[i].kind != lparem {
+				return readCondition(i)
 			}
-			if tokens[i].kind == text || tokens[i].kind == float {
-				clauses = append(clauses, Instruction{cmd, keyBuilder, op, tokens[i].value})
-				keyBuilder = ""
+			e, i, err := readOr(i + 1)
+			if err != nil {
+				return nil, i, err
 			}
-			if tokens[i].kind == and {
-				cmd = And
+			if tokens[i].kind != rparem {
+				return nil, i, syntaxError(i, "Expected )")
 			}
-			if tokens[i].kind == or {
-				cmd = Or
+			return e, i + 1, nil
+		}
+		readBinary := func(i int, opToken tokenKind, kind exprKind, readOperand func(int) (*expr, int, error)) (*expr, int, error) {
+			left, i, err := readOperand(i)
+			for err == nil && tokens[i].kind == opToken {
+				var right *expr
+				right, i, err = readOperand(i + 1)
+				left = &expr{kind: kind, left: left, right: right}
 			}
+			return left, i, err
+		}
+		readAnd := func(i int) (*expr, int, error) {
+			return readBinary(i, and, andExpr, readPrimary)
+		}
+		readOr = func(i int) (*expr, int, error) {
+			return readBinary(i, or, orExpr, readAnd)
 		}
-		return clauses, i
+
+		e, i, err := readOr(i + 1)
+		if err != nil {
+			return nil, i, err
+		}
+		if tokens[i].kind != eof {
+			return nil, i, syntaxError(i, "Unexpected token after WHERE clause")
+		}
+		return e.compile(make([]Instruction, 0, 4), Push), i, nil
 	}
 
-	tokens, err := tokenize(query)
+	readSetClause := func(tokens []token, i int, containerAlias string) ([]Assignment, int, error) {
+		if tokens[i].kind != set_ {
+			return nil, i, syntaxError(i, "Expected SET")
+		}
+
+		assignments := make([]Assignment, 0, 4)
+		for {
+			key, next, err := readKey(tokens, i+1, containerAlias, false)
+			if err != nil {
+				return nil, next, err
+			}
+			if key == "" {
+				return nil, next, syntaxError(next, "Expected <key> = <value> after SET")
+			}
+			if tokens[next].kind != eq || !isValue(tokens[next+1]) {
+				return nil, next, syntaxError(next, fmt.Sprintf("Incomplete assignment to %q", key))
+			}
+			assignments = append(assignments, Assignment{key, tokens[next+1].value})
+			if i = next + 2; tokens[i].kind != comma {
+				return assignments, i, nil
+			}
+		}
+	}
+
+	tokens, positions, err := tokenizeWithPositions(query)
 	if err != nil {
 		return Program{Instructions: nil}, err
 	}
-	_, i := readSelection(tokens) // TODO
+
+	switch tokens[0].kind {
+	case insert:
+		// INSERT INTO c VALUES '{"key": "value"}'
+		expected := []tokenKind{insert, into, ident, values, text}
+		for i, kind := range expected {
+			if i >= len(tokens) || tokens[i].kind != kind {
+				return Program{}, syntaxError(min(i, len(tokens)-1), "Expected INSERT INTO <alias> VALUES '<json>'")
+			}
+		}
+		if tokens[len(expected)].kind != eof {
+			return Program{}, syntaxError(len(expected), "Unexpected token after INSERT statement")
+		}
+		return Program{Kind: Insert, Document: tokens[4].value.(string)}, nil
+	case update:
+		// UPDATE c SET c.key = value, ... WHERE ...
+		containerAlias, i := readContainerAlias(tokens, 1)
+		assignments, i, err := readSetClause(tokens, i, containerAlias)
+		if err != nil {
+			return Program{}, err
+		}
+		instructions, i, err := readWhereClause(tokens, i, containerAlias)
+		if err != nil {
+			return Program{}, err
+		}
+		return Program{Kind: Update, Instructions: instructions, Assignments: assignments}, nil
+	case delete_:
+		// DELETE FROM c WHERE ...
+		if tokens[1].kind != from {
+			return Program{}, syntaxError(1, "Expected DELETE FROM <alias>")
+		}
+		containerAlias, i := readContainerAlias(tokens, 2)
+		instructions, i, err := readWhereClause(tokens, i, containerAlias)
+		if err != nil {
+			return Program{}, err
+		}
+		return Program{Kind: Delete, Instructions: instructions}, nil
+	case select_:
+	default:
+		return Program{}, syntaxError(0, "Expected SELECT, INSERT, UPDATE or DELETE")
+	}
+
+	selection, i, err := readSelection(tokens)
+	if err != nil {
+		return Program{}, err
+	}
+	if i == 0 {
+		return Program{}, syntaxError(len(tokens)-1, "Expected FROM")
+	}
 	containerAlias, i := readContainerAlias(tokens, i)
-	instructions, i := readWhereClause(tokens, i, containerAlias)
+	instructions, i, err := readWhereClause(tokens, i, containerAlias)
+	if err != nil {
+		return Program{}, err
+	}
 
-	return Program{Instructions: instructions}, nil
+	return Program{Kind: Select, Instructions: instructions, Selection: selection}, nil
 }
@@ -1,6 +1,9 @@
 package parser
 
 import (
+	"math"
+	"regexp"
+	"strconv"
 	"testing"
 )
 
@@ -89,13 +92,237 @@ func TestTokenizeSimpleQueryWithOr(t *testing.T) {
 		{dot, nil},
 		{ident, "age"},
 		{eq, nil},
-		{float, float64(23)},
+		{integer, int64(23)},
 		{or, nil},
 		{ident, "c"},
 		{dot, nil},
 		{ident, "age"},
 		{eq, nil},
-		{float, float64(17)},
+		{integer, int64(17)},
+		{eof, nil},
+	}
+
+	if !compareTokens(tokens, expected) {
+		t.Fatalf("Got tokens different than expected:\n%v\n%v", tokens, expected)
+	}
+}
+
+// Tokenizer: Check if numbers without a dot will be tokenized as integers, unless they overflow int64.
+func TestTokenizeIntegers(t *testing.T) {
+	query := "SELECT * FROM c WHERE c.id = 9007199254740993 OR c.n = 2.0 OR c.n = 9223372036854775808"
+
+	tokens, _ := tokenize(query)
+	expected := []token{
+		{select_, nil},
+		{star, nil},
+		{from, nil},
+		{ident, "c"},
+		{where, nil},
+		{ident, "c"},
+		{dot, nil},
+		{ident, "id"},
+		{eq, nil},
+		{integer, int64(9007199254740993)},
+		{or, nil},
+		{ident, "c"},
+		{dot, nil},
+		{ident, "n"},
+		{eq, nil},
+		{float, 2.0},
+		{or, nil},
+		{ident, "c"},
+		{dot, nil},
+		{ident, "n"},
+		{eq, nil},
+		{float, 9223372036854775808.0},
+		{eof, nil},
+	}
+
+	if !compareTokens(tokens, expected) {
+		t.Fatalf("Got tokens different than expected:\n%v\n%v", tokens, expected)
+	}
+}
+
+// Tokenizer: Check if signed, exponent, leading dot, hexadecimal and underscored numbers will be tokenized correctly.
+func TestTokenizeNumbers(t *testing.T) {
+	assert := func(literal string, expected token) {
+		tokens, err := tokenize("c.n = " + literal)
+		if err != nil {
+			t.Fatalf("Got unexpected error for %s: %v", literal, err)
+		}
+		if len(tokens) != 6 || tokens[4] != expected {
+			t.Fatalf("Got tokens for %s different than expected:\n%v\n%v", literal, tokens, expected)
+		}
+	}
+	assert("-17", token{integer, int64(-17)})
+	assert("+17", token{integer, int64(17)})
+	assert("-0", token{integer, int64(0)})
+	assert(".5", token{float, 0.5})
+	assert("-.5", token{float, -0.5})
+	assert("1.5e3", token{float, 1500.0})
+	assert("1E-3", token{float, 0.001})
+	assert("2e+2", token{float, 200.0})
+	assert("1_000_000", token{integer, int64(1000000)})
+	assert("0.000_1", token{float, 0.0001})
+	assert("0x1F", token{integer, int64(31)})
+	assert("-0XfF_fF", token{integer, int64(-65535)})
+	assert("-9223372036854775808", token{integer, int64(math.MinInt64)})
+	assert("-9223372036854775809", token{float, -9223372036854775809.0})
+}
+
+// Tokenizer: Check if decimal numbers will be tokenized to values of strconv.ParseFloat
+// and any other input fails, if at all, with SyntaxError positioned within the query.
+func FuzzTokenizeNumber(f *testing.F) {
+	for _, seed := range []string{
+		"0", "-17", "+3", "1.5", ".5", "-.5e-3", "1E+2", "1e400", "1e-400", "9223372036854775808",
+		"1_000", "1__0", "0x1F", "0x", "1.2.3", "1.", "1e+", "12abc", "-", "- 1", "0.1.", "00012",
+	} {
+		f.Add(seed)
+	}
+	decimal := regexp.MustCompile(`^[+-]?([0-9]+(\.[0-9]+)?|\.[0-9]+)([eE][+-]?[0-9]+)?$`)
+	const prefix = "c.n = "
+
+	f.Fuzz(func(t *testing.T, literal string) {
+		tokens, err := tokenize(prefix + literal)
+		if err != nil {
+			syntaxErr, ok := err.(*SyntaxError)
+			if !ok || syntaxErr.Pos < 0 || syntaxErr.Pos > len(prefix)+len(literal) {
+				t.Fatalf("Expected SyntaxError within query for %q, got %v", literal, err)
+			}
+		}
+		if !decimal.MatchString(literal) {
+			return
+		}
+
+		expected, parseErr := strconv.ParseFloat(literal, 64)
+		if parseErr != nil {
+			if err == nil {
+				t.Fatalf("Expected error for %q out of range, got %v", literal, tokens)
+			}
+			return
+		}
+		if err != nil || len(tokens) != 6 {
+			t.Fatalf("Expected a single number for %q, got %v %v", literal, tokens, err)
+		}
+		actual := 0.0
+		switch v := tokens[4].value.(type) {
+		case int64:
+			if n, err := strconv.ParseInt(literal, 10, 64); err != nil || n != v {
+				t.Fatalf("Expected integer %q to be tokenized exactly, got %d", literal, v)
+			}
+			actual = float64(v)
+		case float64:
+			actual = v
+		default:
+			t.Fatalf("Expected number for %q, got %v", literal, tokens[4])
+		}
+		if actual != expected {
+			t.Fatalf("Expected %q to be tokenized as %v, got %v", literal, expected, actual)
+		}
+	})
+}
+
+// Tokenizer: Check if range query will be tokenized correctly.
+func TestTokenizeRangeQuery(t *testing.T) {
+	query := "SELECT * FROM c WHERE c.age >= 17 AND c.age <= 23 OR c.age BETWEEN 30 AND 40"
+
+	tokens, _ := tokenize(query)
+	expected := []token{
+		{select_, nil},
+		{star, nil},
+		{from, nil},
+		{ident, "c"},
+		{where, nil},
+		{ident, "c"},
+		{dot, nil},
+		{ident, "age"},
+		{ge, nil},
+		{integer, int64(17)},
+		{and, nil},
+		{ident, "c"},
+		{dot, nil},
+		{ident, "age"},
+		{le, nil},
+		{integer, int64(23)},
+		{or, nil},
+		{ident, "c"},
+		{dot, nil},
+		{ident, "age"},
+		{between, nil},
+		{integer, int64(30)},
+		{and, nil},
+		{integer, int64(40)},
+		{eof, nil},
+	}
+
+	if !compareTokens(tokens, expected) {
+		t.Fatalf("Got tokens different than expected:\n%v\n%v", tokens, expected)
+	}
+}
+
+// Tokenizer: Check if boolean and null literals will be tokenized correctly.
+func TestTokenizeLiterals(t *testing.T) {
+	query := "SELECT * FROM c WHERE c.active = TRUE OR c.active = FALSE AND c.name IS NOT NULL"
+
+	tokens, _ := tokenize(query)
+	expected := []token{
+		{select_, nil},
+		{star, nil},
+		{from, nil},
+		{ident, "c"},
+		{where, nil},
+		{ident, "c"},
+		{dot, nil},
+		{ident, "active"},
+		{eq, nil},
+		{boolean, true},
+		{or, nil},
+		{ident, "c"},
+		{dot, nil},
+		{ident, "active"},
+		{eq, nil},
+		{boolean, false},
+		{and, nil},
+		{ident, "c"},
+		{dot, nil},
+		{ident, "name"},
+		{is, nil},
+		{not, nil},
+		{null, nil},
+		{eof, nil},
+	}
+
+	if !compareTokens(tokens, expected) {
+		t.Fatalf("Got tokens different than expected:\n%v\n%v", tokens, expected)
+	}
+}
+
+// Tokenizer: Check if doubled quotes inside string literals and quoted names stand for the quote itself.
+func TestTokenizeEscapedQuotes(t *testing.T) {
+	query := `SELECT * FROM c WHERE c["say ""hi"""] = 'it''s' OR c.name = ''''`
+
+	tokens, err := tokenize(query)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	expected := []token{
+		{select_, nil},
+		{star, nil},
+		{from, nil},
+		{ident, "c"},
+		{where, nil},
+		{ident, "c"},
+		{lsqbrack, nil},
+		{quoted, `say "hi"`},
+		{rsqbrack, nil},
+		{eq, nil},
+		{text, "it's"},
+		{or, nil},
+		{ident, "c"},
+		{dot, nil},
+		{ident, "name"},
+		{eq, nil},
+		{text, "'"},
 		{eof, nil},
 	}
 
@@ -116,12 +343,48 @@ func comparePrograms(actual, expected Program) bool {
 	return true
 }
 
+// Tokenizer: Check if array conditions will be tokenized correctly.
+func TestTokenizeArrayConditions(t *testing.T) {
+	query := "SELECT * FROM c WHERE 'go' IN c.tags OR ARRAY_CONTAINS(c.tags[*], 'go')"
+
+	tokens, _ := tokenize(query)
+	expected := []token{
+		{select_, nil},
+		{star, nil},
+		{from, nil},
+		{ident, "c"},
+		{where, nil},
+		{text, "go"},
+		{in, nil},
+		{ident, "c"},
+		{dot, nil},
+		{ident, "tags"},
+		{or, nil},
+		{arrayContains, nil},
+		{lparem, nil},
+		{ident, "c"},
+		{dot, nil},
+		{ident, "tags"},
+		{lsqbrack, nil},
+		{star, nil},
+		{rsqbrack, nil},
+		{comma, nil},
+		{text, "go"},
+		{rparem, nil},
+		{eof, nil},
+	}
+
+	if !compareTokens(tokens, expected) {
+		t.Fatalf("Got tokens different than expected:\n%v\n%v", tokens, expected)
+	}
+}
+
 // Parse: Check if simple query will be parsed correctly.
 func TestParseSimple(t *testing.T) {
 	query := "SELECT * FROM c WHERE c.social.twitter = 'https://twitter.com'"
 
 	program, _ := Parse(query)
-	expected := Program{[]Instruction{
+	expected := Program{Instructions: []Instruction{
 		{Push, "/social/twitter", Eq, "https://twitter.com"},
 	}}
 
@@ -136,8 +399,8 @@ func TestParseSimpleWithOr(t *testing.T) {
 
 	program, _ := Parse(query)
 	expected := Program{Instructions: []Instruction{
-		{Push, "/age", Eq, float64(23)},
-		{Or, "/age", Eq, float64(17)},
+		{Push, "/age", Eq, int64(23)},
+		{Or, "/age", Eq, int64(17)},
 	}}
 
 	if !comparePrograms(program, expected) {
@@ -159,3 +422,396 @@ func TestParseSimpleWithAnd(t *testing.T) {
 		t.Fatalf("Got programs different than expected:\n%v\n%v", program, expected)
 	}
 }
+
+// Parse: Check if AND binds tighter than OR.
+func TestParsePrecedence(t *testing.T) {
+	query := "SELECT * FROM c WHERE c.age = 23 OR c.age = 17 AND c.type = 'Author'"
+
+	program, err := Parse(query)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	expected := Program{Instructions: []Instruction{
+		{Push, "/age", Eq, int64(23)},
+		{Push, "/age", Eq, int64(17)},
+		{And, "/type", Eq, "Author"},
+		{Kind: OrPop},
+	}}
+
+	if !comparePrograms(program, expected) {
+		t.Fatalf("Got programs different than expected:\n%v\n%v", program, expected)
+	}
+}
+
+// Parse: Check if parenthesized groups will be parsed correctly.
+func TestParseParentheses(t *testing.T) {
+	query := "SELECT * FROM c WHERE (c.age = 23 OR c.age = 17) AND (c.type = 'Author' OR (c.active = TRUE AND c.name = 'Elliot'))"
+
+	program, err := Parse(query)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	expected := Program{Instructions: []Instruction{
+		{Push, "/age", Eq, int64(23)},
+		{Or, "/age", Eq, int64(17)},
+		{Push, "/type", Eq, "Author"},
+		{Push, "/active", Eq, true},
+		{And, "/name", Eq, "Elliot"},
+		{Kind: OrPop},
+		{Kind: AndPop},
+	}}
+
+	if !comparePrograms(program, expected) {
+		t.Fatalf("Got programs different than expected:\n%v\n%v", program, expected)
+	}
+
+	program, err = Parse("SELECT * FROM c WHERE ((c.age = 23))")
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	expected = Program{Instructions: []Instruction{{Push, "/age", Eq, int64(23)}}}
+	if !comparePrograms(program, expected) {
+		t.Fatalf("Got programs different than expected:\n%v\n%v", program, expected)
+	}
+}
+
+// Parse: Check if NOT, != and <> will be parsed correctly.
+func TestParseNot(t *testing.T) {
+	assert := func(query string, expected Program) {
+		program, err := Parse(query)
+		if err != nil {
+			t.Fatalf("Got unexpected error: %v", err)
+		}
+		if !comparePrograms(program, expected) {
+			t.Fatalf("Got programs different than expected:\n%v\n%v", program, expected)
+		}
+	}
+
+	assert("SELECT * FROM c WHERE c.type != 'Reader'", Program{Instructions: []Instruction{
+		{Push, "/type", Ne, "Reader"},
+	}})
+	assert("SELECT * FROM c WHERE c.type <> 'Reader' OR c.age > 20", Program{Instructions: []Instruction{
+		{Push, "/type", Ne, "Reader"},
+		{Or, "/age", Gt, int64(20)},
+	}})
+	assert("SELECT * FROM c WHERE NOT (c.age > 30)", Program{Instructions: []Instruction{
+		{Push, "/age", Gt, int64(30)},
+		{Kind: Not},
+	}})
+	assert("SELECT * FROM c WHERE c.name = 'Elliot' AND NOT c.age > 30 OR NOT NOT c.active = TRUE", Program{Instructions: []Instruction{
+		{Push, "/name", Eq, "Elliot"},
+		{Push, "/age", Gt, int64(30)},
+		{Kind: Not},
+		{Kind: AndPop},
+		{Push, "/active", Eq, true},
+		{Kind: Not},
+		{Kind: Not},
+		{Kind: OrPop},
+	}})
+}
+
+// Parse: Check if range operators will be parsed correctly.
+func TestParseRange(t *testing.T) {
+	query := "SELECT * FROM c WHERE c.age > 17 AND c.age < 23 OR c.age >= 30 OR c.age <= 15"
+
+	program, _ := Parse(query)
+	expected := Program{Instructions: []Instruction{
+		{Push, "/age", Gt, int64(17)},
+		{And, "/age", Lt, int64(23)},
+		{Or, "/age", Ge, int64(30)},
+		{Or, "/age", Le, int64(15)},
+	}}
+
+	if !comparePrograms(program, expected) {
+		t.Fatalf("Got programs different than expected:\n%v\n%v", program, expected)
+	}
+}
+
+// Parse: Check if BETWEEN clause will be parsed correctly.
+func TestParseBetween(t *testing.T) {
+	query := "SELECT * FROM c WHERE c.name BETWEEN 'A' AND 'F' AND c.age BETWEEN 17 AND 23"
+
+	program, _ := Parse(query)
+	expected := Program{Instructions: []Instruction{
+		{Push, "/name", Between, Range{"A", "F"}},
+		{And, "/age", Between, Range{int64(17), int64(23)}},
+	}}
+
+	if !comparePrograms(program, expected) {
+		t.Fatalf("Got programs different than expected:\n%v\n%v", program, expected)
+	}
+}
+
+// Parse: Check if IN, ARRAY_CONTAINS and [*] conditions will be parsed onto wildcard keys.
+func TestParseArrayConditions(t *testing.T) {
+	query := "SELECT * FROM c WHERE 'go' IN c.tags AND (ARRAY_CONTAINS(c.langs, 'en') OR c.people[*].age > 17) AND NOT 3 IN c.arr"
+
+	program, _ := Parse(query)
+	expected := Program{Instructions: []Instruction{
+		{Push, "/tags/*", Eq, "go"},
+		{Push, "/langs/*", Eq, "en"},
+		{Or, "/people/*/age", Gt, int64(17)},
+		{AndPop, "", 0, nil},
+		{Push, "/arr/*", Eq, int64(3)},
+		{Not, "", 0, nil},
+		{AndPop, "", 0, nil},
+	}}
+
+	if !comparePrograms(program, expected) {
+		t.Fatalf("Got programs different than expected:\n%v\n%v", program, expected)
+	}
+}
+
+// Parse: Check if LIKE and string functions will be parsed correctly.
+func TestParseStringMatching(t *testing.T) {
+	query := "SELECT * FROM c WHERE c.social.twitter LIKE 'https://%' AND (STARTS_WITH(c.name, 'El') OR ENDS_WITH(c.name, 'er')) AND NOT CONTAINS(c.tags[*], 'x')"
+
+	program, err := Parse(query)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	expected := Program{Instructions: []Instruction{
+		{Push, "/social/twitter", Like, "https://%"},
+		{Push, "/name", StartsWith, "El"},
+		{Or, "/name", EndsWith, "er"},
+		{AndPop, "", 0, nil},
+		{Push, "/tags/*", Contains, "x"},
+		{Not, "", 0, nil},
+		{AndPop, "", 0, nil},
+	}}
+
+	if !comparePrograms(program, expected) {
+		t.Fatalf("Got programs different than expected:\n%v\n%v", program, expected)
+	}
+}
+
+// Parse: Check if MATCH conditions take the text to search for and combine with other conditions.
+func TestParseMatch(t *testing.T) {
+	query := "SELECT * FROM c WHERE MATCH(c.description, 'fast database') AND c.year > 2020 OR MATCH(c.tags[*], 'go')"
+
+	program, err := Parse(query)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	expected := Program{Instructions: []Instruction{
+		{Push, "/description", Match, "fast database"},
+		{And, "/year", Gt, int64(2020)},
+		{Or, "/tags/*", Match, "go"},
+	}}
+
+	if !comparePrograms(program, expected) {
+		t.Fatalf("Got programs different than expected:\n%v\n%v", program, expected)
+	}
+
+	if _, err := Parse("SELECT * FROM c WHERE MATCH(c.description, 3)"); err == nil {
+		t.Fatalf("Expected error for MATCH of a number")
+	}
+}
+
+// Parse: Check if conditions on LOWER and UPPER of a key wrap their values into CaseValue.
+func TestParseCaseFunctions(t *testing.T) {
+	query := "SELECT * FROM c WHERE LOWER(c.name) = 'elliot' OR UPPER(c.type) BETWEEN 'A' AND 'M' OR LOWER(c['social'].twitter) LIKE '%x%'"
+
+	program, err := Parse(query)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	expected := Program{Instructions: []Instruction{
+		{Push, "/name", Eq, CaseValue{Lower, "elliot"}},
+		{Or, "/type", Between, CaseValue{Upper, Range{"A", "M"}}},
+		{Or, "/social/twitter", Like, CaseValue{Lower, "%x%"}},
+	}}
+
+	if !comparePrograms(program, expected) {
+		t.Fatalf("Got programs different than expected:\n%v\n%v", program, expected)
+	}
+}
+
+// Parse: Check if subscripts and quoted property names will be parsed onto flattened keys.
+func TestParseSubscripts(t *testing.T) {
+	query := "SELECT * FROM c WHERE c.arr[1] = 3 AND c[\"now null behaves\"] IS NULL AND c['social'].twitter[0][\"x.y\"] = 'a'"
+
+	program, err := Parse(query)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	expected := Program{Instructions: []Instruction{
+		{Push, "/arr/1", Eq, int64(3)},
+		{And, "/now null behaves", Is, nil},
+		{And, "/social/twitter/0/x.y", Eq, "a"},
+	}}
+
+	if !comparePrograms(program, expected) {
+		t.Fatalf("Got programs different than expected:\n%v\n%v", program, expected)
+	}
+}
+
+// Parse: Check if boolean literals and IS [NOT] NULL predicates will be parsed correctly.
+func TestParseBoolAndNull(t *testing.T) {
+	query := "SELECT * FROM c WHERE c.active = TRUE AND c.name IS NOT NULL OR c.type IS NULL"
+
+	program, _ := Parse(query)
+	expected := Program{Instructions: []Instruction{
+		{Push, "/active", Eq, true},
+		{And, "/name", IsNot, nil},
+		{Or, "/type", Is, nil},
+	}}
+
+	if !comparePrograms(program, expected) {
+		t.Fatalf("Got programs different than expected:\n%v\n%v", program, expected)
+	}
+}
+
+// Parse: Check if INSERT statement will be parsed correctly.
+func TestParseInsert(t *testing.T) {
+	query := `INSERT INTO c VALUES '{"name": "Ann", "age": 31}'`
+
+	program, err := Parse(query)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if program.Kind != Insert || program.Document != `{"name": "Ann", "age": 31}` {
+		t.Fatalf("Got program different than expected:\n%v", program)
+	}
+
+	if _, err := Parse("INSERT INTO c '{}'"); err == nil {
+		t.Fatalf("Expected error for INSERT without VALUES")
+	}
+}
+
+// Parse: Check if UPDATE statement will be parsed correctly.
+func TestParseUpdate(t *testing.T) {
+	query := "UPDATE c SET c.social.twitter = 'https://x.com', c.age = 31 WHERE c.name = 'Elliot'"
+
+	program, err := Parse(query)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	expected := Program{Instructions: []Instruction{
+		{Push, "/name", Eq, "Elliot"},
+	}}
+	expectedAssignments := []Assignment{
+		{"/social/twitter", "https://x.com"},
+		{"/age", int64(31)},
+	}
+
+	if program.Kind != Update || !comparePrograms(program, expected) {
+		t.Fatalf("Got programs different than expected:\n%v\n%v", program, expected)
+	}
+	if len(program.Assignments) != len(expectedAssignments) {
+		t.Fatalf("Got assignments different than expected:\n%v\n%v", program.Assignments, expectedAssignments)
+	}
+	for i, a := range expectedAssignments {
+		if program.Assignments[i] != a {
+			t.Fatalf("Got assignments different than expected:\n%v\n%v", program.Assignments, expectedAssignments)
+		}
+	}
+}
+
+// Parse: Check if DELETE statement will be parsed correctly.
+func TestParseDelete(t *testing.T) {
+	query := "DELETE FROM c WHERE c.age > 30 OR c.active = FALSE"
+
+	program, err := Parse(query)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	expected := Program{Instructions: []Instruction{
+		{Push, "/age", Gt, int64(30)},
+		{Or, "/active", Eq, false},
+	}}
+
+	if program.Kind != Delete || !comparePrograms(program, expected) {
+		t.Fatalf("Got programs different than expected:\n%v\n%v", program, expected)
+	}
+}
+
+// Parse: Check if SELECT list will be parsed into selected keys.
+func TestParseSelection(t *testing.T) {
+	assert := func(query string, expected ...string) {
+		program, _ := Parse(query)
+		if len(program.Selection) != len(expected) {
+			t.Fatalf("Got selection different than expected:\n%v\n%v", program.Selection, expected)
+		}
+		for i, key := range expected {
+			if program.Selection[i] != key {
+				t.Fatalf("Got selection different than expected:\n%v\n%v", program.Selection, expected)
+			}
+		}
+	}
+
+	assert("SELECT * FROM c WHERE c.age = 23")
+	assert("SELECT c.name FROM c", "/name")
+	assert("SELECT c.name, c.social.twitter FROM c WHERE c.age = 23", "/name", "/social/twitter")
+	assert("SELECT c.arr[1], c[\"now null behaves\"], name FROM c", "/arr/1", "/now null behaves", "/name")
+}
+
+// Parse: Check if invalid queries return SyntaxError with position of the problem.
+func TestParseSyntaxErrors(t *testing.T) {
+	assert := func(query string, pos int) {
+		_, err := Parse(query)
+		syntaxErr, ok := err.(*SyntaxError)
+		if !ok {
+			t.Fatalf("Expected SyntaxError for %q, got %v", query, err)
+		}
+		if syntaxErr.Pos != pos {
+			t.Fatalf("Expected SyntaxError for %q at %d, got %v", query, pos, syntaxErr)
+		}
+	}
+
+	assert("SELECT * FROM c WHERE c.age = 1.2.3", 33)
+	assert("SELECT * FROM c WHERE c.age = 1.", 32)
+	assert("SELECT * FROM c WHERE c.age = 1e", 32)
+	assert("SELECT * FROM c WHERE c.age = 1e+", 33)
+	assert("SELECT * FROM c WHERE c.age = 1__0", 31)
+	assert("SELECT * FROM c WHERE c.age = 1_", 31)
+	assert("SELECT * FROM c WHERE c.age = 0x", 32)
+	assert("SELECT * FROM c WHERE c.age = 0xfg", 33)
+	assert("SELECT * FROM c WHERE c.age = 12abc", 32)
+	assert("SELECT * FROM c WHERE c.age = 1e400", 30)
+	assert("SELECT * FROM c WHERE c.age = 0x8000000000000000", 30)
+	assert("SELECT * FROM c WHERE c.age = - 1", 30)
+	assert("SELECT * FROM c WHERE c.arr[-1] = 1", 28)
+	assert("SELECT * FROM c WHERE c.name = 'Elliot", 31)
+	assert("SELECT * FROM c WHERE c.name = 'it''", 31)
+	assert("SELECT * FROM c WHERE c.name = @", 31)
+	assert("SELECT * FROM c WHERE c.age >", 29)
+	assert("SELECT * FROM c WHERE", 16)
+	assert("SELECT * FROM c WHERE c.age ! 1", 28)
+	assert("SELECT * FROM c WHERE NOT", 25)
+	assert("SELECT * FROM c WHERE (c.age = 1", 32)
+	assert("SELECT * FROM c WHERE c.age = 1)", 31)
+	assert("SELECT * FROM c WHERE c.age = 1 AND", 35)
+	assert("SELECT * FROM c WHERE c.age BETWEEN 1 OR 2", 38)
+	assert("SELECT * FROM c WHERE c.age BETWEEN 1 AND 'z'", 42)
+	assert("SELECT * FROM c WHERE c.name BETWEEN 'a' AND 100", 45)
+	assert("SELECT * FROM c WHERE c.age BETWEEN 1 AND TRUE", 42)
+	assert("SELECT * FROM c WHERE c.active BETWEEN TRUE AND 1", 48)
+	assert("SELECT * FROM c WHERE c.age BETWEEN NULL AND 1", 45)
+	assert("SELECT * FROM c ORDER", 16)
+	assert("SELECT *", 8)
+	assert("DROP c", 0)
+	assert("INSERT INTO c '{}'", 14)
+	assert("UPDATE c WHERE c.age = 23", 9)
+	assert("DELETE c", 7)
+	assert("SELECT * FROM c WHERE 'go' c.tags", 27)
+	assert("SELECT * FROM c WHERE 'go' IN", 29)
+	assert("SELECT * FROM c WHERE ARRAY_CONTAINS(c.tags 'go')", 44)
+	assert("SELECT * FROM c WHERE ARRAY_CONTAINS(c.tags, 'go'", 49)
+	assert("SELECT * FROM c WHERE c.tags[1.5] = 'go'", 29)
+	assert("SELECT * FROM c WHERE c.tags[c] = 'go'", 29)
+	assert("SELECT * FROM c WHERE c[\"tags\" = 'go'", 31)
+	assert("SELECT * FROM c WHERE c. = 'go'", 25)
+	assert("SELECT c.tags[*] FROM c", 14)
+	assert("SELECT c.name c.age FROM c", 14)
+	assert("UPDATE c SET c.tags[*] = 'go' WHERE c.age = 1", 20)
+	assert("UPDATE c SET c.age 1 WHERE c.age = 1", 19)
+	assert("SELECT * FROM c WHERE c.name LIKE 1", 34)
+	assert("SELECT * FROM c WHERE STARTS_WITH(c.name, 1)", 42)
+	assert("SELECT * FROM c WHERE ENDS_WITH c.name", 32)
+	assert("SELECT * FROM c WHERE CONTAINS(c.name 'x')", 38)
+	assert("SELECT * FROM c WHERE LOWER c.name = 'x'", 28)
+	assert("SELECT * FROM c WHERE LOWER(c.name = 'x'", 35)
+	assert("SELECT * FROM c WHERE UPPER(c.age) = 1", 37)
+	assert("SELECT * FROM c WHERE LOWER(c.name) IS NULL", 36)
+}